@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+	"gopkg.in/yaml.v3"
+)
+
+// configFlag is the name of the flag of the config file.
+const configFlag = "config"
+
+// redactedFlags are the flags whose values are secrets, which are not printed by the config print command.
+var redactedFlags = map[string]bool{
+	"auth-hmac-secret": true,
+}
+
+// configCommand is the command that inspects the configuration of the server.
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "inspect the configuration of the server",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "print",
+			Usage:  "print the effective configuration, merged from the config file, the environment and the flags, as YAML",
+			Action: printConfig,
+		},
+	},
+}
+
+// loadConfigFile sets the flags that are set neither on the command line nor in the environment to their values
+// in the config file given by the config flag, if any.
+func loadConfigFile(c *cli.Context) error {
+	source, err := newConfigSource(c)
+	if err != nil {
+		return err
+	}
+	return altsrc.ApplyInputSourceValues(c, source, c.App.Flags)
+}
+
+// configFileFlags returns the flags of the app that can be set by the config file.
+func configFileFlags(app *cli.App) []cli.Flag {
+	var flags []cli.Flag
+	for _, f := range app.Flags {
+		if _, ok := f.(altsrc.FlagInputSourceExtension); ok {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+// newConfigSource returns the source of the values of the flags that are set neither on the command line nor in
+// the environment, which is the config file given by the config flag. The file is YAML or TOML depending on its
+// extension, and its top-level keys are the names of the flags. Keys that are not the names of any flags are
+// rejected, so that typos don't go unnoticed. If the config flag is not set, the source has no values.
+func newConfigSource(c *cli.Context) (altsrc.InputSourceContext, error) {
+	path := c.String(configFlag)
+	if path == "" {
+		return altsrc.NewMapInputSource("", map[interface{}]interface{}{}), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("config file %s must be either .yaml, .yml or .toml, not %q", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	known := map[string]bool{}
+	for _, f := range configFileFlags(c.App) {
+		for _, name := range f.Names() {
+			known[name] = true
+		}
+	}
+	source := map[interface{}]interface{}{}
+	for key, value := range values {
+		if !known[key] {
+			return nil, fmt.Errorf("unknown key %q in config file %s", key, path)
+		}
+		// TOML integers are decoded as int64, whereas the source expects the int flags to be int
+		if i, ok := value.(int64); ok {
+			value = int(i)
+		}
+		source[key] = value
+	}
+	return altsrc.NewMapInputSource(path, source), nil
+}
+
+// printConfig prints the effective configuration as YAML, in the format of the config file, with the secrets
+// redacted. The configuration is validated first, so that the printed one is what the server would run with.
+func printConfig(c *cli.Context) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range configFileFlags(c.App) {
+		name := f.Names()[0]
+		value := c.Value(name)
+		switch v := value.(type) {
+		case time.Duration:
+			value = v.String()
+		case string:
+			if redactedFlags[name] && v != "" {
+				value = "REDACTED"
+			} else if name == "database-url" {
+				value = redactUrl(v)
+			}
+		}
+
+		var key, val yaml.Node
+		key.SetString(name)
+		if err := val.Encode(value); err != nil {
+			return err
+		}
+		doc.Content = append(doc.Content, &key, &val)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	_, err := c.App.Writer.Write(buf.Bytes())
+	return err
+}
+
+// redactUrl returns rawUrl with its password, if any, redacted. All of rawUrl is redacted if it cannot be parsed,
+// as it may still contain a password.
+func redactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "REDACTED"
+	}
+	return u.Redacted()
+}
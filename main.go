@@ -1,45 +1,186 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 	"github.com/umutozd/stats-keeper/server"
 	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
 )
 
 var config = server.NewConfig()
 
 func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		logrus.WithError(err).Fatal("server exited with error")
+	}
+}
+
+// newApp returns the cli app of the server, whose flags are set to config. The defaults of the flags are the
+// values of config when newApp is called.
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "StatsKeeper Rest API"
 	app.Flags = []cli.Flag{
-		&cli.IntFlag{
+		&cli.StringFlag{
+			Name:    configFlag,
+			EnvVars: []string{"SKEEPER_CONFIG"},
+			Usage:   "the YAML or TOML file to read the values of the other flags from, which are overridden by the environment and the flags",
+		},
+		altsrc.NewIntFlag(&cli.IntFlag{
 			Name:        "http-port",
 			Value:       config.HttpPort,
 			Destination: &config.HttpPort,
 			EnvVars:     []string{"SKEEPER_HTTP_PORT"},
 			Usage:       "the port to listen for http requests",
-		},
-		&cli.StringFlag{
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "grpc-port",
+			Value:       config.GrpcPort,
+			Destination: &config.GrpcPort,
+			EnvVars:     []string{"SKEEPER_GRPC_PORT"},
+			Usage:       "the port to listen for grpc requests, 0 disables grpc",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "database-url",
 			Destination: &config.DatabaseUrl,
 			EnvVars:     []string{"SKEEPER_DATABASE_URL"},
-			Usage:       "the url of the database server to connect to",
-		},
+			Usage:       "the url of the database to connect to, either a mongodb:// url or a file:// url for a local file",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "storage",
+			Value:       config.StorageType,
+			Destination: &config.StorageType,
+			EnvVars:     []string{"SKEEPER_STORAGE"},
+			Usage:       "where to keep the data, either 'database' or 'memory'",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "trash-retention-days",
+			Value:       config.TrashRetentionDays,
+			Destination: &config.TrashRetentionDays,
+			EnvVars:     []string{"SKEEPER_TRASH_RETENTION_DAYS"},
+			Usage:       "the number of days deleted statistics are kept before they're purged, 0 keeps them forever",
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "accounts",
+			Value:       config.Accounts,
+			Destination: &config.Accounts,
+			EnvVars:     []string{"SKEEPER_ACCOUNTS"},
+			Usage:       "enable the user accounts and require every request to be authenticated, otherwise the user_id of unauthenticated requests is trusted unless there are keys of JWTs",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "auth-hmac-secret",
+			Destination: &config.AuthHmacSecret,
+			EnvVars:     []string{"SKEEPER_AUTH_HMAC_SECRET"},
+			Usage:       "the secret of HMAC-signed bearer tokens",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "auth-ed25519-public-key-file",
+			Destination: &config.AuthEd25519PublicKeyFile,
+			EnvVars:     []string{"SKEEPER_AUTH_ED25519_PUBLIC_KEY_FILE"},
+			Usage:       "the PEM file of the public key of Ed25519-signed bearer tokens",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "auth-issuer",
+			Destination: &config.AuthIssuer,
+			EnvVars:     []string{"SKEEPER_AUTH_ISSUER"},
+			Usage:       "the issuer that bearer tokens must have, if not empty",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "auth-audience",
+			Destination: &config.AuthAudience,
+			EnvVars:     []string{"SKEEPER_AUTH_AUDIENCE"},
+			Usage:       "the audience that bearer tokens must have, if not empty",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "session-ttl",
+			Value:       config.SessionTtl,
+			Destination: &config.SessionTtl,
+			EnvVars:     []string{"SKEEPER_SESSION_TTL"},
+			Usage:       "how long the session tokens issued by logins are valid",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "max-failed-logins",
+			Value:       config.MaxFailedLogins,
+			Destination: &config.MaxFailedLogins,
+			EnvVars:     []string{"SKEEPER_MAX_FAILED_LOGINS"},
+			Usage:       "the number of consecutive failed logins after which a user is locked",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "login-lockout",
+			Value:       config.LoginLockout,
+			Destination: &config.LoginLockout,
+			EnvVars:     []string{"SKEEPER_LOGIN_LOCKOUT"},
+			Usage:       "how long a locked user cannot log in",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "shutdown-timeout",
+			Value:       config.ShutdownTimeout,
+			Destination: &config.ShutdownTimeout,
+			EnvVars:     []string{"SKEEPER_SHUTDOWN_TIMEOUT"},
+			Usage:       "how long ongoing requests are waited for when the server is shut down",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "shutdown-delay",
+			Value:       config.ShutdownDelay,
+			Destination: &config.ShutdownDelay,
+			EnvVars:     []string{"SKEEPER_SHUTDOWN_DELAY"},
+			Usage:       "how long the server keeps serving while it reports that it's not ready before it's shut down by a signal",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "log-level",
+			Value:       config.LogLevel,
+			Destination: &config.LogLevel,
+			EnvVars:     []string{"SKEEPER_LOG_LEVEL"},
+			Usage:       "the minimum level of the logs, one of 'debug', 'info', 'warn' or 'error'",
+		}),
 	}
+	app.Before = loadConfigFile
 	app.Action = actionFunc
-
-	if err := app.Run(os.Args); err != nil {
-		logrus.WithError(err).Fatal("server exited with error")
-	}
+	app.Commands = []*cli.Command{configCommand}
+	return app
 }
 
 // actionFunc is the function called when cli app is run
 func actionFunc(c *cli.Context) error {
+	level, err := logrus.ParseLevel(config.LogLevel)
+	if err != nil {
+		return err
+	}
+	logrus.SetLevel(level)
+
 	srv, err := server.NewServer(config)
 	if err != nil {
 		return err
 	}
-	return srv.ListenHTTP()
+	errs := make(chan error, 2)
+	go func() { errs <- srv.ListenHTTP() }()
+	if config.GrpcPort != 0 {
+		go func() { errs <- srv.ListenGRPC() }()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	var shutdownErr error
+	select {
+	case err = <-errs:
+		// one of the listeners failed, so there is nothing to keep serving during the shutdown delay
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		shutdownErr = srv.Stop(ctx)
+	case sig := <-signals:
+		logrus.Infof("received %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownDelay+config.ShutdownTimeout)
+		defer cancel()
+		shutdownErr = srv.Shutdown(ctx)
+	}
+	if err == nil {
+		err = shutdownErr
+	}
+	return err
 }
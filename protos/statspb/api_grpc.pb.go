@@ -0,0 +1,538 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.9
+// source: api.proto
+
+package statspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// StatsKeeperServiceClient is the client API for StatsKeeperService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type StatsKeeperServiceClient interface {
+	ListUserStatistics(ctx context.Context, in *ListUserStatisticsRequest, opts ...grpc.CallOption) (*ListUserStatisticsResponse, error)
+	GetStatistic(ctx context.Context, in *GetStatisticRequest, opts ...grpc.CallOption) (*StatisticEntity, error)
+	CreateStatistic(ctx context.Context, in *StatisticEntity, opts ...grpc.CallOption) (*StatisticEntity, error)
+	UpdateStatistic(ctx context.Context, in *UpdateStatisticRequest, opts ...grpc.CallOption) (*StatisticEntity, error)
+	DeleteStatistic(ctx context.Context, in *DeleteStatisticRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	IncrementCounter(ctx context.Context, in *IncrementCounterRequest, opts ...grpc.CallOption) (*StatisticEntity, error)
+	AppendTimestamps(ctx context.Context, in *AppendTimestampsRequest, opts ...grpc.CallOption) (*StatisticEntity, error)
+	RemoveTimestamps(ctx context.Context, in *RemoveTimestampsRequest, opts ...grpc.CallOption) (*StatisticEntity, error)
+	RemoveTimestampRange(ctx context.Context, in *RemoveTimestampRangeRequest, opts ...grpc.CallOption) (*StatisticEntity, error)
+	RestoreStatistic(ctx context.Context, in *RestoreStatisticRequest, opts ...grpc.CallOption) (*StatisticEntity, error)
+	PurgeStatistic(ctx context.Context, in *PurgeStatisticRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ListHistory(ctx context.Context, in *ListHistoryRequest, opts ...grpc.CallOption) (*ListHistoryResponse, error)
+	RevertStatistic(ctx context.Context, in *RevertStatisticRequest, opts ...grpc.CallOption) (*StatisticEntity, error)
+}
+
+type statsKeeperServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStatsKeeperServiceClient(cc grpc.ClientConnInterface) StatsKeeperServiceClient {
+	return &statsKeeperServiceClient{cc}
+}
+
+func (c *statsKeeperServiceClient) ListUserStatistics(ctx context.Context, in *ListUserStatisticsRequest, opts ...grpc.CallOption) (*ListUserStatisticsResponse, error) {
+	out := new(ListUserStatisticsResponse)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/ListUserStatistics", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) GetStatistic(ctx context.Context, in *GetStatisticRequest, opts ...grpc.CallOption) (*StatisticEntity, error) {
+	out := new(StatisticEntity)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/GetStatistic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) CreateStatistic(ctx context.Context, in *StatisticEntity, opts ...grpc.CallOption) (*StatisticEntity, error) {
+	out := new(StatisticEntity)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/CreateStatistic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) UpdateStatistic(ctx context.Context, in *UpdateStatisticRequest, opts ...grpc.CallOption) (*StatisticEntity, error) {
+	out := new(StatisticEntity)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/UpdateStatistic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) DeleteStatistic(ctx context.Context, in *DeleteStatisticRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/DeleteStatistic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) IncrementCounter(ctx context.Context, in *IncrementCounterRequest, opts ...grpc.CallOption) (*StatisticEntity, error) {
+	out := new(StatisticEntity)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/IncrementCounter", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) AppendTimestamps(ctx context.Context, in *AppendTimestampsRequest, opts ...grpc.CallOption) (*StatisticEntity, error) {
+	out := new(StatisticEntity)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/AppendTimestamps", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) RemoveTimestamps(ctx context.Context, in *RemoveTimestampsRequest, opts ...grpc.CallOption) (*StatisticEntity, error) {
+	out := new(StatisticEntity)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/RemoveTimestamps", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) RemoveTimestampRange(ctx context.Context, in *RemoveTimestampRangeRequest, opts ...grpc.CallOption) (*StatisticEntity, error) {
+	out := new(StatisticEntity)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/RemoveTimestampRange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) RestoreStatistic(ctx context.Context, in *RestoreStatisticRequest, opts ...grpc.CallOption) (*StatisticEntity, error) {
+	out := new(StatisticEntity)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/RestoreStatistic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) PurgeStatistic(ctx context.Context, in *PurgeStatisticRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/PurgeStatistic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) ListHistory(ctx context.Context, in *ListHistoryRequest, opts ...grpc.CallOption) (*ListHistoryResponse, error) {
+	out := new(ListHistoryResponse)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/ListHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsKeeperServiceClient) RevertStatistic(ctx context.Context, in *RevertStatisticRequest, opts ...grpc.CallOption) (*StatisticEntity, error) {
+	out := new(StatisticEntity)
+	err := c.cc.Invoke(ctx, "/com.statskeeper.v1.StatsKeeperService/RevertStatistic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StatsKeeperServiceServer is the server API for StatsKeeperService service.
+// All implementations must embed UnimplementedStatsKeeperServiceServer
+// for forward compatibility
+type StatsKeeperServiceServer interface {
+	ListUserStatistics(context.Context, *ListUserStatisticsRequest) (*ListUserStatisticsResponse, error)
+	GetStatistic(context.Context, *GetStatisticRequest) (*StatisticEntity, error)
+	CreateStatistic(context.Context, *StatisticEntity) (*StatisticEntity, error)
+	UpdateStatistic(context.Context, *UpdateStatisticRequest) (*StatisticEntity, error)
+	DeleteStatistic(context.Context, *DeleteStatisticRequest) (*emptypb.Empty, error)
+	IncrementCounter(context.Context, *IncrementCounterRequest) (*StatisticEntity, error)
+	AppendTimestamps(context.Context, *AppendTimestampsRequest) (*StatisticEntity, error)
+	RemoveTimestamps(context.Context, *RemoveTimestampsRequest) (*StatisticEntity, error)
+	RemoveTimestampRange(context.Context, *RemoveTimestampRangeRequest) (*StatisticEntity, error)
+	RestoreStatistic(context.Context, *RestoreStatisticRequest) (*StatisticEntity, error)
+	PurgeStatistic(context.Context, *PurgeStatisticRequest) (*emptypb.Empty, error)
+	ListHistory(context.Context, *ListHistoryRequest) (*ListHistoryResponse, error)
+	RevertStatistic(context.Context, *RevertStatisticRequest) (*StatisticEntity, error)
+	mustEmbedUnimplementedStatsKeeperServiceServer()
+}
+
+// UnimplementedStatsKeeperServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedStatsKeeperServiceServer struct {
+}
+
+func (UnimplementedStatsKeeperServiceServer) ListUserStatistics(context.Context, *ListUserStatisticsRequest) (*ListUserStatisticsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUserStatistics not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) GetStatistic(context.Context, *GetStatisticRequest) (*StatisticEntity, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatistic not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) CreateStatistic(context.Context, *StatisticEntity) (*StatisticEntity, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateStatistic not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) UpdateStatistic(context.Context, *UpdateStatisticRequest) (*StatisticEntity, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateStatistic not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) DeleteStatistic(context.Context, *DeleteStatisticRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteStatistic not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) IncrementCounter(context.Context, *IncrementCounterRequest) (*StatisticEntity, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IncrementCounter not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) AppendTimestamps(context.Context, *AppendTimestampsRequest) (*StatisticEntity, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendTimestamps not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) RemoveTimestamps(context.Context, *RemoveTimestampsRequest) (*StatisticEntity, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveTimestamps not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) RemoveTimestampRange(context.Context, *RemoveTimestampRangeRequest) (*StatisticEntity, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveTimestampRange not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) RestoreStatistic(context.Context, *RestoreStatisticRequest) (*StatisticEntity, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreStatistic not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) PurgeStatistic(context.Context, *PurgeStatisticRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeStatistic not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) ListHistory(context.Context, *ListHistoryRequest) (*ListHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListHistory not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) RevertStatistic(context.Context, *RevertStatisticRequest) (*StatisticEntity, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevertStatistic not implemented")
+}
+func (UnimplementedStatsKeeperServiceServer) mustEmbedUnimplementedStatsKeeperServiceServer() {}
+
+// UnsafeStatsKeeperServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StatsKeeperServiceServer will
+// result in compilation errors.
+type UnsafeStatsKeeperServiceServer interface {
+	mustEmbedUnimplementedStatsKeeperServiceServer()
+}
+
+func RegisterStatsKeeperServiceServer(s grpc.ServiceRegistrar, srv StatsKeeperServiceServer) {
+	s.RegisterService(&StatsKeeperService_ServiceDesc, srv)
+}
+
+func _StatsKeeperService_ListUserStatistics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUserStatisticsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).ListUserStatistics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/ListUserStatistics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).ListUserStatistics(ctx, req.(*ListUserStatisticsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_GetStatistic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatisticRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).GetStatistic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/GetStatistic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).GetStatistic(ctx, req.(*GetStatisticRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_CreateStatistic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatisticEntity)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).CreateStatistic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/CreateStatistic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).CreateStatistic(ctx, req.(*StatisticEntity))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_UpdateStatistic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateStatisticRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).UpdateStatistic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/UpdateStatistic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).UpdateStatistic(ctx, req.(*UpdateStatisticRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_DeleteStatistic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteStatisticRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).DeleteStatistic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/DeleteStatistic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).DeleteStatistic(ctx, req.(*DeleteStatisticRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_IncrementCounter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IncrementCounterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).IncrementCounter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/IncrementCounter",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).IncrementCounter(ctx, req.(*IncrementCounterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_AppendTimestamps_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendTimestampsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).AppendTimestamps(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/AppendTimestamps",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).AppendTimestamps(ctx, req.(*AppendTimestampsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_RemoveTimestamps_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveTimestampsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).RemoveTimestamps(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/RemoveTimestamps",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).RemoveTimestamps(ctx, req.(*RemoveTimestampsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_RemoveTimestampRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveTimestampRangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).RemoveTimestampRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/RemoveTimestampRange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).RemoveTimestampRange(ctx, req.(*RemoveTimestampRangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_RestoreStatistic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreStatisticRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).RestoreStatistic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/RestoreStatistic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).RestoreStatistic(ctx, req.(*RestoreStatisticRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_PurgeStatistic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeStatisticRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).PurgeStatistic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/PurgeStatistic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).PurgeStatistic(ctx, req.(*PurgeStatisticRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_ListHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).ListHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/ListHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).ListHistory(ctx, req.(*ListHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsKeeperService_RevertStatistic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevertStatisticRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsKeeperServiceServer).RevertStatistic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/com.statskeeper.v1.StatsKeeperService/RevertStatistic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsKeeperServiceServer).RevertStatistic(ctx, req.(*RevertStatisticRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// StatsKeeperService_ServiceDesc is the grpc.ServiceDesc for StatsKeeperService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var StatsKeeperService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "com.statskeeper.v1.StatsKeeperService",
+	HandlerType: (*StatsKeeperServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListUserStatistics",
+			Handler:    _StatsKeeperService_ListUserStatistics_Handler,
+		},
+		{
+			MethodName: "GetStatistic",
+			Handler:    _StatsKeeperService_GetStatistic_Handler,
+		},
+		{
+			MethodName: "CreateStatistic",
+			Handler:    _StatsKeeperService_CreateStatistic_Handler,
+		},
+		{
+			MethodName: "UpdateStatistic",
+			Handler:    _StatsKeeperService_UpdateStatistic_Handler,
+		},
+		{
+			MethodName: "DeleteStatistic",
+			Handler:    _StatsKeeperService_DeleteStatistic_Handler,
+		},
+		{
+			MethodName: "IncrementCounter",
+			Handler:    _StatsKeeperService_IncrementCounter_Handler,
+		},
+		{
+			MethodName: "AppendTimestamps",
+			Handler:    _StatsKeeperService_AppendTimestamps_Handler,
+		},
+		{
+			MethodName: "RemoveTimestamps",
+			Handler:    _StatsKeeperService_RemoveTimestamps_Handler,
+		},
+		{
+			MethodName: "RemoveTimestampRange",
+			Handler:    _StatsKeeperService_RemoveTimestampRange_Handler,
+		},
+		{
+			MethodName: "RestoreStatistic",
+			Handler:    _StatsKeeperService_RestoreStatistic_Handler,
+		},
+		{
+			MethodName: "PurgeStatistic",
+			Handler:    _StatsKeeperService_PurgeStatistic_Handler,
+		},
+		{
+			MethodName: "ListHistory",
+			Handler:    _StatsKeeperService_ListHistory_Handler,
+		},
+		{
+			MethodName: "RevertStatistic",
+			Handler:    _StatsKeeperService_RevertStatistic_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api.proto",
+}
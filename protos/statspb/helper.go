@@ -2,11 +2,13 @@ package statspb
 
 // GetComponentType is a helper function to get the ComponentType of this entity.
 func (se *StatisticEntity) GetComponentType() ComponentType {
-	switch se.Component.(type) {
+	switch se.GetComponent().(type) {
 	case *StatisticEntity_Counter:
 		return ComponentType_COUNTER
 	case *StatisticEntity_Date:
 		return ComponentType_DATE
+	case *StatisticEntity_MultiValue:
+		return ComponentType_MULTI_VALUE
 	default:
 		return ComponentType_NONE
 	}
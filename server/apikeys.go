@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	// apiKeyPrefix is the prefix of every API key, which makes the keys recognizable, e.g. by secret scanners.
+	apiKeyPrefix = "sk_"
+	// apiKeyLength is the number of random bytes of an API key.
+	apiKeyLength = 32
+	// apiKeyVisibleLength is the number of characters of an API key that are kept to identify it.
+	apiKeyVisibleLength = len(apiKeyPrefix) + 8
+)
+
+func (s *Server) ListApiKeys(w http.ResponseWriter, r *http.Request) {
+	userId := credentialsUserIdOf(w, r, r.URL.Query().Get("user_id"))
+	if userId == "" {
+		return
+	}
+
+	keys, err := s.db.ListApiKeys(r.Context(), userId)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, &statspb.ListApiKeysResponse{ApiKeys: keys})
+}
+
+func (s *Server) CreateApiKey(w http.ResponseWriter, r *http.Request) {
+	in := unmarshalRequestBody(w, r, &statspb.CreateApiKeyRequest{})
+	if in == nil {
+		return
+	}
+	if in.UserId = credentialsUserIdOf(w, r, in.UserId); in.UserId == "" {
+		return
+	}
+	// keys can only be limited to existing statistics of the user
+	for _, entityId := range in.EntityIds {
+		if _, err := s.db.GetStatistic(r.Context(), in.UserId, entityId); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	}
+
+	random, err := newRandomToken(apiKeyLength)
+	if err != nil {
+		writeErrorResponse(w, http.StatusInternalServerError, "error creating API key", err)
+		return
+	}
+	key := apiKeyPrefix + random
+	apiKey, err := s.db.CreateApiKey(r.Context(), &statspb.ApiKey{
+		UserId:    in.UserId,
+		Name:      in.Name,
+		Prefix:    key[:apiKeyVisibleLength],
+		Scope:     in.Scope,
+		EntityIds: in.EntityIds,
+	}, hashToken(key))
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, &statspb.CreateApiKeyResponse{ApiKey: apiKey, Key: key})
+}
+
+func (s *Server) RevokeApiKey(w http.ResponseWriter, r *http.Request) {
+	keyId := r.URL.Query().Get("key_id")
+	if keyId == "" {
+		writeInvalidFieldsResponse(w, "key_id cannot be empty", "key_id")
+		return
+	}
+
+	userId := credentialsUserIdOf(w, r, r.URL.Query().Get("user_id"))
+	if userId == "" {
+		return
+	}
+
+	if err := s.db.RevokeApiKey(r.Context(), userId, keyId); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, &emptypb.Empty{})
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// createTestApiKey creates an API key of the user of the session token with the given scope and entity ids, and
+// returns the Authorization header of it.
+func createTestApiKey(t *testing.T, ts *httptest.Server, token string, scope statspb.ApiKey_Scope, entityIds ...string) string {
+	resp := &statspb.CreateApiKeyResponse{}
+	req := &statspb.CreateApiKeyRequest{Name: "test", Scope: scope, EntityIds: entityIds}
+	if status := doRequest(t, ts, http.MethodPost, "/api/auth/keys/create", "Bearer "+token, req, resp); status != http.StatusOK {
+		t.Fatalf("wrong status of API key creation: expected=%d, got=%d", http.StatusOK, status)
+	}
+	return "ApiKey " + resp.Key
+}
+
+// createTestStat creates a counter statistic with the given Authorization header, and returns it.
+func createTestStat(t *testing.T, ts *httptest.Server, authorization, name string) *statspb.StatisticEntity {
+	entity := &statspb.StatisticEntity{}
+	if status := doRequest(t, ts, http.MethodPut, "/api/stats/add", authorization, newCounterStat(name), entity); status != http.StatusOK {
+		t.Fatalf("wrong status of statistic creation: expected=%d, got=%d", http.StatusOK, status)
+	}
+	return entity
+}
+
+// apiKeyTest is a request that is made with an API key, and its expected result.
+type apiKeyTest struct {
+	name   string
+	method string
+	path   string
+	body   proto.Message
+	status int
+}
+
+// runApiKeyTests makes the requests of tests with the given Authorization header and checks their status codes.
+func runApiKeyTests(t *testing.T, ts *httptest.Server, authorization string, tests []apiKeyTest) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := doRequest(t, ts, tt.method, tt.path, authorization, tt.body, nil); status != tt.status {
+				t.Fatalf("wrong status: expected=%d, got=%d", tt.status, status)
+			}
+		})
+	}
+}
+
+// updateName returns the request to rename the statistic specified by entityId.
+func updateName(entityId string) *statspb.UpdateStatisticRequest {
+	return &statspb.UpdateStatisticRequest{
+		Fields: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+		Values: &statspb.StatisticEntity{Id: entityId, Name: "renamed"},
+	}
+}
+
+func Test_Server_ApiKey_ReadOnly(t *testing.T) {
+	srv, ts := newTestServer(t, func(cfg *Config) { cfg.Accounts = true })
+	user := registerTestUser(t, ts, "alice", "password")
+	_, session := login(t, ts, "alice", "password")
+	entity := createTestStat(t, ts, "Bearer "+session.AccessToken, "stat")
+	key := createTestApiKey(t, ts, session.AccessToken, statspb.ApiKey_READ_ONLY)
+
+	renamed := newCounterStat("renamed")
+	runApiKeyTests(t, ts, key, []apiKeyTest{
+		{name: "list", method: http.MethodGet, path: "/api/stats/list", status: http.StatusOK},
+		{name: "get", method: http.MethodGet, path: "/api/stats/get?entity_id=" + entity.Id, status: http.StatusOK},
+		{name: "get v1", method: http.MethodGet, path: "/api/v1/stats/" + entity.Id, status: http.StatusOK},
+		{name: "add", method: http.MethodPut, path: "/api/stats/add", body: renamed, status: http.StatusForbidden},
+		{name: "update", method: http.MethodPost, path: "/api/stats/update", body: updateName(entity.Id), status: http.StatusForbidden},
+		{name: "delete", method: http.MethodDelete, path: "/api/stats/delete?entity_id=" + entity.Id, status: http.StatusForbidden},
+		{name: "create v1", method: http.MethodPost, path: "/api/v1/users/" + user.Id + "/stats", body: renamed, status: http.StatusForbidden},
+		{name: "patch v1", method: http.MethodPatch, path: "/api/v1/stats/" + entity.Id + "?update_mask=name", body: renamed, status: http.StatusForbidden},
+		{name: "delete v1", method: http.MethodDelete, path: "/api/v1/stats/" + entity.Id, status: http.StatusForbidden},
+		{name: "create API key", method: http.MethodPost, path: "/api/auth/keys/create", status: http.StatusForbidden},
+	})
+
+	client := newTestGrpcClient(t, srv)
+	ctx := withAuthorization(key)
+	grpcTests := []struct {
+		name string
+		call func(ctx context.Context) error
+		code codes.Code
+	}{
+		{
+			name: "ListUserStatistics",
+			call: func(ctx context.Context) error {
+				_, err := client.ListUserStatistics(ctx, &statspb.ListUserStatisticsRequest{})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "GetStatistic",
+			call: func(ctx context.Context) error {
+				_, err := client.GetStatistic(ctx, &statspb.GetStatisticRequest{EntityId: entity.Id})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "CreateStatistic",
+			call: func(ctx context.Context) error {
+				_, err := client.CreateStatistic(ctx, renamed)
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "UpdateStatistic",
+			call: func(ctx context.Context) error {
+				_, err := client.UpdateStatistic(ctx, updateName(entity.Id))
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "IncrementCounter",
+			call: func(ctx context.Context) error {
+				_, err := client.IncrementCounter(ctx, &statspb.IncrementCounterRequest{EntityId: entity.Id, Delta: 1})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "DeleteStatistic",
+			call: func(ctx context.Context) error {
+				_, err := client.DeleteStatistic(ctx, &statspb.DeleteStatisticRequest{EntityId: entity.Id})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+	}
+	for _, tt := range grpcTests {
+		t.Run("grpc "+tt.name, func(t *testing.T) {
+			if code := status.Code(tt.call(ctx)); code != tt.code {
+				t.Fatalf("wrong code: expected=%s, got=%s", tt.code, code)
+			}
+		})
+	}
+
+	// none of the denied requests changed the statistic
+	got := &statspb.StatisticEntity{}
+	if status := doRequest(t, ts, http.MethodGet, "/api/stats/get?entity_id="+entity.Id, key, nil, got); status != http.StatusOK {
+		t.Fatalf("wrong status of get: expected=%d, got=%d", http.StatusOK, status)
+	}
+	if got.Version != entity.Version {
+		t.Fatalf("statistic is modified by a read-only API key: expected version=%d, got=%d", entity.Version, got.Version)
+	}
+}
+
+func Test_Server_ApiKey_EntityIds(t *testing.T) {
+	srv, ts := newTestServer(t, func(cfg *Config) { cfg.Accounts = true })
+	registerTestUser(t, ts, "alice", "password")
+	_, session := login(t, ts, "alice", "password")
+	allowed := createTestStat(t, ts, "Bearer "+session.AccessToken, "allowed")
+	other := createTestStat(t, ts, "Bearer "+session.AccessToken, "other")
+	key := createTestApiKey(t, ts, session.AccessToken, statspb.ApiKey_READ_WRITE, allowed.Id)
+
+	renamed := newCounterStat("renamed")
+	runApiKeyTests(t, ts, key, []apiKeyTest{
+		{name: "get", method: http.MethodGet, path: "/api/stats/get?entity_id=" + allowed.Id, status: http.StatusOK},
+		{name: "update", method: http.MethodPost, path: "/api/stats/update", body: updateName(allowed.Id), status: http.StatusOK},
+		{name: "get other", method: http.MethodGet, path: "/api/stats/get?entity_id=" + other.Id, status: http.StatusForbidden},
+		{name: "get other v1", method: http.MethodGet, path: "/api/v1/stats/" + other.Id, status: http.StatusForbidden},
+		{name: "update other", method: http.MethodPost, path: "/api/stats/update", body: updateName(other.Id), status: http.StatusForbidden},
+		{name: "patch other v1", method: http.MethodPatch, path: "/api/v1/stats/" + other.Id + "?update_mask=name", body: renamed, status: http.StatusForbidden},
+		{name: "delete other", method: http.MethodDelete, path: "/api/stats/delete?entity_id=" + other.Id, status: http.StatusForbidden},
+		{name: "list", method: http.MethodGet, path: "/api/stats/list", status: http.StatusForbidden},
+		{name: "add", method: http.MethodPut, path: "/api/stats/add", body: renamed, status: http.StatusForbidden},
+	})
+
+	client := newTestGrpcClient(t, srv)
+	ctx := withAuthorization(key)
+	if _, err := client.GetStatistic(ctx, &statspb.GetStatisticRequest{EntityId: allowed.Id}); err != nil {
+		t.Fatalf("GetStatistic of the allowed statistic returned unexpected error: %v", err)
+	}
+	if _, err := client.GetStatistic(ctx, &statspb.GetStatisticRequest{EntityId: other.Id}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("wrong code of GetStatistic of another statistic: expected=%s, got=%v", codes.PermissionDenied, err)
+	}
+	if _, err := client.UpdateStatistic(ctx, updateName(other.Id)); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("wrong code of UpdateStatistic of another statistic: expected=%s, got=%v", codes.PermissionDenied, err)
+	}
+
+	// the other statistic is not changed by the denied requests
+	got := &statspb.StatisticEntity{}
+	if status := doRequest(t, ts, http.MethodGet, "/api/stats/get?entity_id="+other.Id, "Bearer "+session.AccessToken, nil, got); status != http.StatusOK {
+		t.Fatalf("wrong status of get: expected=%d, got=%d", http.StatusOK, status)
+	}
+	if got.Version != other.Version {
+		t.Fatalf("other statistic is modified by an API key that is limited to another one: %v", got)
+	}
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"github.com/umutozd/stats-keeper/storage"
+)
+
+const (
+	// schemeBearer is the authorization scheme of session tokens and JWTs.
+	schemeBearer = "Bearer"
+	// schemeApiKey is the authorization scheme of API keys.
+	schemeApiKey = "ApiKey"
+)
+
+// authenticator authenticates the requests with their Authorization headers, which have either a bearer token
+// or an API key. Bearer tokens are either the session tokens issued by logins, or signed JWTs if the server has
+// their keys. JWTs are signed with either the HMAC secret or the Ed25519 private key whose public key is known,
+// and their subject is the id of the user. Session tokens and API keys are accepted only if the accounts are
+// enabled.
+type authenticator struct {
+	sessions   storage.UserStorage
+	apiKeys    storage.ApiKeyStorage
+	hmacSecret []byte
+	ed25519Key ed25519.PublicKey
+	issuer     string
+	audience   string
+	accounts   bool
+	// parser is nil if the server doesn't have the keys of JWTs.
+	parser *jwt.Parser
+}
+
+// newAuthenticator creates the authenticator that looks up the sessions and the API keys in db and validates
+// JWTs with the keys in cfg.
+func newAuthenticator(cfg *Config, db storage.StatsKeeperStorage) (*authenticator, error) {
+	a := &authenticator{
+		sessions: db,
+		apiKeys:  db,
+		issuer:   cfg.AuthIssuer,
+		audience: cfg.AuthAudience,
+		accounts: cfg.Accounts,
+	}
+	methods := []string{}
+	if cfg.AuthHmacSecret != "" {
+		a.hmacSecret = []byte(cfg.AuthHmacSecret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg(), jwt.SigningMethodHS384.Alg(), jwt.SigningMethodHS512.Alg())
+	}
+	if cfg.AuthEd25519PublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.AuthEd25519PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading Ed25519 public key: %w", err)
+		}
+		key, err := jwt.ParseEdPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing Ed25519 public key: %w", err)
+		}
+		a.ed25519Key = key.(ed25519.PublicKey)
+		methods = append(methods, jwt.SigningMethodEdDSA.Alg())
+	}
+	if len(methods) != 0 {
+		a.parser = jwt.NewParser(jwt.WithValidMethods(methods))
+	}
+	return a, nil
+}
+
+// required reports whether every request must be authenticated, which is the case if the accounts are enabled
+// or the server has the keys of JWTs. Otherwise, requests without an Authorization header are trusted with the
+// user_id they specify.
+func (a *authenticator) required() bool {
+	return a.accounts || a.parser != nil
+}
+
+// principal is who a request is made by.
+type principal struct {
+	userId string
+	// apiKey is the API key that the request is authenticated with, or nil if it's authenticated otherwise.
+	apiKey *statspb.ApiKey
+}
+
+// authenticate returns the principal of the request with the given Authorization header, whose user is the
+// user of its session token or API key, or the subject of its JWT. It returns nil if authorization is empty
+// and authentication is not required. If authorization doesn't have valid credentials, it returns an
+// *authError. Other errors are storage errors of looking up the credentials.
+func (a *authenticator) authenticate(ctx context.Context, authorization string) (*principal, error) {
+	scheme, credentials, err := parseAuthorization(authorization)
+	switch {
+	case err != nil:
+		return nil, err
+	case scheme == "" && a.required():
+		return nil, &authError{reason: "bearer token or API key is required"}
+	case scheme == "":
+		return nil, nil
+	case scheme == schemeApiKey && !a.accounts:
+		return nil, &authError{reason: "API keys are disabled"}
+	case scheme == schemeApiKey:
+		return a.authenticateApiKey(ctx, credentials)
+	case a.isJwt(credentials):
+		userId, err := a.authenticateJwt(credentials)
+		if err != nil {
+			return nil, err
+		}
+		return &principal{userId: userId}, nil
+	case !a.accounts:
+		return nil, &authError{reason: "session tokens are disabled"}
+	}
+
+	userId, err := a.sessions.GetSession(ctx, hashToken(credentials))
+	if err != nil {
+		if code, _, _ := storage.ToHttpError(err); code == http.StatusNotFound {
+			return nil, &authError{reason: "session token is invalid or expired"}
+		}
+		return nil, err
+	}
+	return &principal{userId: userId}, nil
+}
+
+// parseAuthorization returns the scheme and the credentials in the Authorization header, which is either
+// schemeBearer or schemeApiKey. It returns an empty scheme if authorization is empty.
+func parseAuthorization(authorization string) (scheme, credentials string, err error) {
+	if authorization == "" {
+		return "", "", nil
+	}
+	scheme, credentials, _ = strings.Cut(authorization, " ")
+	switch {
+	case credentials == "":
+	case strings.EqualFold(scheme, schemeBearer):
+		return schemeBearer, credentials, nil
+	case strings.EqualFold(scheme, schemeApiKey):
+		return schemeApiKey, credentials, nil
+	}
+	return "", "", &authError{reason: "authorization must be a bearer token or an API key"}
+}
+
+// isJwt reports whether the bearer token is a JWT rather than a session token. Session tokens are
+// base64url-encoded, so they never have the dots that separate the parts of a JWT.
+func (a *authenticator) isJwt(token string) bool {
+	return a.parser != nil && strings.Count(token, ".") == 2
+}
+
+// authenticateApiKey returns the principal of key. It returns an error if key is not a valid API key.
+func (a *authenticator) authenticateApiKey(ctx context.Context, key string) (*principal, error) {
+	apiKey, err := a.apiKeys.GetApiKey(ctx, hashToken(key))
+	if err != nil {
+		if code, _, _ := storage.ToHttpError(err); code == http.StatusNotFound {
+			return nil, &authError{reason: "API key is invalid or revoked"}
+		}
+		return nil, err
+	}
+	return &principal{userId: apiKey.UserId, apiKey: apiKey}, nil
+}
+
+// authenticateJwt returns the subject of token. It returns an error if token is not a valid JWT.
+func (a *authenticator) authenticateJwt(token string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	if _, err := a.parser.ParseWithClaims(token, claims, a.key); err != nil {
+		return "", &authError{reason: err.Error()}
+	}
+	now := time.Now()
+	switch {
+	case !claims.VerifyExpiresAt(now, true):
+		return "", &authError{reason: "token must have an expiration time"}
+	case claims.Subject == "":
+		return "", &authError{reason: "token must have a subject"}
+	case a.issuer != "" && !claims.VerifyIssuer(a.issuer, true):
+		return "", &authError{reason: "token has an invalid issuer"}
+	case a.audience != "" && !claims.VerifyAudience(a.audience, true):
+		return "", &authError{reason: "token has an invalid audience"}
+	}
+	return claims.Subject, nil
+}
+
+// authError is the error of a request that doesn't have valid credentials.
+type authError struct {
+	reason string
+}
+
+func (e *authError) Error() string {
+	return e.reason
+}
+
+// key implements jwt.Keyfunc, returning the key that token must be signed with.
+func (a *authenticator) key(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return a.hmacSecret, nil
+	case *jwt.SigningMethodEd25519:
+		return a.ed25519Key, nil
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+// publicPaths are the paths that are not authenticated, since they're used to get a session token.
+var publicPaths = map[string]bool{
+	"/api/auth/register": true,
+	"/api/auth/login":    true,
+}
+
+// credentialPaths are the paths that manage the credentials of the users, which cannot be accessed with API
+// keys, so that a leaked key cannot be used to create more.
+var credentialPaths = map[string]bool{
+	"/api/auth/logout":      true,
+	"/api/auth/keys/list":   true,
+	"/api/auth/keys/create": true,
+	"/api/auth/keys/revoke": true,
+}
+
+// authenticateRequest authenticates r and returns r with its principal in its context. Requests to
+// publicPaths, and requests without an Authorization header if authentication is not required, are returned
+// as they are. If r cannot be authenticated, or its API key doesn't allow it, authenticateRequest writes the
+// error response to w and returns nil.
+func (s *Server) authenticateRequest(w http.ResponseWriter, r *http.Request) *http.Request {
+	if publicPaths[r.URL.Path] {
+		return r
+	}
+	p, err := s.auth.authenticate(r.Context(), r.Header.Get("Authorization"))
+	if err != nil {
+		if _, ok := err.(*authError); !ok {
+			writeStorageError(w, err)
+			return nil
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="stats-keeper"`)
+		writeErrorResponse(w, http.StatusUnauthorized, "unauthenticated: "+err.Error(), nil)
+		return nil
+	}
+	if p == nil {
+		return r
+	}
+	if p.apiKey != nil {
+		switch {
+		case credentialPaths[r.URL.Path]:
+			writeErrorResponse(w, http.StatusForbidden, "API keys cannot manage credentials", nil)
+			return nil
+		case p.apiKey.Scope != statspb.ApiKey_READ_WRITE && r.Method != http.MethodGet && r.Method != http.MethodHead:
+			writeErrorResponse(w, http.StatusForbidden, "API key is read-only", nil)
+			return nil
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p))
+}
+
+// principalContextKey is the context key of the principal of the request.
+type principalContextKey struct{}
+
+// principalOf returns the principal of the request with ctx, or nil if the request is not authenticated.
+func principalOf(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalContextKey{}).(*principal)
+	return p
+}
+
+// authenticatedUserId returns the id of the user that made the request with ctx, or an empty string if the
+// request is not authenticated.
+func authenticatedUserId(ctx context.Context) string {
+	if p := principalOf(ctx); p != nil {
+		return p.userId
+	}
+	return ""
+}
+
+// userIdOf returns the id of the user whose statistics r accesses, as described in userIdFor. If the user id
+// cannot be determined or r cannot access entityId, userIdOf writes the error response to w and returns an
+// empty string.
+func userIdOf(w http.ResponseWriter, r *http.Request, requested, entityId string) string {
+	userId, err := userIdFor(r.Context(), requested, entityId)
+	if err != nil {
+		writeErrorResponse(w, err.status, err.message, nil)
+		return ""
+	}
+	return userId
+}
+
+// credentialsUserIdOf returns the id of the user whose credentials r manages, which is the user of its
+// session token or JWT. requested, the user_id given in r, must either be empty or the same. Unlike
+// userIdOf, it never trusts requested: if r is not authenticated, or is authenticated with an API key,
+// credentialsUserIdOf writes the error response to w and returns an empty string.
+func credentialsUserIdOf(w http.ResponseWriter, r *http.Request, requested string) string {
+	p := principalOf(r.Context())
+	switch {
+	case p == nil:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="stats-keeper"`)
+		writeErrorResponse(w, http.StatusUnauthorized, "unauthenticated: session token is required", nil)
+		return ""
+	case p.apiKey != nil:
+		writeErrorResponse(w, http.StatusForbidden, "API keys cannot manage credentials", nil)
+		return ""
+	case requested != "" && requested != p.userId:
+		writeErrorResponse(w, http.StatusForbidden, "user_id is not the authenticated user", nil)
+		return ""
+	default:
+		return p.userId
+	}
+}
+
+// accessError is the error of a request that cannot access the statistics it requests.
+type accessError struct {
+	status  int
+	message string
+}
+
+// userIdFor returns the id of the user whose statistics the request with ctx accesses. If the request is
+// authenticated, it's the authenticated user, and requested, the user_id given in the request, must either be
+// empty or the same. Otherwise, it's requested, which cannot be empty. entityId is the id of the statistic
+// that the request accesses, or empty if it accesses the statistics of the user as a whole, e.g. by listing
+// them, which API keys that are limited to some statistics cannot do.
+func userIdFor(ctx context.Context, requested, entityId string) (string, *accessError) {
+	userId := authenticatedUserId(ctx)
+	switch {
+	case userId == "" && requested == "":
+		return "", &accessError{http.StatusBadRequest, "user_id cannot be empty"}
+	case userId == "":
+		return requested, nil
+	case requested != "" && requested != userId:
+		return "", &accessError{http.StatusForbidden, "user_id is not the authenticated user"}
+	case !canAccessEntity(principalOf(ctx).apiKey, entityId) && entityId == "":
+		return "", &accessError{http.StatusForbidden, "API key is limited to specific statistics"}
+	case !canAccessEntity(principalOf(ctx).apiKey, entityId):
+		return "", &accessError{http.StatusForbidden, "API key cannot access this statistic"}
+	default:
+		return userId, nil
+	}
+}
+
+// canAccessEntity reports whether the requests authenticated with apiKey can access the statistic specified
+// by entityId, or all statistics of the user if entityId is empty. apiKey is nil if the requests are not
+// authenticated with an API key.
+func canAccessEntity(apiKey *statspb.ApiKey, entityId string) bool {
+	if apiKey == nil || len(apiKey.EntityIds) == 0 {
+		return true
+	}
+	for _, id := range apiKey.EntityIds {
+		if id == entityId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/umutozd/stats-keeper/storage"
+)
+
+// newTestJwtAuthenticator returns an authenticator that accepts the JWTs signed with hmacSecret or
+// ed25519Key, issued by "issuer" for "audience".
+func newTestJwtAuthenticator(t *testing.T, hmacSecret string, ed25519Key ed25519.PrivateKey) *authenticator {
+	der, err := x509.MarshalPKIXPublicKey(ed25519Key.Public())
+	if err != nil {
+		t.Fatalf("error encoding public key: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "ed25519.pem")
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("error writing public key: %v", err)
+	}
+
+	cfg := NewConfig()
+	cfg.AuthHmacSecret = hmacSecret
+	cfg.AuthEd25519PublicKeyFile = keyFile
+	cfg.AuthIssuer = "issuer"
+	cfg.AuthAudience = "audience"
+	a, err := newAuthenticator(cfg, storage.NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("error creating authenticator: %v", err)
+	}
+	return a
+}
+
+func Test_authenticator_authenticateJwt(t *testing.T) {
+	const hmacSecret = "secret"
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating Ed25519 key: %v", err)
+	}
+	_, otherEd25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating Ed25519 key: %v", err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating ECDSA key: %v", err)
+	}
+	a := newTestJwtAuthenticator(t, hmacSecret, ed25519Key)
+
+	// validClaims returns the claims that are accepted by a, modified by modify
+	validClaims := func(modify func(c *jwt.RegisteredClaims)) *jwt.RegisteredClaims {
+		c := &jwt.RegisteredClaims{
+			Subject:   "user-1",
+			Issuer:    "issuer",
+			Audience:  jwt.ClaimStrings{"audience"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		key    any
+		claims *jwt.RegisteredClaims
+		// userId is the expected subject, or empty if the token must be rejected.
+		userId string
+	}{
+		{
+			name:   "HS256",
+			method: jwt.SigningMethodHS256,
+			key:    []byte(hmacSecret),
+			claims: validClaims(nil),
+			userId: "user-1",
+		},
+		{
+			name:   "HS512",
+			method: jwt.SigningMethodHS512,
+			key:    []byte(hmacSecret),
+			claims: validClaims(nil),
+			userId: "user-1",
+		},
+		{
+			name:   "EdDSA",
+			method: jwt.SigningMethodEdDSA,
+			key:    ed25519Key,
+			claims: validClaims(nil),
+			userId: "user-1",
+		},
+		{
+			name:   "wrong HMAC secret",
+			method: jwt.SigningMethodHS256,
+			key:    []byte("wrong-secret"),
+			claims: validClaims(nil),
+		},
+		{
+			name:   "wrong Ed25519 key",
+			method: jwt.SigningMethodEdDSA,
+			key:    otherEd25519Key,
+			claims: validClaims(nil),
+		},
+		{
+			name:   "wrong alg",
+			method: jwt.SigningMethodES256,
+			key:    ecdsaKey,
+			claims: validClaims(nil),
+		},
+		{
+			name:   "alg none",
+			method: jwt.SigningMethodNone,
+			key:    jwt.UnsafeAllowNoneSignatureType,
+			claims: validClaims(nil),
+		},
+		{
+			name:   "expired",
+			method: jwt.SigningMethodHS256,
+			key:    []byte(hmacSecret),
+			claims: validClaims(func(c *jwt.RegisteredClaims) {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+			}),
+		},
+		{
+			name:   "no expiration time",
+			method: jwt.SigningMethodHS256,
+			key:    []byte(hmacSecret),
+			claims: validClaims(func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }),
+		},
+		{
+			name:   "issuer mismatch",
+			method: jwt.SigningMethodHS256,
+			key:    []byte(hmacSecret),
+			claims: validClaims(func(c *jwt.RegisteredClaims) { c.Issuer = "other-issuer" }),
+		},
+		{
+			name:   "no issuer",
+			method: jwt.SigningMethodHS256,
+			key:    []byte(hmacSecret),
+			claims: validClaims(func(c *jwt.RegisteredClaims) { c.Issuer = "" }),
+		},
+		{
+			name:   "audience mismatch",
+			method: jwt.SigningMethodHS256,
+			key:    []byte(hmacSecret),
+			claims: validClaims(func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other-audience"} }),
+		},
+		{
+			name:   "one of the audiences",
+			method: jwt.SigningMethodHS256,
+			key:    []byte(hmacSecret),
+			claims: validClaims(func(c *jwt.RegisteredClaims) {
+				c.Audience = jwt.ClaimStrings{"other-audience", "audience"}
+			}),
+			userId: "user-1",
+		},
+		{
+			name:   "missing subject",
+			method: jwt.SigningMethodHS256,
+			key:    []byte(hmacSecret),
+			claims: validClaims(func(c *jwt.RegisteredClaims) { c.Subject = "" }),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwt.NewWithClaims(tt.method, tt.claims).SignedString(tt.key)
+			if err != nil {
+				t.Fatalf("error signing token: %v", err)
+			}
+
+			p, err := a.authenticate(context.TODO(), "Bearer "+token)
+			if tt.userId == "" {
+				var authErr *authError
+				if !errors.As(err, &authErr) {
+					t.Fatalf("expected an *authError, got principal=%v, err=%v", p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("authenticate returned unexpected error: %v", err)
+			}
+			if p.userId != tt.userId || p.apiKey != nil {
+				t.Fatalf("wrong principal: expected user=%s, got=%+v", tt.userId, p)
+			}
+		})
+	}
+}
@@ -1,7 +1,29 @@
 package server
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHttpPort        = 8080
+	defaultGrpcPort        = 9090
+	defaultStorageType     = StorageTypeDatabase
+	defaultSessionTtl      = 24 * time.Hour
+	defaultMaxFailedLogins = 5
+	defaultLoginLockout    = 15 * time.Minute
+	defaultShutdownTimeout = 30 * time.Second
+	defaultShutdownDelay   = 5 * time.Second
+	defaultLogLevel        = "info"
+)
+
 const (
-	defaultHttpPort = 8080
+	// StorageTypeDatabase makes the server keep its data in the database at Config.DatabaseUrl.
+	StorageTypeDatabase = "database"
+	// StorageTypeMemory makes the server keep its data in memory, which is lost when the process exits.
+	StorageTypeMemory = "memory"
 )
 
 // Config is the server configuration. This enables us to change execution environment of the server
@@ -9,11 +31,93 @@ const (
 type Config struct {
 	HttpPort    int
 	DatabaseUrl string
+	StorageType string
+
+	// GrpcPort is the port to listen for gRPC requests on. The gRPC service is not served if it's zero.
+	GrpcPort int
+
+	// TrashRetentionDays is the number of days a deleted statistic is kept in the trash before it's purged.
+	// Deleted statistics are never purged automatically if it's zero.
+	TrashRetentionDays int
+
+	// AuthHmacSecret is the secret of the HMAC-signed bearer tokens that requests are authenticated with.
+	AuthHmacSecret string
+	// AuthEd25519PublicKeyFile is the path of the PEM-encoded public key of the Ed25519-signed bearer tokens
+	// that requests are authenticated with. Requests are not authenticated if neither this nor AuthHmacSecret
+	// is set.
+	AuthEd25519PublicKeyFile string
+	// AuthIssuer, if not empty, is the issuer that bearer tokens must have.
+	AuthIssuer string
+	// AuthAudience, if not empty, is the audience that bearer tokens must have.
+	AuthAudience string
+	// Accounts enables the user accounts, i.e. the registration, the logins and the API keys. Every request
+	// must be authenticated if it's enabled; otherwise, requests are authenticated only if the keys of JWTs
+	// are given, and are trusted with the user_id they specify if not. It's disabled by default, so that the
+	// existing clients, which don't authenticate, keep working.
+	Accounts bool
+
+	// SessionTtl is how long the session tokens issued by logins are valid.
+	SessionTtl time.Duration
+	// MaxFailedLogins is the number of consecutive failed logins after which a user is locked.
+	MaxFailedLogins int
+	// LoginLockout is how long a user cannot log in after it's locked.
+	LoginLockout time.Duration
+
+	// ShutdownTimeout is how long the ongoing requests are waited for to finish when the server is shut down,
+	// after which their connections are closed.
+	ShutdownTimeout time.Duration
+	// ShutdownDelay is how long the server keeps serving requests while it reports that it's not ready when it's
+	// shut down, so that it stops receiving new requests before it stops accepting them. It's skipped when the
+	// server is stopped because one of its listeners failed.
+	ShutdownDelay time.Duration
+
+	// LogLevel is the minimum level of the logs, as accepted by logrus.ParseLevel.
+	LogLevel string
 }
 
 // NewConfig returns a Config with sensible default values assigned to some fields.
 func NewConfig() *Config {
 	return &Config{
-		HttpPort: defaultHttpPort,
+		HttpPort:        defaultHttpPort,
+		GrpcPort:        defaultGrpcPort,
+		StorageType:     defaultStorageType,
+		SessionTtl:      defaultSessionTtl,
+		MaxFailedLogins: defaultMaxFailedLogins,
+		LoginLockout:    defaultLoginLockout,
+		ShutdownTimeout: defaultShutdownTimeout,
+		ShutdownDelay:   defaultShutdownDelay,
+		LogLevel:        defaultLogLevel,
+	}
+}
+
+// Validate checks that the values of c are valid, and returns an error describing the first invalid one.
+func (c *Config) Validate() error {
+	switch {
+	case c.HttpPort <= 0 || c.HttpPort > 65535:
+		return fmt.Errorf("http port must be between 1 and 65535, not %d", c.HttpPort)
+	case c.GrpcPort < 0 || c.GrpcPort > 65535:
+		return fmt.Errorf("grpc port must be between 0 and 65535, not %d", c.GrpcPort)
+	case c.GrpcPort == c.HttpPort:
+		return fmt.Errorf("grpc port cannot be the same as http port %d", c.HttpPort)
+	case c.StorageType != StorageTypeDatabase && c.StorageType != StorageTypeMemory:
+		return fmt.Errorf("storage must be either %q or %q, not %q", StorageTypeDatabase, StorageTypeMemory, c.StorageType)
+	case c.StorageType == StorageTypeDatabase && c.DatabaseUrl == "":
+		return fmt.Errorf("database url is required by %q storage", StorageTypeDatabase)
+	case c.TrashRetentionDays < 0:
+		return fmt.Errorf("trash retention days cannot be negative")
+	case c.SessionTtl <= 0:
+		return fmt.Errorf("session ttl must be positive")
+	case c.MaxFailedLogins <= 0:
+		return fmt.Errorf("max failed logins must be positive")
+	case c.LoginLockout < 0:
+		return fmt.Errorf("login lockout cannot be negative")
+	case c.ShutdownTimeout <= 0:
+		return fmt.Errorf("shutdown timeout must be positive")
+	case c.ShutdownDelay < 0:
+		return fmt.Errorf("shutdown delay cannot be negative")
+	}
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		return err
 	}
+	return nil
 }
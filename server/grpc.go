@@ -0,0 +1,308 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"github.com/umutozd/stats-keeper/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// grpcReadMethods are the methods of StatsKeeperService that don't modify anything, which are the only ones
+// that read-only API keys can call.
+var grpcReadMethods = map[string]bool{
+	"/com.statskeeper.v1.StatsKeeperService/ListUserStatistics": true,
+	"/com.statskeeper.v1.StatsKeeperService/GetStatistic":       true,
+	"/com.statskeeper.v1.StatsKeeperService/ListHistory":        true,
+}
+
+// newGrpcServer creates the gRPC server of StatsKeeperService.
+func (s *Server) newGrpcServer() *grpc.Server {
+	srv := grpc.NewServer(grpc.UnaryInterceptor(s.interceptGrpc))
+	statspb.RegisterStatsKeeperServiceServer(srv, &grpcService{db: s.db})
+	return srv
+}
+
+// ListenGRPC initiates the gRPC listening and serving incoming requests. It returns when the server is shut
+// down by Shutdown, or if it cannot listen.
+func (s *Server) ListenGRPC() error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.cfg.GrpcPort))
+	if err != nil {
+		return fmt.Errorf("error listening for grpc: %w", err)
+	}
+
+	logrus.Infof("serving grpc on :%d", s.cfg.GrpcPort)
+	if err = s.grpcServer.Serve(lis); err != grpc.ErrServerStopped {
+		return err
+	}
+	return nil
+}
+
+// interceptGrpc authenticates the gRPC requests like authenticateRequest and logs them like ServeHTTP.
+func (s *Server) interceptGrpc(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("interceptGrpc: recovered from panic: %v", r)
+			err = status.Error(codes.Internal, "server unavailable")
+		}
+	}()
+
+	start := time.Now()
+	if ctx, err = s.authenticateGrpc(ctx, info.FullMethod); err == nil {
+		resp, err = handler(ctx, req)
+	}
+	end := time.Now()
+
+	logrus.Infof("grpc %s %s, %s", info.FullMethod, end.Sub(start), status.Code(err))
+	return resp, err
+}
+
+// authenticateGrpc authenticates the request with ctx to the given method, and returns ctx with its principal.
+func (s *Server) authenticateGrpc(ctx context.Context, method string) (context.Context, error) {
+	p, err := s.auth.authenticate(ctx, metadataValue(ctx, "authorization"))
+	if err != nil {
+		if _, ok := err.(*authError); !ok {
+			return nil, toGrpcError(err)
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "unauthenticated: %v", err)
+	}
+	if p == nil {
+		return ctx, nil
+	}
+	if p.apiKey != nil && p.apiKey.Scope != statspb.ApiKey_READ_WRITE && !grpcReadMethods[method] {
+		return nil, status.Error(codes.PermissionDenied, "API key is read-only")
+	}
+	return context.WithValue(ctx, principalContextKey{}, p), nil
+}
+
+// metadataValue returns the first value of the incoming metadata of ctx with the given key, or an empty string
+// if there is no such metadata.
+func metadataValue(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcUserId returns the id of the user whose statistics the request with ctx accesses, as described in
+// userIdFor. If requested is empty, it's the "user-id" metadata of the request.
+func grpcUserId(ctx context.Context, requested, entityId string) (string, error) {
+	if requested == "" {
+		requested = metadataValue(ctx, "user-id")
+	}
+	userId, err := userIdFor(ctx, requested, entityId)
+	if err != nil {
+		code := codes.PermissionDenied
+		if err.status == http.StatusBadRequest {
+			code = codes.InvalidArgument
+		}
+		return "", status.Error(code, err.message)
+	}
+	return userId, nil
+}
+
+// grpcService implements statspb.StatsKeeperServiceServer by delegating to StatsKeeperStorage. The requests
+// are validated like the HTTP requests of the same operations.
+type grpcService struct {
+	statspb.UnimplementedStatsKeeperServiceServer
+	db storage.StatsKeeperStorage
+}
+
+func (g *grpcService) ListUserStatistics(ctx context.Context, req *statspb.ListUserStatisticsRequest) (*statspb.ListUserStatisticsResponse, error) {
+	var err error
+	if req.UserId, err = grpcUserId(ctx, req.UserId, ""); err != nil {
+		return nil, err
+	}
+	resp, err := g.db.ListUserStatistics(ctx, req)
+	if err != nil {
+		return nil, toGrpcError(err)
+	}
+	return resp, nil
+}
+
+func (g *grpcService) GetStatistic(ctx context.Context, req *statspb.GetStatisticRequest) (*statspb.StatisticEntity, error) {
+	if req.EntityId == "" {
+		return nil, status.Error(codes.InvalidArgument, "entity_id cannot be empty")
+	}
+	userId, err := grpcUserId(ctx, "", req.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	return toGrpcResult(g.db.GetStatistic(ctx, userId, req.EntityId))
+}
+
+func (g *grpcService) CreateStatistic(ctx context.Context, req *statspb.StatisticEntity) (*statspb.StatisticEntity, error) {
+	if c, _ := storage.ComponentOf(req); req.Name == "" || c == nil {
+		return nil, status.Error(codes.InvalidArgument, "name and component cannot be empty")
+	}
+	var err error
+	if req.UserId, err = grpcUserId(ctx, req.UserId, ""); err != nil {
+		return nil, err
+	}
+	return toGrpcResult(g.db.CreateStatistic(ctx, req))
+}
+
+func (g *grpcService) UpdateStatistic(ctx context.Context, req *statspb.UpdateStatisticRequest) (*statspb.StatisticEntity, error) {
+	if req.Fields == nil || len(req.Fields.Paths) == 0 || req.Values == nil {
+		return nil, status.Error(codes.InvalidArgument, "fields.paths and values must be non-empty or non-null")
+	}
+	userId, err := grpcUserId(ctx, "", req.Values.Id)
+	if err != nil {
+		return nil, err
+	}
+	return toGrpcResult(g.db.UpdateStatistic(ctx, userId, req.Fields.Paths, req.Values, req.ExpectedVersion))
+}
+
+func (g *grpcService) DeleteStatistic(ctx context.Context, req *statspb.DeleteStatisticRequest) (*emptypb.Empty, error) {
+	if req.EntityId == "" {
+		return nil, status.Error(codes.InvalidArgument, "entity_id cannot be empty")
+	}
+	userId, err := grpcUserId(ctx, "", req.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	if err = g.db.DeleteStatistic(ctx, userId, req.EntityId, req.ExpectedVersion); err != nil {
+		return nil, toGrpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g *grpcService) IncrementCounter(ctx context.Context, req *statspb.IncrementCounterRequest) (*statspb.StatisticEntity, error) {
+	if req.EntityId == "" {
+		return nil, status.Error(codes.InvalidArgument, "entity_id cannot be empty")
+	}
+	userId, err := grpcUserId(ctx, "", req.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	return toGrpcResult(g.db.IncrementCounter(ctx, userId, req.EntityId, req.Delta))
+}
+
+func (g *grpcService) AppendTimestamps(ctx context.Context, req *statspb.AppendTimestampsRequest) (*statspb.StatisticEntity, error) {
+	if req.EntityId == "" || len(req.Timestamps) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "entity_id and timestamps cannot be empty")
+	}
+	userId, err := grpcUserId(ctx, "", req.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	return toGrpcResult(g.db.AppendTimestamps(ctx, userId, req.EntityId, req.Timestamps, req.Unique))
+}
+
+func (g *grpcService) RemoveTimestamps(ctx context.Context, req *statspb.RemoveTimestampsRequest) (*statspb.StatisticEntity, error) {
+	if req.EntityId == "" || len(req.Timestamps) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "entity_id and timestamps cannot be empty")
+	}
+	userId, err := grpcUserId(ctx, "", req.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	return toGrpcResult(g.db.RemoveTimestamps(ctx, userId, req.EntityId, req.Timestamps))
+}
+
+func (g *grpcService) RemoveTimestampRange(ctx context.Context, req *statspb.RemoveTimestampRangeRequest) (*statspb.StatisticEntity, error) {
+	if req.EntityId == "" || req.From == nil || req.To == nil {
+		return nil, status.Error(codes.InvalidArgument, "entity_id, from and to cannot be empty")
+	}
+	userId, err := grpcUserId(ctx, "", req.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	return toGrpcResult(g.db.RemoveTimestampRange(ctx, userId, req.EntityId, req.From, req.To))
+}
+
+func (g *grpcService) RestoreStatistic(ctx context.Context, req *statspb.RestoreStatisticRequest) (*statspb.StatisticEntity, error) {
+	if req.EntityId == "" {
+		return nil, status.Error(codes.InvalidArgument, "entity_id cannot be empty")
+	}
+	userId, err := grpcUserId(ctx, "", req.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	return toGrpcResult(g.db.RestoreStatistic(ctx, userId, req.EntityId))
+}
+
+func (g *grpcService) PurgeStatistic(ctx context.Context, req *statspb.PurgeStatisticRequest) (*emptypb.Empty, error) {
+	if req.EntityId == "" {
+		return nil, status.Error(codes.InvalidArgument, "entity_id cannot be empty")
+	}
+	userId, err := grpcUserId(ctx, "", req.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	if err = g.db.PurgeStatistic(ctx, userId, req.EntityId); err != nil {
+		return nil, toGrpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g *grpcService) ListHistory(ctx context.Context, req *statspb.ListHistoryRequest) (*statspb.ListHistoryResponse, error) {
+	if req.EntityId == "" {
+		return nil, status.Error(codes.InvalidArgument, "entity_id cannot be empty")
+	}
+	userId, err := grpcUserId(ctx, "", req.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.db.ListHistory(ctx, userId, req)
+	if err != nil {
+		return nil, toGrpcError(err)
+	}
+	return resp, nil
+}
+
+func (g *grpcService) RevertStatistic(ctx context.Context, req *statspb.RevertStatisticRequest) (*statspb.StatisticEntity, error) {
+	if req.EntityId == "" {
+		return nil, status.Error(codes.InvalidArgument, "entity_id cannot be empty")
+	}
+	userId, err := grpcUserId(ctx, "", req.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	return toGrpcResult(g.db.RevertStatistic(ctx, userId, req))
+}
+
+// toGrpcError converts the storage error err to the gRPC status error with the code of its ApiError and its
+// message, and logs its internal details, which are not sent to the client like in the HTTP responses.
+func toGrpcError(err error) error {
+	_, apiErr, internalErr := storage.ToApiError(err)
+	if internalErr != nil {
+		logrus.WithError(internalErr).Warn("grpc: storage error")
+	}
+	return status.Error(grpcCode(apiErr.Code), apiErr.Message)
+}
+
+// grpcCode returns the gRPC code of the ApiError code of a storage error.
+func grpcCode(code statspb.ApiError_Code) codes.Code {
+	switch code {
+	case statspb.ApiError_INVALID_ARGUMENT, statspb.ApiError_NO_UPDATE:
+		return codes.InvalidArgument
+	case statspb.ApiError_NOT_FOUND:
+		return codes.NotFound
+	case statspb.ApiError_INTERNAL:
+		return codes.Internal
+	case statspb.ApiError_CONFLICT:
+		return codes.Aborted
+	default:
+		return codes.Unknown
+	}
+}
+
+// toGrpcResult returns the result of a storage method that returns an entity, with its error converted to a
+// gRPC status error.
+func toGrpcResult(entity *statspb.StatisticEntity, err error) (*statspb.StatisticEntity, error) {
+	if err != nil {
+		return nil, toGrpcError(err)
+	}
+	return entity, nil
+}
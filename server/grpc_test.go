@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newTestGrpcClient serves the gRPC API of srv in memory until the test ends, and returns a client of it.
+func newTestGrpcClient(t *testing.T, srv *Server) statspb.StatsKeeperServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	go func() { _ = srv.grpcServer.Serve(lis) }()
+	t.Cleanup(srv.grpcServer.Stop)
+
+	dial := func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("error dialing grpc server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return statspb.NewStatsKeeperServiceClient(conn)
+}
+
+// withAuthorization returns a context whose gRPC requests have the given authorization metadata.
+func withAuthorization(authorization string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", authorization)
+}
+
+// newCounterStat returns a new counter statistic with the given name.
+func newCounterStat(name string) *statspb.StatisticEntity {
+	return &statspb.StatisticEntity{Name: name, Component: &statspb.StatisticEntity_Counter{Counter: &statspb.ComponentCounter{}}}
+}
+
+// withUserId returns a context whose gRPC requests have the given user-id metadata.
+func withUserId(userId string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "user-id", userId)
+}
+
+// checkGrpcCode fails the test if err doesn't have the expected code.
+func checkGrpcCode(t *testing.T, method string, err error, expected codes.Code) {
+	t.Helper()
+	if code := status.Code(err); code != expected {
+		t.Fatalf("wrong code of %s: expected=%s, got=%s (%v)", method, expected, code, err)
+	}
+}
+
+func Test_grpcService(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	client := newTestGrpcClient(t, srv)
+	ctx := withUserId("user-1")
+
+	_, err := client.CreateStatistic(ctx, &statspb.StatisticEntity{Name: "no component"})
+	checkGrpcCode(t, "CreateStatistic without a component", err, codes.InvalidArgument)
+	_, err = client.CreateStatistic(context.Background(), newCounterStat("counter"))
+	checkGrpcCode(t, "CreateStatistic without a user", err, codes.InvalidArgument)
+
+	counter, err := client.CreateStatistic(ctx, newCounterStat("counter"))
+	checkGrpcCode(t, "CreateStatistic", err, codes.OK)
+	if counter.Id == "" || counter.UserId != "user-1" || counter.Version != 1 {
+		t.Fatalf("wrong created statistic: %v", counter)
+	}
+	got, err := client.GetStatistic(ctx, &statspb.GetStatisticRequest{EntityId: counter.Id})
+	checkGrpcCode(t, "GetStatistic", err, codes.OK)
+	if !proto.Equal(counter, got) {
+		t.Fatalf("wrong statistic: expected=%v, got=%v", counter, got)
+	}
+	_, err = client.GetStatistic(ctx, &statspb.GetStatisticRequest{})
+	checkGrpcCode(t, "GetStatistic without an entity_id", err, codes.InvalidArgument)
+	_, err = client.GetStatistic(ctx, &statspb.GetStatisticRequest{EntityId: "unknown"})
+	checkGrpcCode(t, "GetStatistic of an unknown statistic", err, codes.NotFound)
+	_, err = client.GetStatistic(withUserId("user-2"), &statspb.GetStatisticRequest{EntityId: counter.Id})
+	checkGrpcCode(t, "GetStatistic of another user", err, codes.NotFound)
+
+	list, err := client.ListUserStatistics(ctx, &statspb.ListUserStatisticsRequest{})
+	checkGrpcCode(t, "ListUserStatistics", err, codes.OK)
+	if len(list.Entities) != 1 || list.Entities[0].Id != counter.Id {
+		t.Fatalf("wrong statistics: %v", list.Entities)
+	}
+
+	update := &statspb.UpdateStatisticRequest{
+		Fields: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+		Values: &statspb.StatisticEntity{Id: counter.Id, Name: "renamed"},
+	}
+	updated, err := client.UpdateStatistic(ctx, update)
+	checkGrpcCode(t, "UpdateStatistic", err, codes.OK)
+	if updated.Name != "renamed" || updated.Version != 2 {
+		t.Fatalf("wrong updated statistic: %v", updated)
+	}
+	update.ExpectedVersion = 1
+	_, err = client.UpdateStatistic(ctx, update)
+	checkGrpcCode(t, "UpdateStatistic of an old version", err, codes.Aborted)
+	_, err = client.UpdateStatistic(ctx, &statspb.UpdateStatisticRequest{Values: update.Values})
+	checkGrpcCode(t, "UpdateStatistic without fields", err, codes.InvalidArgument)
+
+	incremented, err := client.IncrementCounter(ctx, &statspb.IncrementCounterRequest{EntityId: counter.Id, Delta: 2})
+	checkGrpcCode(t, "IncrementCounter", err, codes.OK)
+	if incremented.GetCounter().GetCount() != 2 {
+		t.Fatalf("wrong incremented statistic: %v", incremented)
+	}
+
+	date, err := client.CreateStatistic(ctx, &statspb.StatisticEntity{
+		Name:      "date",
+		Component: &statspb.StatisticEntity_Date{Date: &statspb.ComponentDate{}},
+	})
+	checkGrpcCode(t, "CreateStatistic", err, codes.OK)
+	_, err = client.IncrementCounter(ctx, &statspb.IncrementCounterRequest{EntityId: date.Id, Delta: 1})
+	checkGrpcCode(t, "IncrementCounter of a date", err, codes.InvalidArgument)
+	timestamps := []*timestamppb.Timestamp{{Seconds: 3}, {Seconds: 1}, {Seconds: 2}}
+	appended, err := client.AppendTimestamps(ctx, &statspb.AppendTimestampsRequest{EntityId: date.Id, Timestamps: timestamps})
+	checkGrpcCode(t, "AppendTimestamps", err, codes.OK)
+	if got := appended.GetDate().GetTimestamps(); len(got) != 3 || got[0].Seconds != 1 || got[2].Seconds != 3 {
+		t.Fatalf("wrong timestamps after AppendTimestamps: %v", got)
+	}
+	removed, err := client.RemoveTimestamps(ctx, &statspb.RemoveTimestampsRequest{EntityId: date.Id, Timestamps: timestamps[:1]})
+	checkGrpcCode(t, "RemoveTimestamps", err, codes.OK)
+	if got := removed.GetDate().GetTimestamps(); len(got) != 2 || got[1].Seconds != 2 {
+		t.Fatalf("wrong timestamps after RemoveTimestamps: %v", got)
+	}
+	removed, err = client.RemoveTimestampRange(ctx, &statspb.RemoveTimestampRangeRequest{EntityId: date.Id, From: timestamps[1], To: timestamps[2]})
+	checkGrpcCode(t, "RemoveTimestampRange", err, codes.OK)
+	if got := removed.GetDate().GetTimestamps(); len(got) != 0 {
+		t.Fatalf("wrong timestamps after RemoveTimestampRange: %v", got)
+	}
+	_, err = client.AppendTimestamps(ctx, &statspb.AppendTimestampsRequest{EntityId: date.Id})
+	checkGrpcCode(t, "AppendTimestamps without timestamps", err, codes.InvalidArgument)
+
+	history, err := client.ListHistory(ctx, &statspb.ListHistoryRequest{EntityId: counter.Id})
+	checkGrpcCode(t, "ListHistory", err, codes.OK)
+	if len(history.Events) != 3 {
+		t.Fatalf("wrong number of history events: expected=3, got=%d", len(history.Events))
+	}
+	reverted, err := client.RevertStatistic(ctx, &statspb.RevertStatisticRequest{EntityId: counter.Id, Version: 1})
+	checkGrpcCode(t, "RevertStatistic", err, codes.OK)
+	if reverted.Name != counter.Name || reverted.GetCounter().GetCount() != 0 {
+		t.Fatalf("wrong reverted statistic: %v", reverted)
+	}
+
+	_, err = client.DeleteStatistic(ctx, &statspb.DeleteStatisticRequest{EntityId: counter.Id})
+	checkGrpcCode(t, "DeleteStatistic", err, codes.OK)
+	_, err = client.GetStatistic(ctx, &statspb.GetStatisticRequest{EntityId: counter.Id})
+	checkGrpcCode(t, "GetStatistic of a deleted statistic", err, codes.NotFound)
+	_, err = client.RestoreStatistic(ctx, &statspb.RestoreStatisticRequest{EntityId: counter.Id})
+	checkGrpcCode(t, "RestoreStatistic", err, codes.OK)
+	_, err = client.PurgeStatistic(ctx, &statspb.PurgeStatisticRequest{EntityId: counter.Id})
+	checkGrpcCode(t, "PurgeStatistic of a statistic that is not deleted", err, codes.NotFound)
+	_, err = client.DeleteStatistic(ctx, &statspb.DeleteStatisticRequest{EntityId: counter.Id})
+	checkGrpcCode(t, "DeleteStatistic", err, codes.OK)
+	_, err = client.PurgeStatistic(ctx, &statspb.PurgeStatisticRequest{EntityId: counter.Id})
+	checkGrpcCode(t, "PurgeStatistic", err, codes.OK)
+	_, err = client.RestoreStatistic(ctx, &statspb.RestoreStatisticRequest{EntityId: counter.Id})
+	checkGrpcCode(t, "RestoreStatistic of a purged statistic", err, codes.NotFound)
+}
+
+func Test_Server_interceptGrpc(t *testing.T) {
+	const hmacSecret = "secret"
+	srv, ts := newTestServer(t, func(cfg *Config) {
+		cfg.Accounts = true
+		cfg.AuthHmacSecret = hmacSecret
+	})
+	client := newTestGrpcClient(t, srv)
+	registerTestUser(t, ts, "alice", "password")
+	_, session := login(t, ts, "alice", "password")
+
+	signed := func(claims *jwt.RegisteredClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(hmacSecret))
+		if err != nil {
+			t.Fatalf("error signing token: %v", err)
+		}
+		return "Bearer " + token
+	}
+	expiresAt := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	jwtToken := signed(&jwt.RegisteredClaims{Subject: "user-1", ExpiresAt: expiresAt})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{name: "no credentials", ctx: context.Background(), code: codes.Unauthenticated},
+		{name: "trusted user id", ctx: withUserId("user-1"), code: codes.Unauthenticated},
+		{name: "invalid scheme", ctx: withAuthorization("Basic abc"), code: codes.Unauthenticated},
+		{name: "invalid session token", ctx: withAuthorization("Bearer invalid"), code: codes.Unauthenticated},
+		{name: "invalid API key", ctx: withAuthorization("ApiKey sk_invalid"), code: codes.Unauthenticated},
+		{name: "expired JWT", ctx: withAuthorization(signed(&jwt.RegisteredClaims{
+			Subject:   "user-1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		})), code: codes.Unauthenticated},
+		{name: "session token", ctx: withAuthorization("Bearer " + session.AccessToken), code: codes.OK},
+		{name: "JWT", ctx: withAuthorization(jwtToken), code: codes.OK},
+		{
+			name: "JWT with another user id",
+			ctx:  metadata.AppendToOutgoingContext(withAuthorization(jwtToken), "user-id", "user-2"),
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "JWT with its user id",
+			ctx:  metadata.AppendToOutgoingContext(withAuthorization(jwtToken), "user-id", "user-1"),
+			code: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.ListUserStatistics(tt.ctx, &statspb.ListUserStatisticsRequest{})
+			checkGrpcCode(t, "ListUserStatistics", err, tt.code)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/umutozd/stats-keeper/storage"
+)
+
+const (
+	// livenessPath is the path that tells whether the process is alive, i.e. it can serve HTTP at all.
+	livenessPath = "/healthz"
+	// readinessPath is the path that tells whether the server is ready to serve requests.
+	readinessPath = "/readyz"
+
+	// readinessTimeout is how long the checks of a readiness request can take.
+	readinessTimeout = 5 * time.Second
+
+	healthStatusOk          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+// healthStatus is the body of the responses to the liveness and readiness requests. The status of a readiness
+// response is ok only if all of its checks are ok.
+type healthStatus struct {
+	Status string `json:"status"`
+	// Error describes why the status is not ok.
+	Error string `json:"error,omitempty"`
+	// Checks are the statuses of the dependencies of the server by their names.
+	Checks map[string]*healthStatus `json:"checks,omitempty"`
+}
+
+// Healthz responds with 200 as long as the server is able to handle requests.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	if !validateRequestMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	writeHealthStatus(w, &healthStatus{Status: healthStatusOk})
+}
+
+// Readyz responds with 200 if the server and its dependencies are ready to serve requests, and with 503
+// otherwise, e.g. when the storage is unreachable or the server is shutting down.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	if !validateRequestMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	resp := &healthStatus{
+		Status: healthStatusOk,
+		Checks: map[string]*healthStatus{
+			"server":  {Status: healthStatusOk},
+			"storage": {Status: healthStatusOk},
+		},
+	}
+	if s.shuttingDown.Load() {
+		resp.Checks["server"] = &healthStatus{Status: healthStatusUnavailable, Error: "server is shutting down"}
+	}
+	if err := s.db.Ping(ctx); err != nil {
+		_, ae, internalErr := storage.ToApiError(err)
+		logrus.WithError(internalErr).Warnf("Readyz: %s", ae.Message)
+		resp.Checks["storage"] = &healthStatus{Status: healthStatusUnavailable, Error: ae.Message}
+	}
+	for _, check := range resp.Checks {
+		if check.Status != healthStatusOk {
+			resp.Status = healthStatusUnavailable
+		}
+	}
+	writeHealthStatus(w, resp)
+}
+
+// BeginShutdown makes the server report that it's not ready, so that it stops receiving new requests before
+// it's shut down.
+func (s *Server) BeginShutdown() {
+	s.shuttingDown.Store(true)
+}
+
+// writeHealthStatus writes status to w as JSON, with 200 if it's ok and with 503 otherwise.
+func writeHealthStatus(w http.ResponseWriter, status *healthStatus) {
+	statusCode := http.StatusOK
+	if status.Status != healthStatusOk {
+		statusCode = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", contentTypeJson)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logrus.WithError(err).Error("writeHealthStatus: error writing http response")
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+)
+
+func (s *Server) GetHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := &statspb.ListHistoryRequest{
+		EntityId:  q.Get("entity_id"),
+		PageToken: q.Get("page_token"),
+	}
+	if req.EntityId == "" {
+		writeInvalidFieldsResponse(w, "entity_id cannot be empty", "entity_id")
+		return
+	}
+	if pageSize := q.Get("page_size"); pageSize != "" {
+		value, err := strconv.ParseInt(pageSize, 10, 32)
+		if err != nil {
+			writeInvalidFieldsResponse(w, "invalid page_size", "page_size")
+			return
+		}
+		req.PageSize = int32(value)
+	}
+
+	userId := userIdOf(w, r, q.Get("user_id"), req.EntityId)
+	if userId == "" {
+		return
+	}
+
+	resp, err := s.db.ListHistory(r.Context(), userId, req)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsPath is the path that the metrics are served on in the Prometheus text exposition format.
+const metricsPath = "/metrics"
+
+// httpMetrics are the metrics of the HTTP requests that ServeHTTP handles. Requests are labeled with the
+// pattern of their route rather than their path, so that path parameters don't create a time series each.
+type httpMetrics struct {
+	requests     *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	bytesWritten *prometheus.CounterVec
+	inFlight     prometheus.Gauge
+}
+
+// newHttpMetrics creates the httpMetrics and registers them to reg.
+func newHttpMetrics(reg prometheus.Registerer) (*httpMetrics, error) {
+	labels := []string{"route", "method", "status"}
+	m := &httpMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "stats_keeper",
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of handled HTTP requests.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "stats_keeper",
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of the HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "stats_keeper",
+			Subsystem: "http",
+			Name:      "response_bytes_total",
+			Help:      "Number of bytes written to the HTTP response bodies.",
+		}, labels),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "stats_keeper",
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "Number of HTTP requests that are being handled.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.requests, m.duration, m.bytesWritten, m.inFlight} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// observe records a request to route with method that was handled in duration, and its response with status
+// and bytesWritten bytes. route is the pattern of the route of the request, or empty if it has no route.
+func (m *httpMetrics) observe(route, method string, status int, duration time.Duration, bytesWritten int) {
+	if route == "" {
+		route = "unmatched"
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		// methods are chosen by the clients, so unknown ones share a label
+		method = "OTHER"
+	}
+	labels := prometheus.Labels{"route": route, "method": method, "status": strconv.Itoa(status)}
+	m.requests.With(labels).Inc()
+	m.duration.With(labels).Observe(duration.Seconds())
+	m.bytesWritten.With(labels).Add(float64(bytesWritten))
+}
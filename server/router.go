@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// router dispatches the requests to the handlers of their methods and paths. Paths are patterns whose segments
+// are either literal or a parameter like {id}, which matches any non-empty segment and is read by pathParam.
+// If multiple patterns match a path, literal segments take precedence over parameters from left to right, e.g.
+// /stats/batch over /stats/{id}, regardless of the order the patterns are registered in. Requests whose path
+// matches some patterns but none of them with their method get a 405 response with the Allow header, other
+// unmatched requests get a 404 response.
+type router struct {
+	routes []*route
+}
+
+// route is a path pattern and the handlers of its methods.
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.HandlerFunc
+	// methods are the keys of handlers in the order they're added.
+	methods []string
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+// handle registers handler for the requests with the given method and path pattern.
+func (rt *router) handle(method, pattern string, handler http.HandlerFunc) {
+	segments := splitPath(pattern)
+	var rte *route
+	for _, existing := range rt.routes {
+		if equalSegments(existing.segments, segments) {
+			rte = existing
+			break
+		}
+	}
+	if rte == nil {
+		rte = &route{pattern: pattern, segments: segments, handlers: map[string]http.HandlerFunc{}}
+		rt.routes = append(rt.routes, rte)
+	}
+	if _, ok := rte.handlers[method]; ok {
+		panic("router: multiple handlers for " + method + " " + pattern)
+	}
+	rte.handlers[method] = handler
+	rte.methods = append(rte.methods, method)
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+	rte, params := rt.find(segments, r.Method)
+	if rte == nil {
+		if allowed := rt.allowedMethods(segments); len(allowed) != 0 {
+			writeMethodNotAllowed(w, allowed)
+			return
+		}
+		writeErrorResponse(w, http.StatusNotFound, "not found", nil)
+		return
+	}
+	if len(params) != 0 {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsContextKey{}, params))
+	}
+	rte.handlers[r.Method](w, r)
+}
+
+// routeOf returns the pattern of the route of the requests with the given method and path, or an empty string
+// if the path has no route. If none of the routes of the path has method, it's the route that the path would
+// have with any method.
+func (rt *router) routeOf(method, path string) string {
+	segments := splitPath(path)
+	rte, _ := rt.find(segments, method)
+	if rte == nil {
+		rte, _ = rt.find(segments, "")
+	}
+	if rte == nil {
+		return ""
+	}
+	return rte.pattern
+}
+
+// find returns the route that matches segments with the highest precedence among the ones that have a handler
+// of method, or among all routes if method is empty, along with the parameters in segments. It returns nil if
+// there is no such route.
+func (rt *router) find(segments []string, method string) (*route, map[string]string) {
+	var found *route
+	var foundParams map[string]string
+	for _, rte := range rt.routes {
+		if _, ok := rte.handlers[method]; !ok && method != "" {
+			continue
+		}
+		params, ok := rte.match(segments)
+		if ok && (found == nil || rte.precedes(found)) {
+			found, foundParams = rte, params
+		}
+	}
+	return found, foundParams
+}
+
+// allowedMethods returns the methods of all routes that match segments, in the order they're registered.
+func (rt *router) allowedMethods(segments []string) []string {
+	var allowed []string
+	seen := map[string]bool{}
+	for _, rte := range rt.routes {
+		if _, ok := rte.match(segments); !ok {
+			continue
+		}
+		for _, method := range rte.methods {
+			if !seen[method] {
+				seen[method] = true
+				allowed = append(allowed, method)
+			}
+		}
+	}
+	return allowed
+}
+
+// precedes reports whether rte takes precedence over other, which has as many segments, since it has a literal
+// segment where other has a parameter before any other difference.
+func (rte *route) precedes(other *route) bool {
+	for i, segment := range rte.segments {
+		_, isParam := paramName(segment)
+		_, otherIsParam := paramName(other.segments[i])
+		if isParam != otherIsParam {
+			return otherIsParam
+		}
+	}
+	return false
+}
+
+// match returns the parameters in segments if they match the pattern of rte.
+func (rte *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rte.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, segment := range rte.segments {
+		if name, ok := paramName(segment); ok {
+			if segments[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[name] = segments[i]
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// pathParamsContextKey is the context key of the path parameters of the request.
+type pathParamsContextKey struct{}
+
+// pathParam returns the value of the path parameter with the given name in r, or an empty string if the
+// pattern of r doesn't have such a parameter.
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsContextKey{}).(map[string]string)
+	return params[name]
+}
+
+// paramName returns the name of the parameter if segment is a parameter like {id}.
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// splitPath returns the segments of path, e.g. ["api", "v1", "stats"] for /api/v1/stats.
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+func equalSegments(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testRoute is a route that is registered to the routers under test.
+type testRoute struct {
+	method  string
+	pattern string
+}
+
+// newTestRouter returns a router with routes, whose handlers write their route and the id path parameter.
+func newTestRouter(routes []testRoute) *router {
+	rt := newRouter()
+	for _, r := range routes {
+		name := r.method + " " + r.pattern
+		rt.handle(r.method, r.pattern, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s id=%s", name, pathParam(r, "id"))
+		})
+	}
+	return rt
+}
+
+func Test_router_ServeHTTP(t *testing.T) {
+	routes := []testRoute{
+		{http.MethodGet, "/stats/{id}"},
+		{http.MethodDelete, "/stats/{id}"},
+		{http.MethodPost, "/stats/batch"},
+		{http.MethodGet, "/stats/{id}/history"},
+		{http.MethodGet, "/users/{user}/stats"},
+	}
+	tests := []struct {
+		method string
+		path   string
+		status int
+		// body is the body written by the handler, if the request is handled.
+		body string
+		// allow is the Allow header of a 405 response.
+		allow string
+	}{
+		{method: http.MethodGet, path: "/stats/1", status: http.StatusOK, body: "GET /stats/{id} id=1"},
+		{method: http.MethodDelete, path: "/stats/1", status: http.StatusOK, body: "DELETE /stats/{id} id=1"},
+		{method: http.MethodGet, path: "/stats/1/history", status: http.StatusOK, body: "GET /stats/{id}/history id=1"},
+		{method: http.MethodGet, path: "/users/u/stats", status: http.StatusOK, body: "GET /users/{user}/stats id="},
+		// the literal segment takes precedence over the parameter
+		{method: http.MethodPost, path: "/stats/batch", status: http.StatusOK, body: "POST /stats/batch id="},
+		// the parameter still matches the literal segment with the methods of the parameter's route
+		{method: http.MethodGet, path: "/stats/batch", status: http.StatusOK, body: "GET /stats/{id} id=batch"},
+		{method: http.MethodPost, path: "/stats/1", status: http.StatusMethodNotAllowed, allow: "GET, DELETE"},
+		{method: http.MethodPut, path: "/stats/batch", status: http.StatusMethodNotAllowed, allow: "GET, DELETE, POST"},
+		{method: http.MethodPost, path: "/stats/1/history", status: http.StatusMethodNotAllowed, allow: "GET"},
+		{method: http.MethodGet, path: "/stats/", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/stats", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/stats/1/2", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/users//stats", status: http.StatusNotFound},
+		{method: http.MethodPost, path: "/unknown", status: http.StatusNotFound},
+	}
+
+	// the precedence doesn't depend on the order the routes are registered in
+	reversed := make([]testRoute, len(routes))
+	for i, r := range routes {
+		reversed[len(routes)-1-i] = r
+	}
+	for name, rt := range map[string]*router{"registered": newTestRouter(routes), "reversed": newTestRouter(reversed)} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s %s %s", name, tt.method, tt.path), func(t *testing.T) {
+				w := httptest.NewRecorder()
+				rt.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+				if w.Code != tt.status {
+					t.Fatalf("wrong status: expected=%d, got=%d", tt.status, w.Code)
+				}
+				if tt.body != "" && w.Body.String() != tt.body {
+					t.Fatalf("wrong body: expected=%q, got=%q", tt.body, w.Body.String())
+				}
+				if tt.status != http.StatusMethodNotAllowed {
+					return
+				}
+				// the order of the methods in the Allow header is their registration order
+				if name == "registered" && w.Header().Get("Allow") != tt.allow {
+					t.Fatalf("wrong Allow header: expected=%q, got=%q", tt.allow, w.Header().Get("Allow"))
+				}
+				if w.Header().Get("Allow") == "" {
+					t.Fatalf("405 response doesn't have an Allow header")
+				}
+			})
+		}
+	}
+}
+
+func Test_router_routeOf(t *testing.T) {
+	rt := newTestRouter([]testRoute{
+		{http.MethodGet, "/stats/{id}"},
+		{http.MethodPost, "/stats/batch"},
+		{http.MethodGet, "/stats/list"},
+	})
+	tests := []struct {
+		method string
+		path   string
+		route  string
+	}{
+		{method: http.MethodGet, path: "/stats/1", route: "/stats/{id}"},
+		{method: http.MethodGet, path: "/stats/list", route: "/stats/list"},
+		{method: http.MethodPost, path: "/stats/batch", route: "/stats/batch"},
+		{method: http.MethodGet, path: "/stats/batch", route: "/stats/{id}"},
+		// the label of a 405 response is the route of the path with any method
+		{method: http.MethodDelete, path: "/stats/1", route: "/stats/{id}"},
+		{method: http.MethodDelete, path: "/stats/batch", route: "/stats/batch"},
+		{method: http.MethodGet, path: "/unknown", route: ""},
+		{method: http.MethodGet, path: "/stats/", route: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if route := rt.routeOf(tt.method, tt.path); route != tt.route {
+				t.Fatalf("wrong route: expected=%q, got=%q", tt.route, route)
+			}
+		})
+	}
+}
+
+func Test_router_handle_Duplicate(t *testing.T) {
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/stats/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("registering a second handler of the same method and pattern did not panic")
+		}
+	}()
+	rt.handle(http.MethodGet, "/stats/{id}", func(w http.ResponseWriter, r *http.Request) {})
+}
@@ -1,61 +1,273 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/umutozd/stats-keeper/storage"
+	"google.golang.org/grpc"
 )
 
 // Server is the object that listens to and handles all incoming HTTP requests.
 type Server struct {
-	cfg *Config
-	mux *http.ServeMux
-	db  storage.StatsKeeperStorage
+	cfg        *Config
+	mux        *router
+	db         storage.StatsKeeperStorage
+	auth       *authenticator
+	registry   *prometheus.Registry
+	metrics    *httpMetrics
+	httpServer *http.Server
+	grpcServer *grpc.Server
+	// shuttingDown is set by BeginShutdown to make the server unready.
+	shuttingDown atomic.Bool
+
+	// workersMu guards starting the background workers against stopping them.
+	workersMu sync.Mutex
+	// workersCtx is the context of the background workers, which is canceled by stopWorkers.
+	workersCtx  context.Context
+	stopWorkers context.CancelFunc
+	workers     sync.WaitGroup
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{
+		status:    http.StatusOK,
+		actual:    w,
+		requestId: newRequestId(),
+	}
+	rw.Header().Set("X-Request-Id", rw.requestId)
+	// the format of every response depends on the Accept header, so caches must not serve one to another Accept
+	rw.Header().Set("Vary", "Accept")
+	start := time.Now()
+	// the request is observed and logged after a panic is recovered from, so that the 500 responses are counted too
+	defer func() {
+		elapsed := time.Since(start)
+		s.metrics.observe(s.mux.routeOf(r.Method, r.URL.Path), r.Method, rw.status, elapsed, rw.bytesWritten)
+		logrus.WithField("request_id", rw.requestId).Infof("%s %s %s, %d %s, %d bytes", r.Method, r.URL.Path, elapsed, rw.status, http.StatusText(rw.status), rw.bytesWritten)
+	}()
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.Errorf("ServeHTTP: recovered from panic: %v", r)
-			writeErrorResponse(w, http.StatusInternalServerError, "server unavailable", fmt.Errorf("%v", r))
+			writeErrorResponse(rw, http.StatusInternalServerError, "server unavailable", fmt.Errorf("%v", r))
 		}
 	}()
 
-	start := time.Now()
-	rw := &responseWriter{
-		status: http.StatusOK,
-		actual: w,
+	s.metrics.inFlight.Inc()
+	defer s.metrics.inFlight.Dec()
+	if contentType, ok := negotiateContentType(r.Header.Get("Accept")); !ok {
+		writeErrorResponse(rw, http.StatusNotAcceptable, "only application/json and application/x-protobuf responses are supported", nil)
+	} else {
+		rw.contentType = contentType
+		if req := s.authenticateRequest(rw, r); req != nil {
+			s.mux.ServeHTTP(rw, req)
+		}
 	}
-	s.mux.ServeHTTP(rw, r)
-	end := time.Now()
+}
+
+// requestIdLength is the number of random bytes of a request id.
+const requestIdLength = 12
 
-	logrus.Infof("%s %s %s, %d %s, %d bytes", r.Method, r.URL.Path, end.Sub(start), rw.status, http.StatusText(rw.status), rw.bytesWritten)
+// newRequestId returns a random id for a request. It falls back to the current time if random bytes cannot be
+// read, which is not worth failing the request for.
+func newRequestId() string {
+	id, err := newRandomToken(requestIdLength)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return id
 }
 
 // NewServer creates and initializes a new Server object using the given Config.
 func NewServer(cfg *Config) (*Server, error) {
-	db, err := storage.NewStatsKeeperStorage(cfg.DatabaseUrl)
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	metrics, err := newHttpMetrics(registry)
 	if err != nil {
 		return nil, err
 	}
-	return &Server{
-		cfg: cfg,
-		db:  db,
-	}, nil
+	db, err := newStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if db, err = storage.NewMetricsStorage(db, registry); err != nil {
+		return nil, err
+	}
+	auth, err := newAuthenticator(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+	if !auth.required() {
+		logrus.Warn("accounts are disabled and there are no keys of JWTs, requests are trusted with the user_id they specify")
+	}
+	s := &Server{
+		cfg:      cfg,
+		db:       db,
+		auth:     auth,
+		registry: registry,
+		metrics:  metrics,
+	}
+	s.workersCtx, s.stopWorkers = context.WithCancel(context.Background())
+	s.registerRoutes()
+	s.httpServer = &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.HttpPort),
+		Handler: s.rootHandler(),
+	}
+	s.grpcServer = s.newGrpcServer()
+	return s, nil
+}
+
+// newStorage creates the StatsKeeperStorage specified by cfg.StorageType.
+func newStorage(cfg *Config) (storage.StatsKeeperStorage, error) {
+	switch cfg.StorageType {
+	case StorageTypeDatabase:
+		return storage.NewStatsKeeperStorage(cfg.DatabaseUrl)
+	case StorageTypeMemory:
+		logrus.Warn("using in-memory storage, data will be lost when the server exits")
+		return storage.NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", cfg.StorageType)
+	}
 }
 
-// ListenHTTP initiates the HTTP listening and serving incoming requests. It returns only when process ends.
+// registerRoutes registers the handlers of the API to the router of the server.
+func (s *Server) registerRoutes() {
+	s.mux = newRouter()
+	s.mux.handle(http.MethodGet, "/api/stats/list", s.ListUserStats)
+	s.mux.handle(http.MethodGet, "/api/stats/get", s.GetStat)
+	s.mux.handle(http.MethodPut, "/api/stats/add", s.AddStat)
+	s.mux.handle(http.MethodDelete, "/api/stats/delete", s.DeleteStat)
+	s.mux.handle(http.MethodPost, "/api/stats/update", s.UpdateStat)
+	s.mux.handle(http.MethodPost, "/api/stats/revert", s.RevertStat)
+	s.mux.handle(http.MethodPost, "/api/stats/counter/increment", s.IncrementCounter)
+	s.mux.handle(http.MethodPost, "/api/stats/date/append", s.AppendTimestamps)
+	s.mux.handle(http.MethodPost, "/api/stats/date/remove", s.RemoveTimestamps)
+	s.mux.handle(http.MethodPost, "/api/stats/date/remove-range", s.RemoveTimestampRange)
+	s.mux.handle(http.MethodGet, "/api/stats/trash/list", s.ListTrash)
+	s.mux.handle(http.MethodPost, "/api/stats/trash/restore", s.RestoreStat)
+	s.mux.handle(http.MethodDelete, "/api/stats/trash/purge", s.PurgeStat)
+	s.mux.handle(http.MethodGet, "/api/stats/history", s.GetHistory)
+	if s.cfg.Accounts {
+		s.mux.handle(http.MethodPost, "/api/auth/register", s.Register)
+		s.mux.handle(http.MethodPost, "/api/auth/login", s.Login)
+		s.mux.handle(http.MethodPost, "/api/auth/logout", s.Logout)
+		s.mux.handle(http.MethodGet, "/api/auth/keys/list", s.ListApiKeys)
+		s.mux.handle(http.MethodPost, "/api/auth/keys/create", s.CreateApiKey)
+		s.mux.handle(http.MethodPost, "/api/auth/keys/revoke", s.RevokeApiKey)
+	}
+	s.registerV1Routes(s.mux)
+}
+
+// rootHandler returns the handler of all HTTP requests, which serves the API with the server itself.
+func (s *Server) rootHandler() http.Handler {
+	// the metrics and the health checks are served outside of ServeHTTP, so that scrapers and probes are
+	// neither authenticated nor subject to the content negotiation of the API
+	root := http.NewServeMux()
+	root.Handle(metricsPath, promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
+	root.HandleFunc(livenessPath, s.Healthz)
+	root.HandleFunc(readinessPath, s.Readyz)
+	root.Handle("/", s)
+	return root
+}
+
+// ListenHTTP initiates the HTTP listening and serving incoming requests, along with the background workers. It
+// returns when the server is shut down by Shutdown, or if it cannot listen.
 func (s *Server) ListenHTTP() error {
-	s.mux = http.NewServeMux()
-	s.mux.HandleFunc("/api/stats/list", s.ListUserStats)
-	s.mux.HandleFunc("/api/stats/get", s.GetStat)
-	s.mux.HandleFunc("/api/stats/add", s.AddStat)
-	s.mux.HandleFunc("/api/stats/delete", s.DeleteStat)
-	s.mux.HandleFunc("/api/stats/update", s.UpdateStat)
-
-	logrus.Infof("serving http on :%d", s.cfg.HttpPort)
-	return http.ListenAndServe(fmt.Sprintf(":%d", s.cfg.HttpPort), s)
+	s.startWorker(s.runTrashRetention)
+
+	logrus.Infof("serving http on %s", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// startWorker runs worker in the background until its context is canceled by Shutdown. It doesn't run worker
+// if the server is already shut down.
+func (s *Server) startWorker(worker func(ctx context.Context)) {
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+	if s.workersCtx.Err() != nil {
+		return
+	}
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		worker(s.workersCtx)
+	}()
+}
+
+// Shutdown gracefully shuts the server down. In order, it makes the server unready and keeps serving for
+// Config.ShutdownDelay, stops accepting new HTTP and gRPC requests and waits for the ongoing ones to finish,
+// stops the background workers and waits for them to return, and closes the storage. If ctx is done before the
+// ongoing requests finish, their connections are closed. Shutdown carries on after an error, and returns the
+// first one.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.BeginShutdown()
+	if s.cfg.ShutdownDelay > 0 {
+		// the load balancers need some time to notice that the server is unready
+		logrus.Infof("waiting %s before draining requests", s.cfg.ShutdownDelay)
+		select {
+		case <-time.After(s.cfg.ShutdownDelay):
+		case <-ctx.Done():
+		}
+	}
+	return s.drain(ctx)
+}
+
+// Stop shuts the server down like Shutdown, but without waiting for Config.ShutdownDelay. It's meant for when
+// the server cannot keep serving anyway, such as when one of its listeners failed.
+func (s *Server) Stop(ctx context.Context) error {
+	s.BeginShutdown()
+	return s.drain(ctx)
+}
+
+// drain stops accepting new requests, waits for the ongoing ones and the background workers to finish, and
+// closes the storage. It carries on after an error, and returns the first one.
+func (s *Server) drain(ctx context.Context) error {
+	var firstErr error
+	fail := func(err error, msg string) {
+		logrus.WithError(err).Error(msg)
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", msg, err)
+		}
+	}
+
+	grpcStopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		fail(err, "error draining http requests")
+		_ = s.httpServer.Close()
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		fail(ctx.Err(), "error draining grpc requests")
+		s.grpcServer.Stop()
+		<-grpcStopped
+	}
+
+	s.workersMu.Lock()
+	s.stopWorkers()
+	s.workersMu.Unlock()
+	s.workers.Wait()
+
+	if err := s.db.Close(ctx); err != nil {
+		fail(err, "error closing storage")
+	}
+	return firstErr
 }
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// freePort returns a port that is free to listen on.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("error finding a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// getStatus returns the status code of a GET request to url, or zero if the request fails.
+func getStatus(url string) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// newTestServer returns a server with in-memory storage, whose config is modified by configure if it's not nil,
+// along with the test server that serves its HTTP API until the test ends.
+func newTestServer(t *testing.T, configure func(cfg *Config)) (*Server, *httptest.Server) {
+	cfg := NewConfig()
+	cfg.StorageType = StorageTypeMemory
+	if configure != nil {
+		configure(cfg)
+	}
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	ts := httptest.NewServer(srv.rootHandler())
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+// doRequest sends a request to ts with the given method, path and Authorization header, and in as its JSON body
+// if it's not nil. The JSON body of a successful response is unmarshaled to out if it's not nil. It returns the
+// status code of the response.
+func doRequest(t *testing.T, ts *httptest.Server, method, path, authorization string, in, out proto.Message) int {
+	var body io.Reader
+	if in != nil {
+		data, err := protojson.Marshal(in)
+		if err != nil {
+			t.Fatalf("error encoding request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, body)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", contentTypeJson)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading response: %v", err)
+	}
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err = protojson.Unmarshal(data, out); err != nil {
+			t.Fatalf("error decoding response %s: %v", data, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func Test_Server_Accounts(t *testing.T) {
+	if NewConfig().Accounts {
+		t.Fatalf("accounts are enabled by default")
+	}
+	register := &statspb.RegisterRequest{Username: "alice", Password: "password"}
+
+	tests := []struct {
+		name     string
+		accounts bool
+		// listStatus and registerStatus are the expected status codes of an unauthenticated list request and a
+		// registration, respectively.
+		listStatus     int
+		registerStatus int
+	}{
+		{name: "disabled", accounts: false, listStatus: http.StatusOK, registerStatus: http.StatusNotFound},
+		{name: "enabled", accounts: true, listStatus: http.StatusUnauthorized, registerStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := newTestServer(t, func(cfg *Config) { cfg.Accounts = tt.accounts })
+			if status := doRequest(t, ts, http.MethodGet, "/api/stats/list?user_id=user-1", "", nil, nil); status != tt.listStatus {
+				t.Fatalf("wrong status of unauthenticated list: expected=%d, got=%d", tt.listStatus, status)
+			}
+			if status := doRequest(t, ts, http.MethodPost, "/api/auth/register", "", register, nil); status != tt.registerStatus {
+				t.Fatalf("wrong status of registration: expected=%d, got=%d", tt.registerStatus, status)
+			}
+		})
+	}
+}
+
+func Test_Server_Shutdown(t *testing.T) {
+	cfg := NewConfig()
+	cfg.HttpPort = freePort(t)
+	cfg.GrpcPort = 0
+	cfg.StorageType = StorageTypeMemory
+	cfg.ShutdownDelay = 500 * time.Millisecond
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- srv.ListenHTTP() }()
+
+	base := fmt.Sprintf("http://localhost:%d", cfg.HttpPort)
+	deadline := time.Now().Add(5 * time.Second)
+	for getStatus(base+readinessPath) != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	shutdownErr := make(chan error, 1)
+	start := time.Now()
+	go func() { shutdownErr <- srv.Shutdown(context.Background()) }()
+
+	// the server keeps serving during the shutdown delay, but reports that it's not ready
+	for getStatus(base+readinessPath) != http.StatusServiceUnavailable {
+		if time.Since(start) >= cfg.ShutdownDelay {
+			t.Fatalf("readiness did not report 503 during the shutdown delay")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := getStatus(base + livenessPath); status != http.StatusOK {
+		t.Fatalf("wrong liveness status during the shutdown delay: expected=%d, got=%d", http.StatusOK, status)
+	}
+
+	if err = <-shutdownErr; err != nil {
+		t.Fatalf("Shutdown returned unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < cfg.ShutdownDelay {
+		t.Fatalf("Shutdown returned before the shutdown delay: %s", elapsed)
+	}
+	if err = <-listenErr; err != nil {
+		t.Fatalf("ListenHTTP returned unexpected error: %v", err)
+	}
+	if status := getStatus(base + livenessPath); status != 0 {
+		t.Fatalf("server is still serving after Shutdown, status=%d", status)
+	}
+}
+
+func Test_Server_ServeHTTP_Panic(t *testing.T) {
+	srv, ts := newTestServer(t, nil)
+	srv.mux.handle(http.MethodGet, "/api/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler panic")
+	})
+
+	if status := doRequest(t, ts, http.MethodGet, "/api/panic", "", nil, nil); status != http.StatusInternalServerError {
+		t.Fatalf("wrong status: expected=%d, got=%d", http.StatusInternalServerError, status)
+	}
+	// the recovered request is observed with the status of the response written after the panic
+	labels := prometheus.Labels{"route": "/api/panic", "method": http.MethodGet, "status": "500"}
+	if count := testutil.ToFloat64(srv.metrics.requests.With(labels)); count != 1 {
+		t.Fatalf("wrong number of observed requests: expected=1, got=%v", count)
+	}
+	if inFlight := testutil.ToFloat64(srv.metrics.inFlight); inFlight != 0 {
+		t.Fatalf("wrong number of in-flight requests: expected=0, got=%v", inFlight)
+	}
+}
+
+func Test_Server_Stop(t *testing.T) {
+	cfg := NewConfig()
+	cfg.HttpPort = freePort(t)
+	cfg.GrpcPort = 0
+	cfg.StorageType = StorageTypeMemory
+	cfg.ShutdownDelay = time.Hour
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- srv.ListenHTTP() }()
+
+	base := fmt.Sprintf("http://localhost:%d", cfg.HttpPort)
+	deadline := time.Now().Add(5 * time.Second)
+	for getStatus(base+readinessPath) != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Stop doesn't wait for the shutdown delay
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = srv.Stop(ctx); err != nil {
+		t.Fatalf("Stop returned unexpected error: %v", err)
+	}
+	if err = <-listenErr; err != nil {
+		t.Fatalf("ListenHTTP returned unexpected error: %v", err)
+	}
+	if status := getStatus(base + livenessPath); status != 0 {
+		t.Fatalf("server is still serving after Stop, status=%d", status)
+	}
+}
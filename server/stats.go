@@ -1,65 +1,109 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/umutozd/stats-keeper/protos/statspb"
+	"github.com/umutozd/stats-keeper/storage"
 
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func (s *Server) ListUserStats(w http.ResponseWriter, r *http.Request) {
-	if !validateRequestMethod(w, r, http.MethodGet) {
+	q := r.URL.Query()
+	req, err := parseListRequest(q)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
-
-	q := r.URL.Query()
-	userId := q.Get("user_id")
-	if userId == "" {
-		writeErrorResponse(w, http.StatusBadRequest, "user_id cannot be empty", nil)
+	if req.UserId = userIdOf(w, r, req.UserId, ""); req.UserId == "" {
 		return
 	}
 
-	entities, err := s.db.ListUserStatistics(r.Context(), userId)
+	resp, err := s.db.ListUserStatistics(r.Context(), req)
 	if err != nil {
 		writeStorageError(w, err)
 		return
 	}
 
-	writeJsonResponse(w, http.StatusOK, &statspb.ListUserStatisticsResponse{Entities: entities})
+	writeResponse(w, http.StatusOK, resp)
 }
 
-func (s *Server) GetStat(w http.ResponseWriter, r *http.Request) {
-	if !validateRequestMethod(w, r, http.MethodGet) {
-		return
+// parseListRequest returns the ListUserStatisticsRequest specified by the query parameters of a list request.
+// Enum values are given by their names, case-insensitively, e.g. order_by=created_at.
+func parseListRequest(q url.Values) (*statspb.ListUserStatisticsRequest, error) {
+	req := &statspb.ListUserStatisticsRequest{
+		UserId:     q.Get("user_id"),
+		PageToken:  q.Get("page_token"),
+		NamePrefix: q.Get("name_prefix"),
+	}
+	if orderBy := q.Get("order_by"); orderBy != "" {
+		value, ok := statspb.ListUserStatisticsRequest_OrderBy_value[strings.ToUpper(orderBy)]
+		if !ok {
+			return nil, fmt.Errorf("invalid order_by")
+		}
+		req.OrderBy = statspb.ListUserStatisticsRequest_OrderBy(value)
 	}
+	if descending := q.Get("descending"); descending != "" {
+		var err error
+		if req.Descending, err = strconv.ParseBool(descending); err != nil {
+			return nil, fmt.Errorf("invalid descending")
+		}
+	}
+	if pageSize := q.Get("page_size"); pageSize != "" {
+		value, err := strconv.ParseInt(pageSize, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page_size")
+		}
+		req.PageSize = int32(value)
+	}
+	if componentType := q.Get("component_type"); componentType != "" {
+		value, ok := statspb.ComponentType_value[strings.ToUpper(componentType)]
+		if !ok {
+			return nil, fmt.Errorf("invalid component_type")
+		}
+		req.ComponentType = statspb.ComponentType(value)
+	}
+	return req, nil
+}
 
+func (s *Server) GetStat(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	entityId := q.Get("entity_id")
 	if entityId == "" {
-		writeErrorResponse(w, http.StatusBadRequest, "entity_id cannot be empty", nil)
+		writeInvalidFieldsResponse(w, "entity_id cannot be empty", "entity_id")
+		return
+	}
+
+	userId := userIdOf(w, r, q.Get("user_id"), entityId)
+	if userId == "" {
 		return
 	}
 
-	entity, err := s.db.GetStatistic(r.Context(), entityId)
+	entity, err := s.db.GetStatistic(r.Context(), userId, entityId)
 	if err != nil {
 		writeStorageError(w, err)
 		return
 	}
 
-	writeJsonResponse(w, http.StatusOK, entity)
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
 }
 
 func (s *Server) AddStat(w http.ResponseWriter, r *http.Request) {
-	if !validateRequestMethod(w, r, http.MethodPut) {
-		return
-	}
 	in := unmarshalRequestBody(w, r, &statspb.StatisticEntity{})
 	if in == nil {
 		return
 	}
-	if in.Name == "" || in.UserId == "" || in.Component == nil {
-		writeErrorResponse(w, http.StatusBadRequest, "name, user_id and component cannot be empty", nil)
+	if c, _ := storage.ComponentOf(in); in.Name == "" || c == nil {
+		writeInvalidFieldsResponse(w, "name and component cannot be empty", "name", "component")
+		return
+	}
+	if in.UserId = userIdOf(w, r, in.UserId, ""); in.UserId == "" {
 		return
 	}
 
@@ -68,46 +112,189 @@ func (s *Server) AddStat(w http.ResponseWriter, r *http.Request) {
 		writeStorageError(w, err)
 		return
 	}
-	writeJsonResponse(w, http.StatusOK, entity)
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
 }
 
 func (s *Server) DeleteStat(w http.ResponseWriter, r *http.Request) {
-	if !validateRequestMethod(w, r, http.MethodDelete) {
-		return
-	}
-
 	q := r.URL.Query()
 	entityId := q.Get("entity_id")
 	if entityId == "" {
-		writeErrorResponse(w, http.StatusBadRequest, "entity_id cannot be empty", nil)
+		writeInvalidFieldsResponse(w, "entity_id cannot be empty", "entity_id")
+		return
+	}
+
+	expectedVersion, err := parseIfMatch(r)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	userId := userIdOf(w, r, q.Get("user_id"), entityId)
+	if userId == "" {
 		return
 	}
 
-	if err := s.db.DeleteStatistic(r.Context(), entityId); err != nil {
+	if err = s.db.DeleteStatistic(r.Context(), userId, entityId, expectedVersion); err != nil {
 		writeStorageError(w, err)
 		return
 	} else {
-		writeJsonResponse(w, http.StatusOK, &emptypb.Empty{})
+		writeResponse(w, http.StatusOK, &emptypb.Empty{})
 	}
 }
 
 func (s *Server) UpdateStat(w http.ResponseWriter, r *http.Request) {
-	if !validateRequestMethod(w, r, http.MethodPost) {
-		return
-	}
 	in := unmarshalRequestBody(w, r, &statspb.UpdateStatisticRequest{})
 	if in == nil {
 		return
 	}
 	if in.Fields == nil || len(in.Fields.Paths) == 0 || in.Values == nil {
-		writeErrorResponse(w, http.StatusBadRequest, "fields.paths and values must be non-empty or non-null", nil)
+		writeInvalidFieldsResponse(w, "fields.paths and values must be non-empty or non-null", "fields.paths", "values")
+		return
+	}
+
+	expectedVersion, err := expectedVersionOf(r, in.ExpectedVersion)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	userId := userIdOf(w, r, r.URL.Query().Get("user_id"), in.Values.Id)
+	if userId == "" {
+		return
+	}
+
+	entity, err := s.db.UpdateStatistic(r.Context(), userId, in.Fields.Paths, in.Values, expectedVersion)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
+}
+
+func (s *Server) RevertStat(w http.ResponseWriter, r *http.Request) {
+	in := unmarshalRequestBody(w, r, &statspb.RevertStatisticRequest{})
+	if in == nil {
+		return
+	}
+	if in.EntityId == "" {
+		writeInvalidFieldsResponse(w, "entity_id cannot be empty", "entity_id")
+		return
+	}
+
+	var err error
+	if in.ExpectedVersion, err = expectedVersionOf(r, in.ExpectedVersion); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	userId := userIdOf(w, r, r.URL.Query().Get("user_id"), in.EntityId)
+	if userId == "" {
+		return
+	}
+
+	entity, err := s.db.RevertStatistic(r.Context(), userId, in)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
+}
+
+func (s *Server) IncrementCounter(w http.ResponseWriter, r *http.Request) {
+	in := unmarshalRequestBody(w, r, &statspb.IncrementCounterRequest{})
+	if in == nil {
+		return
+	}
+	if in.EntityId == "" {
+		writeInvalidFieldsResponse(w, "entity_id cannot be empty", "entity_id")
+		return
+	}
+
+	userId := userIdOf(w, r, r.URL.Query().Get("user_id"), in.EntityId)
+	if userId == "" {
+		return
+	}
+
+	entity, err := s.db.IncrementCounter(r.Context(), userId, in.EntityId, in.Delta)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
+}
+
+func (s *Server) AppendTimestamps(w http.ResponseWriter, r *http.Request) {
+	in := unmarshalRequestBody(w, r, &statspb.AppendTimestampsRequest{})
+	if in == nil {
+		return
+	}
+	if in.EntityId == "" || len(in.Timestamps) == 0 {
+		writeInvalidFieldsResponse(w, "entity_id and timestamps cannot be empty", "entity_id", "timestamps")
+		return
+	}
+
+	userId := userIdOf(w, r, r.URL.Query().Get("user_id"), in.EntityId)
+	if userId == "" {
+		return
+	}
+
+	entity, err := s.db.AppendTimestamps(r.Context(), userId, in.EntityId, in.Timestamps, in.Unique)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
+}
+
+func (s *Server) RemoveTimestamps(w http.ResponseWriter, r *http.Request) {
+	in := unmarshalRequestBody(w, r, &statspb.RemoveTimestampsRequest{})
+	if in == nil {
+		return
+	}
+	if in.EntityId == "" || len(in.Timestamps) == 0 {
+		writeInvalidFieldsResponse(w, "entity_id and timestamps cannot be empty", "entity_id", "timestamps")
+		return
+	}
+
+	userId := userIdOf(w, r, r.URL.Query().Get("user_id"), in.EntityId)
+	if userId == "" {
+		return
+	}
+
+	entity, err := s.db.RemoveTimestamps(r.Context(), userId, in.EntityId, in.Timestamps)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
+}
+
+func (s *Server) RemoveTimestampRange(w http.ResponseWriter, r *http.Request) {
+	in := unmarshalRequestBody(w, r, &statspb.RemoveTimestampRangeRequest{})
+	if in == nil {
+		return
+	}
+	if in.EntityId == "" || in.From == nil || in.To == nil {
+		writeInvalidFieldsResponse(w, "entity_id, from and to cannot be empty", "entity_id", "from", "to")
+		return
+	}
+
+	userId := userIdOf(w, r, r.URL.Query().Get("user_id"), in.EntityId)
+	if userId == "" {
 		return
 	}
 
-	entity, err := s.db.UpdateStatistic(r.Context(), in.Fields.Paths, in.Values)
+	entity, err := s.db.RemoveTimestampRange(r.Context(), userId, in.EntityId, in.From, in.To)
 	if err != nil {
 		writeStorageError(w, err)
 		return
 	}
-	writeJsonResponse(w, http.StatusOK, entity)
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
 }
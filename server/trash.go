@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// trashRetentionInterval is how often the deleted statistics that are older than the retention period
+// are purged.
+const trashRetentionInterval = time.Hour
+
+func (s *Server) ListTrash(w http.ResponseWriter, r *http.Request) {
+	req, err := parseListRequest(r.URL.Query())
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if req.UserId = userIdOf(w, r, req.UserId, ""); req.UserId == "" {
+		return
+	}
+	req.Deleted = true
+
+	resp, err := s.db.ListUserStatistics(r.Context(), req)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, resp)
+}
+
+func (s *Server) RestoreStat(w http.ResponseWriter, r *http.Request) {
+	entityId := r.URL.Query().Get("entity_id")
+	if entityId == "" {
+		writeInvalidFieldsResponse(w, "entity_id cannot be empty", "entity_id")
+		return
+	}
+
+	userId := userIdOf(w, r, r.URL.Query().Get("user_id"), entityId)
+	if userId == "" {
+		return
+	}
+
+	entity, err := s.db.RestoreStatistic(r.Context(), userId, entityId)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
+}
+
+func (s *Server) PurgeStat(w http.ResponseWriter, r *http.Request) {
+	entityId := r.URL.Query().Get("entity_id")
+	if entityId == "" {
+		writeInvalidFieldsResponse(w, "entity_id cannot be empty", "entity_id")
+		return
+	}
+
+	userId := userIdOf(w, r, r.URL.Query().Get("user_id"), entityId)
+	if userId == "" {
+		return
+	}
+
+	if err := s.db.PurgeStatistic(r.Context(), userId, entityId); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, &emptypb.Empty{})
+}
+
+// runTrashRetention purges the statistics that are deleted more than cfg.TrashRetentionDays days ago, once
+// every trashRetentionInterval, until ctx is done. It returns immediately if the retention is disabled.
+func (s *Server) runTrashRetention(ctx context.Context) {
+	if s.cfg.TrashRetentionDays <= 0 {
+		return
+	}
+	retention := time.Duration(s.cfg.TrashRetentionDays) * 24 * time.Hour
+	logrus.Infof("purging deleted statistics after %d days", s.cfg.TrashRetentionDays)
+
+	ticker := time.NewTicker(trashRetentionInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := s.db.PurgeDeletedStatistics(ctx, time.Now().Add(-retention))
+		if err != nil {
+			logrus.WithError(err).Error("error purging deleted statistics")
+		} else if purged > 0 {
+			logrus.Infof("purged %d deleted statistics", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"github.com/umutozd/stats-keeper/storage"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// minPasswordLength is the minimum number of bytes of a password.
+	minPasswordLength = 8
+	// maxPasswordLength is the maximum number of bytes of a password, which is the most that bcrypt uses.
+	maxPasswordLength = 72
+	// sessionTokenLength is the number of random bytes of a session token.
+	sessionTokenLength = 32
+)
+
+// dummyPasswordHash is a bcrypt hash that the password of a login is compared to when the user doesn't exist,
+// so that the response time doesn't reveal which usernames exist.
+var dummyPasswordHash = []byte("$2a$10$mKYL9u1KX4m.CK9aqMhvZ.Vg5c7g92G/nzgOMs1svdV.yqjzvSrZm")
+
+func (s *Server) Register(w http.ResponseWriter, r *http.Request) {
+	in := unmarshalRequestBody(w, r, &statspb.RegisterRequest{})
+	if in == nil {
+		return
+	}
+	if len(in.Password) < minPasswordLength || len(in.Password) > maxPasswordLength {
+		msg := fmt.Sprintf("password must be %d to %d bytes long", minPasswordLength, maxPasswordLength)
+		writeInvalidFieldsResponse(w, msg, "password")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
+	if err != nil {
+		writeErrorResponse(w, http.StatusInternalServerError, "error hashing password", err)
+		return
+	}
+	user, err := s.db.CreateUser(r.Context(), in.Username, hash)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, user)
+}
+
+func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
+	in := unmarshalRequestBody(w, r, &statspb.LoginRequest{})
+	if in == nil {
+		return
+	}
+
+	credentials, err := s.db.GetUserCredentials(r.Context(), in.Username)
+	if err != nil {
+		if code, _, _ := storage.ToHttpError(err); code != http.StatusNotFound {
+			writeStorageError(w, err)
+			return
+		}
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(in.Password))
+		writeErrorResponse(w, http.StatusUnauthorized, "invalid username or password", nil)
+		return
+	}
+	userId := credentials.User.Id
+	// the password is compared even if the user is locked, so that neither the response nor its time reveals
+	// that the user exists
+	passwordErr := bcrypt.CompareHashAndPassword(credentials.PasswordHash, []byte(in.Password))
+	if credentials.LockedUntil.After(time.Now()) {
+		logrus.Warnf("Login: user %s is locked because of failed logins until %s", userId, credentials.LockedUntil.UTC().Format(time.RFC3339))
+		writeErrorResponse(w, http.StatusUnauthorized, "invalid username or password", nil)
+		return
+	}
+	if passwordErr != nil {
+		if err = s.db.RecordLoginFailure(r.Context(), userId, s.cfg.MaxFailedLogins, s.cfg.LoginLockout); err != nil {
+			logrus.WithError(err).Errorf("Login: error recording failed login of user %s", userId)
+		}
+		writeErrorResponse(w, http.StatusUnauthorized, "invalid username or password", nil)
+		return
+	}
+	if err = s.db.RecordLoginSuccess(r.Context(), userId); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	token, err := newRandomToken(sessionTokenLength)
+	if err != nil {
+		writeErrorResponse(w, http.StatusInternalServerError, "error creating session token", err)
+		return
+	}
+	expiresAt := time.Now().Add(s.cfg.SessionTtl)
+	if err = s.db.CreateSession(r.Context(), userId, hashToken(token), expiresAt); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, &statspb.LoginResponse{
+		AccessToken: token,
+		ExpiresAt:   timestamppb.New(expiresAt),
+		User:        credentials.User,
+	})
+}
+
+func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	scheme, token, err := parseAuthorization(r.Header.Get("Authorization"))
+	switch {
+	case err != nil:
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	case scheme != schemeBearer:
+		writeErrorResponse(w, http.StatusBadRequest, "session token is required", nil)
+		return
+	case s.auth.isJwt(token):
+		writeErrorResponse(w, http.StatusBadRequest, "only session tokens can be logged out", nil)
+		return
+	}
+
+	if err = s.db.DeleteSession(r.Context(), hashToken(token)); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, &emptypb.Empty{})
+}
+
+// newRandomToken returns a base64url-encoded token of n random bytes.
+func newRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash of a session token or an API key that it's stored with, so that the stored
+// credentials cannot be used if they leak.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
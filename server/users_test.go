@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+)
+
+// newTestAccountsServer returns a test server with the accounts enabled, whose config is modified by configure
+// if it's not nil.
+func newTestAccountsServer(t *testing.T, configure func(cfg *Config)) *httptest.Server {
+	_, ts := newTestServer(t, func(cfg *Config) {
+		cfg.Accounts = true
+		if configure != nil {
+			configure(cfg)
+		}
+	})
+	return ts
+}
+
+// registerTestUser registers a user with the given username and password, and returns it.
+func registerTestUser(t *testing.T, ts *httptest.Server, username, password string) *statspb.User {
+	user := &statspb.User{}
+	req := &statspb.RegisterRequest{Username: username, Password: password}
+	if status := doRequest(t, ts, http.MethodPost, "/api/auth/register", "", req, user); status != http.StatusOK {
+		t.Fatalf("wrong status of registration: expected=%d, got=%d", http.StatusOK, status)
+	}
+	return user
+}
+
+// login logs in with the given username and password, and returns the status code along with the response.
+func login(t *testing.T, ts *httptest.Server, username, password string) (int, *statspb.LoginResponse) {
+	resp := &statspb.LoginResponse{}
+	req := &statspb.LoginRequest{Username: username, Password: password}
+	return doRequest(t, ts, http.MethodPost, "/api/auth/login", "", req, resp), resp
+}
+
+// listStatus returns the status code of listing the statistics of the user with the given Authorization header.
+func listStatus(t *testing.T, ts *httptest.Server, authorization string) int {
+	return doRequest(t, ts, http.MethodGet, "/api/stats/list", authorization, nil, nil)
+}
+
+func Test_Server_Register(t *testing.T) {
+	ts := newTestAccountsServer(t, nil)
+	user := registerTestUser(t, ts, "alice", "password")
+	if user.Id == "" || user.Username != "alice" {
+		t.Fatalf("wrong user: %v", user)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		status   int
+	}{
+		{name: "taken username", username: "alice", password: "password", status: http.StatusConflict},
+		{name: "short password", username: "bob", password: "short", status: http.StatusBadRequest},
+		{name: "long password", username: "bob", password: string(make([]byte, maxPasswordLength+1)), status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &statspb.RegisterRequest{Username: tt.username, Password: tt.password}
+			if status := doRequest(t, ts, http.MethodPost, "/api/auth/register", "", req, nil); status != tt.status {
+				t.Fatalf("wrong status: expected=%d, got=%d", tt.status, status)
+			}
+		})
+	}
+}
+
+func Test_Server_Login(t *testing.T) {
+	ts := newTestAccountsServer(t, nil)
+	user := registerTestUser(t, ts, "alice", "password")
+
+	status, resp := login(t, ts, "alice", "password")
+	if status != http.StatusOK {
+		t.Fatalf("wrong status of login: expected=%d, got=%d", http.StatusOK, status)
+	}
+	if resp.AccessToken == "" || resp.User.GetId() != user.Id || !resp.ExpiresAt.AsTime().After(time.Now()) {
+		t.Fatalf("wrong login response: %v", resp)
+	}
+	if status = listStatus(t, ts, "Bearer "+resp.AccessToken); status != http.StatusOK {
+		t.Fatalf("wrong status of a request with the session token: expected=%d, got=%d", http.StatusOK, status)
+	}
+
+	// a wrong password and an unknown user are indistinguishable
+	if status, _ = login(t, ts, "alice", "wrong-password"); status != http.StatusUnauthorized {
+		t.Fatalf("wrong status of login with a wrong password: expected=%d, got=%d", http.StatusUnauthorized, status)
+	}
+	if status, _ = login(t, ts, "bob", "password"); status != http.StatusUnauthorized {
+		t.Fatalf("wrong status of login of an unknown user: expected=%d, got=%d", http.StatusUnauthorized, status)
+	}
+}
+
+func Test_Server_Login_Lockout(t *testing.T) {
+	ts := newTestAccountsServer(t, func(cfg *Config) {
+		cfg.MaxFailedLogins = 2
+		cfg.LoginLockout = time.Hour
+	})
+	registerTestUser(t, ts, "alice", "password")
+	registerTestUser(t, ts, "bob", "password")
+
+	for i := 0; i < 2; i++ {
+		if status, _ := login(t, ts, "alice", "wrong-password"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong status of failed login %d: expected=%d, got=%d", i+1, http.StatusUnauthorized, status)
+		}
+	}
+	// the locked user cannot log in even with the right password, and gets the same response as a wrong one
+	if status, _ := login(t, ts, "alice", "password"); status != http.StatusUnauthorized {
+		t.Fatalf("wrong status of login of a locked user: expected=%d, got=%d", http.StatusUnauthorized, status)
+	}
+	if status, _ := login(t, ts, "bob", "password"); status != http.StatusOK {
+		t.Fatalf("wrong status of login of another user: expected=%d, got=%d", http.StatusOK, status)
+	}
+}
+
+func Test_Server_Logout(t *testing.T) {
+	ts := newTestAccountsServer(t, nil)
+	registerTestUser(t, ts, "alice", "password")
+	_, first := login(t, ts, "alice", "password")
+	_, second := login(t, ts, "alice", "password")
+
+	if status := doRequest(t, ts, http.MethodPost, "/api/auth/logout", "Bearer "+first.AccessToken, nil, nil); status != http.StatusOK {
+		t.Fatalf("wrong status of logout: expected=%d, got=%d", http.StatusOK, status)
+	}
+	if status := listStatus(t, ts, "Bearer "+first.AccessToken); status != http.StatusUnauthorized {
+		t.Fatalf("wrong status of a request with a logged out session: expected=%d, got=%d", http.StatusUnauthorized, status)
+	}
+	// the other sessions of the user are not logged out
+	if status := listStatus(t, ts, "Bearer "+second.AccessToken); status != http.StatusOK {
+		t.Fatalf("wrong status of a request with another session: expected=%d, got=%d", http.StatusOK, status)
+	}
+	if status := doRequest(t, ts, http.MethodPost, "/api/auth/logout", "", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("wrong status of logout without a session: expected=%d, got=%d", http.StatusUnauthorized, status)
+	}
+}
+
+func Test_Server_SessionExpiry(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+	ts := newTestAccountsServer(t, func(cfg *Config) { cfg.SessionTtl = ttl })
+	registerTestUser(t, ts, "alice", "password")
+	status, resp := login(t, ts, "alice", "password")
+	if status != http.StatusOK {
+		t.Fatalf("wrong status of login: expected=%d, got=%d", http.StatusOK, status)
+	}
+
+	time.Sleep(ttl)
+	if status = listStatus(t, ts, "Bearer "+resp.AccessToken); status != http.StatusUnauthorized {
+		t.Fatalf("wrong status of a request with an expired session: expected=%d, got=%d", http.StatusUnauthorized, status)
+	}
+}
@@ -1,29 +1,55 @@
 package server
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
+	"github.com/umutozd/stats-keeper/protos/statspb"
 	"github.com/umutozd/stats-keeper/storage"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	// contentTypeJson is the content type of protojson-encoded bodies, which is the default.
+	contentTypeJson = "application/json"
+	// contentTypeProtobuf is the content type of binary protobuf-encoded bodies.
+	contentTypeProtobuf = "application/x-protobuf"
 )
 
 // unmarshalRequestBody reads the request body, unmarshals it to given object and
-// returns the unmarshaled object. If it fails, unmarshalRequestBody also handles
-// sending the appropriate response body and status code.
+// returns the unmarshaled object. The body is decoded according to the Content-Type
+// header of the request, which is JSON if it's not set. If it fails, unmarshalRequestBody
+// also handles sending the appropriate response body and status code.
 func unmarshalRequestBody[T proto.Message](w http.ResponseWriter, r *http.Request, unmarshalTo T) T {
 	var nilResult T // result to return when nil is intended to be returned
+	contentType := contentTypeJson
+	if header := r.Header.Get("Content-Type"); header != "" {
+		mediaType, _, err := mime.ParseMediaType(header)
+		if err != nil || (mediaType != contentTypeJson && mediaType != contentTypeProtobuf) {
+			writeErrorResponse(w, http.StatusUnsupportedMediaType, "unsupported content type", nil)
+			return nilResult
+		}
+		contentType = mediaType
+	}
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		writeErrorResponse(w, http.StatusBadRequest, "invalid http request body", err)
 		return nilResult
 	}
+	if contentType == contentTypeProtobuf {
+		if err := proto.Unmarshal(body, unmarshalTo); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, "invalid protobuf request body", err)
+			return nilResult
+		}
+		return unmarshalTo
+	}
 	if err := protojson.Unmarshal(body, unmarshalTo); err != nil {
 		writeErrorResponse(w, http.StatusBadRequest, "invalid json request body", err)
 		return nilResult
@@ -31,61 +57,181 @@ func unmarshalRequestBody[T proto.Message](w http.ResponseWriter, r *http.Reques
 	return unmarshalTo
 }
 
-type apiError struct {
-	Message string `json:"message"`
-	Err     string `json:"error,omitempty"`
+// writeStorageError writes the ApiError of the storage error err to w, and logs its internal details.
+func writeStorageError(w http.ResponseWriter, err error) {
+	statusCode, ae, internalErr := storage.ToApiError(err)
+	writeApiError(w, statusCode, ae, internalErr)
 }
 
-func (e *apiError) Error() string {
-	return fmt.Sprintf("apiError: %s; %s", e.Message, e.Err)
+// writeErrorResponse writes an ApiError with message to w with statusCode. The code of the ApiError is the one
+// of statusCode. err is the internal cause of the error, which is logged but not sent to the client.
+func writeErrorResponse(w http.ResponseWriter, statusCode int, message string, err error) {
+	writeApiError(w, statusCode, &statspb.ApiError{Code: apiErrorCode(statusCode), Message: message}, err)
 }
 
-func writeStorageError(w http.ResponseWriter, err error) {
-	statusCode, message, wrappedErr := storage.ToHttpError(err)
-	writeErrorResponse(w, statusCode, message, wrappedErr)
+// writeInvalidFieldsResponse writes an INVALID_ARGUMENT ApiError with message to w, which has a field violation
+// with message as its description for each of fields.
+func writeInvalidFieldsResponse(w http.ResponseWriter, message string, fields ...string) {
+	ae := &statspb.ApiError{Code: statspb.ApiError_INVALID_ARGUMENT, Message: message}
+	for _, field := range fields {
+		ae.FieldViolations = append(ae.FieldViolations, &statspb.ApiError_FieldViolation{Field: field, Description: message})
+	}
+	writeApiError(w, http.StatusBadRequest, ae, nil)
 }
 
-// writeErrorResponse writes an apiError to w with statusCode.
-func writeErrorResponse(w http.ResponseWriter, statusCode int, message string, err error) {
-	ae := &apiError{Message: message, Err: ""}
-	if err != nil {
-		ae.Err = err.Error()
+// writeApiError writes ae to w with statusCode and the request id of w. If internalErr is not nil, it's logged
+// with the request id so that the response can be traced to it.
+func writeApiError(w http.ResponseWriter, statusCode int, ae *statspb.ApiError, internalErr error) {
+	if rw, ok := w.(*responseWriter); ok {
+		ae.RequestId = rw.requestId
+	}
+	if internalErr != nil {
+		entry := logrus.WithError(internalErr).WithField("request_id", ae.RequestId)
+		if statusCode >= http.StatusInternalServerError {
+			entry.Errorf("%d %s", statusCode, ae.Message)
+		} else {
+			entry.Warnf("%d %s", statusCode, ae.Message)
+		}
+	}
+	writeResponse(w, statusCode, ae)
+}
+
+// apiErrorCode returns the ApiError code of the errors with the given http status.
+func apiErrorCode(statusCode int) statspb.ApiError_Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return statspb.ApiError_INVALID_ARGUMENT
+	case http.StatusUnauthorized:
+		return statspb.ApiError_UNAUTHENTICATED
+	case http.StatusForbidden:
+		return statspb.ApiError_PERMISSION_DENIED
+	case http.StatusNotFound:
+		return statspb.ApiError_NOT_FOUND
+	case http.StatusMethodNotAllowed:
+		return statspb.ApiError_METHOD_NOT_ALLOWED
+	case http.StatusNotAcceptable:
+		return statspb.ApiError_NOT_ACCEPTABLE
+	case http.StatusConflict:
+		return statspb.ApiError_CONFLICT
+	case http.StatusUnsupportedMediaType:
+		return statspb.ApiError_UNSUPPORTED_MEDIA_TYPE
+	case http.StatusInternalServerError:
+		return statspb.ApiError_INTERNAL
+	default:
+		return statspb.ApiError_UNKNOWN
 	}
-	writeJsonResponse(w, statusCode, ae)
 }
 
-// writeJsonResponse json-marshals the given data and writes it along with statusCode
-// to w. The type of marshaler used is based upon the data's type with default one being
-// encoding/json.
-func writeJsonResponse(w http.ResponseWriter, statusCode int, data any) {
+// writeResponse marshals the given message and writes it along with statusCode to w. The message
+// is marshaled to the content type negotiated for the request by ServeHTTP, which is JSON if w is
+// not the responseWriter of ServeHTTP.
+func writeResponse(w http.ResponseWriter, statusCode int, msg proto.Message) {
+	contentType := contentTypeJson
+	if rw, ok := w.(*responseWriter); ok && rw.contentType != "" {
+		contentType = rw.contentType
+	}
+
 	var resp []byte
 	var err error
-	if protoMsg, ok := data.(protoreflect.ProtoMessage); ok {
-		// marshal using protojson package
-		resp, err = protojson.Marshal(protoMsg)
-	} else if b, ok := data.([]byte); ok {
-		// data is already marshaled
-		resp = b
+	if contentType == contentTypeProtobuf {
+		resp, err = proto.Marshal(msg)
 	} else {
-		// marshal using builtin json package
-		resp, err = json.Marshal(data)
+		resp, err = protojson.Marshal(msg)
 	}
 
 	if err != nil {
 		// fall back to pre-defined error message
-		logrus.WithError(err).Error("writeJsonResponse: error marshaling response")
-		resp = []byte(fmt.Sprintf(`{"message":"unable to encode http response","error":"%v"}`, err))
+		logrus.WithError(err).Error("writeResponse: error marshaling response")
+		resp = []byte(`{"code":"INTERNAL","message":"unable to encode http response"}`)
+		contentType = contentTypeJson
 		statusCode = http.StatusInternalServerError
 	}
 
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(statusCode)
 	if _, err = w.Write(resp); err != nil {
-		logrus.WithError(err).Error("writeJsonResponse: error writing http response")
+		logrus.WithError(err).Error("writeResponse: error writing http response")
 	}
 }
 
+// negotiateContentType returns the content type of the response to a request with the given Accept header,
+// which is the supported type with the highest quality in it. It returns false if the header doesn't accept
+// any of the supported types. Wildcards and a missing header are served JSON.
+func negotiateContentType(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return contentTypeJson, true
+	}
+	best, bestQuality := "", 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		var contentType string
+		switch mediaType {
+		case contentTypeJson, "application/*", "*/*":
+			contentType = contentTypeJson
+		case contentTypeProtobuf:
+			contentType = contentTypeProtobuf
+		default:
+			continue
+		}
+		if quality > bestQuality {
+			best, bestQuality = contentType, quality
+		}
+	}
+	return best, best != ""
+}
+
+// setETag sets the ETag header of w to the version of entity, so that clients can make modifications
+// conditional on it with the If-Match header.
+func setETag(w http.ResponseWriter, entity *statspb.StatisticEntity) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(entity.Version, 10)))
+}
+
+// parseIfMatch returns the entity version in the If-Match header of r, which is an ETag set by setETag. It
+// returns zero if r doesn't have an If-Match header or it's "*", which don't make a modification conditional.
+func parseIfMatch(r *http.Request) (int64, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, nil
+	}
+	unquoted, err := strconv.Unquote(ifMatch)
+	if err != nil || !strings.HasPrefix(ifMatch, `"`) {
+		return 0, fmt.Errorf("If-Match header must be a single, strong entity tag")
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("If-Match header is not an entity tag of this server")
+	}
+	return version, nil
+}
+
+// expectedVersionOf returns the version that a modification requested by r is conditional on, which is given
+// either in the If-Match header of r or as expectedVersion in its body. It returns an error if both are given
+// and they're different.
+func expectedVersionOf(r *http.Request, expectedVersion int64) (int64, error) {
+	ifMatch, err := parseIfMatch(r)
+	if err != nil {
+		return 0, err
+	}
+	if expectedVersion == 0 {
+		return ifMatch, nil
+	}
+	if ifMatch != 0 && ifMatch != expectedVersion {
+		return 0, fmt.Errorf("expected_version and If-Match header don't match")
+	}
+	return expectedVersion, nil
+}
+
 // validateRequestMethod checks if given request's method is in the given allowed methods. If so, it returns true.
-// Otherwise, it sets 405 status and Allow header with given allowed methods and returns false.
+// Otherwise, it writes a 405 response with the Allow header of given allowed methods and returns false.
 func validateRequestMethod(w http.ResponseWriter, r *http.Request, allowedMethods ...string) (isValid bool) {
 	for _, m := range allowedMethods {
 		if r.Method == m {
@@ -93,17 +239,27 @@ func validateRequestMethod(w http.ResponseWriter, r *http.Request, allowedMethod
 		}
 	}
 
-	w.WriteHeader(http.StatusMethodNotAllowed)
-	w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
+	writeMethodNotAllowed(w, allowedMethods)
 	return false
 }
 
+// writeMethodNotAllowed writes a 405 response with the Allow header of allowedMethods to w. The header must be
+// set before the status is written, headers set afterwards are not sent.
+func writeMethodNotAllowed(w http.ResponseWriter, allowedMethods []string) {
+	w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
+	writeErrorResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+}
+
 // responseWriter is an adapter for the actual http.ResponseWriter. It's intended to
 // intercept http status codes and written byte count for logging.
 type responseWriter struct {
 	actual       http.ResponseWriter
 	bytesWritten int
 	status       int
+	// contentType is the content type negotiated for the response by ServeHTTP.
+	contentType string
+	// requestId identifies the request in the logs and in its ApiError responses.
+	requestId string
 }
 
 func (rw *responseWriter) Header() http.Header {
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_negotiateContentType(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+		ok          bool
+	}{
+		{accept: "", contentType: contentTypeJson, ok: true},
+		{accept: "*/*", contentType: contentTypeJson, ok: true},
+		{accept: "application/*", contentType: contentTypeJson, ok: true},
+		{accept: "application/json", contentType: contentTypeJson, ok: true},
+		{accept: "application/x-protobuf", contentType: contentTypeProtobuf, ok: true},
+		{accept: "application/json;q=0.5, application/x-protobuf", contentType: contentTypeProtobuf, ok: true},
+		{accept: "application/x-protobuf;q=0.1, */*;q=0.9", contentType: contentTypeJson, ok: true},
+		{accept: "text/html, application/x-protobuf;q=0.2", contentType: contentTypeProtobuf, ok: true},
+		{accept: "text/html", ok: false},
+		{accept: "application/json;q=0", ok: false},
+		{accept: "application/xml, text/*", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			contentType, ok := negotiateContentType(tt.accept)
+			if contentType != tt.contentType || ok != tt.ok {
+				t.Fatalf("wrong result: expected=(%q, %t), got=(%q, %t)", tt.contentType, tt.ok, contentType, ok)
+			}
+		})
+	}
+}
+
+// sendRaw sends a request to url with the given method, body and headers, and returns the response along
+// with its body.
+func sendRaw(t *testing.T, url, method string, body []byte, headers map[string]string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading response: %v", err)
+	}
+	return resp, data
+}
+
+func Test_Server_ContentTypes(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	addUrl := ts.URL + "/api/stats/add"
+	entity := newCounterStat("counter")
+	entity.UserId = "user-1"
+	jsonBody, err := protojson.Marshal(entity)
+	if err != nil {
+		t.Fatalf("error encoding request: %v", err)
+	}
+	protoBody, err := proto.Marshal(entity)
+	if err != nil {
+		t.Fatalf("error encoding request: %v", err)
+	}
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		resp, _ := sendRaw(t, addUrl, http.MethodPut, jsonBody, map[string]string{"Content-Type": "text/plain"})
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Fatalf("wrong status: expected=%d, got=%d", http.StatusUnsupportedMediaType, resp.StatusCode)
+		}
+	})
+	t.Run("unsupported accept", func(t *testing.T) {
+		resp, _ := sendRaw(t, addUrl, http.MethodPut, jsonBody, map[string]string{"Content-Type": contentTypeJson, "Accept": "text/html"})
+		if resp.StatusCode != http.StatusNotAcceptable {
+			t.Fatalf("wrong status: expected=%d, got=%d", http.StatusNotAcceptable, resp.StatusCode)
+		}
+		if vary := resp.Header.Get("Vary"); vary != "Accept" {
+			t.Fatalf("wrong Vary header: expected=%q, got=%q", "Accept", vary)
+		}
+	})
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		accept      string
+		// responseType is the expected content type of the response.
+		responseType string
+	}{
+		{name: "json", body: jsonBody, contentType: contentTypeJson, accept: contentTypeJson, responseType: contentTypeJson},
+		{name: "json without headers", body: jsonBody, responseType: contentTypeJson},
+		{name: "protobuf", body: protoBody, contentType: contentTypeProtobuf, accept: contentTypeProtobuf, responseType: contentTypeProtobuf},
+		{name: "protobuf request", body: protoBody, contentType: contentTypeProtobuf, accept: "*/*", responseType: contentTypeJson},
+		{name: "protobuf response", body: jsonBody, contentType: contentTypeJson, accept: contentTypeProtobuf, responseType: contentTypeProtobuf},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.contentType != "" {
+				headers["Content-Type"] = tt.contentType
+			}
+			if tt.accept != "" {
+				headers["Accept"] = tt.accept
+			}
+			resp, data := sendRaw(t, addUrl, http.MethodPut, tt.body, headers)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("wrong status: expected=%d, got=%d, body=%s", http.StatusOK, resp.StatusCode, data)
+			}
+			if contentType := resp.Header.Get("Content-Type"); contentType != tt.responseType {
+				t.Fatalf("wrong content type: expected=%q, got=%q", tt.responseType, contentType)
+			}
+			if vary := resp.Header.Get("Vary"); vary != "Accept" {
+				t.Fatalf("wrong Vary header: expected=%q, got=%q", "Accept", vary)
+			}
+
+			created := &statspb.StatisticEntity{}
+			if tt.responseType == contentTypeProtobuf {
+				err = proto.Unmarshal(data, created)
+			} else {
+				err = protojson.Unmarshal(data, created)
+			}
+			if err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			if created.Id == "" || created.Name != entity.Name || created.UserId != entity.UserId || created.GetCounter() == nil {
+				t.Fatalf("wrong created statistic: %v", created)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"github.com/umutozd/stats-keeper/storage"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// apiV1Prefix is the prefix of the paths of the resource-oriented routes, which are versioned so that
+// incompatible changes can be made under a new prefix.
+const apiV1Prefix = "/api/v1"
+
+// registerV1Routes registers the resource-oriented routes of the statistics to rt.
+func (s *Server) registerV1Routes(rt *router) {
+	rt.handle(http.MethodGet, apiV1Prefix+"/users/{user}/stats", s.ListUserStatsV1)
+	rt.handle(http.MethodPost, apiV1Prefix+"/users/{user}/stats", s.CreateStatV1)
+	rt.handle(http.MethodGet, apiV1Prefix+"/stats/{id}", s.GetStatV1)
+	rt.handle(http.MethodPatch, apiV1Prefix+"/stats/{id}", s.PatchStatV1)
+	rt.handle(http.MethodDelete, apiV1Prefix+"/stats/{id}", s.DeleteStatV1)
+}
+
+// ListUserStatsV1 lists the statistics of the user in the path, with the query parameters of ListUserStats.
+func (s *Server) ListUserStatsV1(w http.ResponseWriter, r *http.Request) {
+	req, err := parseListRequest(r.URL.Query())
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if req.UserId = userIdOf(w, r, pathParam(r, "user"), ""); req.UserId == "" {
+		return
+	}
+
+	resp, err := s.db.ListUserStatistics(r.Context(), req)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, resp)
+}
+
+// CreateStatV1 creates the statistic in the body for the user in the path, and responds with 201 and the
+// location of the new statistic.
+func (s *Server) CreateStatV1(w http.ResponseWriter, r *http.Request) {
+	in := unmarshalRequestBody(w, r, &statspb.StatisticEntity{})
+	if in == nil {
+		return
+	}
+	if c, _ := storage.ComponentOf(in); in.Name == "" || c == nil {
+		writeInvalidFieldsResponse(w, "name and component cannot be empty", "name", "component")
+		return
+	}
+	userId := pathParam(r, "user")
+	if in.UserId != "" && in.UserId != userId {
+		writeInvalidFieldsResponse(w, "user_id must be the user in the path", "user_id")
+		return
+	}
+	if in.UserId = userIdOf(w, r, userId, ""); in.UserId == "" {
+		return
+	}
+
+	entity, err := s.db.CreateStatistic(r.Context(), in)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	setETag(w, entity)
+	w.Header().Set("Location", apiV1Prefix+"/stats/"+entity.Id)
+	writeResponse(w, http.StatusCreated, entity)
+}
+
+// GetStatV1 returns the statistic in the path.
+func (s *Server) GetStatV1(w http.ResponseWriter, r *http.Request) {
+	entityId := pathParam(r, "id")
+	userId := userIdOf(w, r, r.URL.Query().Get("user_id"), entityId)
+	if userId == "" {
+		return
+	}
+
+	entity, err := s.db.GetStatistic(r.Context(), userId, entityId)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
+}
+
+// PatchStatV1 updates the fields in the update_mask query parameter, which is a comma-separated list of paths,
+// of the statistic in the path to their values in the body. It's conditional if the request has an If-Match
+// header.
+func (s *Server) PatchStatV1(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	paths := []string{}
+	for _, path := range strings.Split(q.Get("update_mask"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		writeInvalidFieldsResponse(w, "update_mask cannot be empty", "update_mask")
+		return
+	}
+	in := unmarshalRequestBody(w, r, &statspb.StatisticEntity{})
+	if in == nil {
+		return
+	}
+	entityId := pathParam(r, "id")
+	if in.Id != "" && in.Id != entityId {
+		writeInvalidFieldsResponse(w, "id must be the statistic in the path", "id")
+		return
+	}
+	in.Id = entityId
+
+	expectedVersion, err := parseIfMatch(r)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	userId := userIdOf(w, r, q.Get("user_id"), entityId)
+	if userId == "" {
+		return
+	}
+
+	entity, err := s.db.UpdateStatistic(r.Context(), userId, paths, in, expectedVersion)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	setETag(w, entity)
+	writeResponse(w, http.StatusOK, entity)
+}
+
+// DeleteStatV1 moves the statistic in the path to the trash. It's conditional if the request has an If-Match
+// header.
+func (s *Server) DeleteStatV1(w http.ResponseWriter, r *http.Request) {
+	expectedVersion, err := parseIfMatch(r)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	entityId := pathParam(r, "id")
+	userId := userIdOf(w, r, r.URL.Query().Get("user_id"), entityId)
+	if userId == "" {
+		return
+	}
+
+	if err = s.db.DeleteStatistic(r.Context(), userId, entityId, expectedVersion); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, &emptypb.Empty{})
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const apiKeysCollectionName = "ApiKeys"
+
+// maxApiKeyNameLength is the maximum number of bytes of the name of an API key.
+const maxApiKeyNameLength = 100
+
+// ApiKeyStorage keeps the API keys of the users. Keys are hashed by the caller, the storage never sees them.
+type ApiKeyStorage interface {
+	// CreateApiKey stores the given API key of key.UserId, which is identified by keyHash, and returns it after
+	// initializing its Id and CreatedAt. If any of the fields of key is invalid, an INVALID_ARGUMENT error is
+	// returned.
+	CreateApiKey(ctx context.Context, key *statspb.ApiKey, keyHash string) (*statspb.ApiKey, error)
+
+	// GetApiKey returns the API key identified by keyHash. If there is no such key, a NOT_FOUND error is
+	// returned.
+	GetApiKey(ctx context.Context, keyHash string) (*statspb.ApiKey, error)
+
+	// ListApiKeys returns the API keys of the user specified by userId, from the oldest to the newest.
+	ListApiKeys(ctx context.Context, userId string) ([]*statspb.ApiKey, error)
+
+	// RevokeApiKey permanently removes the API key specified by keyId, so that it cannot be used anymore. If
+	// there is no such key of the user specified by userId, a NOT_FOUND error is returned.
+	RevokeApiKey(ctx context.Context, userId, keyId string) error
+}
+
+// apiKeyEntity is the internal representation of an API key. Its id in the database is the hash of the key,
+// which is what it's looked up with.
+type apiKeyEntity struct {
+	KeyHash   string    `bson:"_id"`
+	Id        string    `bson:"key_id"`
+	UserId    string    `bson:"user_id"`
+	Name      string    `bson:"name"`
+	Prefix    string    `bson:"prefix"`
+	Scope     int32     `bson:"scope"`
+	EntityIds []string  `bson:"entity_ids,omitempty"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// newApiKeyEntity validates key and returns a new API key with its fields, which is identified by keyHash.
+func newApiKeyEntity(key *statspb.ApiKey, keyHash string) (*apiKeyEntity, error) {
+	switch {
+	case key.UserId == "":
+		return nil, NewErrorInvalidField("user_id", nil, "user_id cannot be empty")
+	case keyHash == "":
+		return nil, NewErrorInvalidArgument(nil, "key hash cannot be empty")
+	case len(key.Name) > maxApiKeyNameLength:
+		return nil, NewErrorInvalidField("name", nil, "name cannot be longer than %d bytes", maxApiKeyNameLength)
+	case key.Scope != statspb.ApiKey_READ_ONLY && key.Scope != statspb.ApiKey_READ_WRITE:
+		return nil, NewErrorInvalidField("scope", nil, "scope must be either READ_ONLY or READ_WRITE")
+	}
+	for _, id := range key.EntityIds {
+		if id == "" {
+			return nil, NewErrorInvalidField("entity_ids", nil, "entity_ids cannot have empty ids")
+		}
+	}
+	return &apiKeyEntity{
+		KeyHash:   keyHash,
+		Id:        primitive.NewObjectID().Hex(),
+		UserId:    key.UserId,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scope:     int32(key.Scope),
+		EntityIds: append([]string{}, key.EntityIds...),
+		CreatedAt: now(),
+	}, nil
+}
+
+// toPB converts this apiKeyEntity to *statspb.ApiKey.
+func (ke *apiKeyEntity) toPB() *statspb.ApiKey {
+	return &statspb.ApiKey{
+		Id:        ke.Id,
+		UserId:    ke.UserId,
+		Name:      ke.Name,
+		Prefix:    ke.Prefix,
+		Scope:     statspb.ApiKey_Scope(ke.Scope),
+		EntityIds: append([]string{}, ke.EntityIds...),
+		CreatedAt: timestampOf(ke.CreatedAt),
+	}
+}
+
+// apiKeys returns a handle to the API keys collection in MongoDB
+func (s *storage) apiKeys() *mongo.Collection {
+	return s.cli.Database(defaultDatabaseName).Collection(apiKeysCollectionName)
+}
+
+func (s *storage) CreateApiKey(ctx context.Context, key *statspb.ApiKey, keyHash string) (*statspb.ApiKey, error) {
+	ke, err := newApiKeyEntity(key, keyHash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.apiKeys().InsertOne(ctx, ke); err != nil {
+		return nil, NewErrorInternal(err, "error creating API key")
+	}
+	return ke.toPB(), nil
+}
+
+func (s *storage) GetApiKey(ctx context.Context, keyHash string) (*statspb.ApiKey, error) {
+	ke := &apiKeyEntity{}
+	if err := s.apiKeys().FindOne(ctx, bson.M{"_id": keyHash}).Decode(ke); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, NewErrorNotFound(nil, "API key not found")
+		}
+		return nil, NewErrorInternal(err, "error getting API key from database")
+	}
+	return ke.toPB(), nil
+}
+
+func (s *storage) ListApiKeys(ctx context.Context, userId string) ([]*statspb.ApiKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "key_id", Value: 1}})
+	cursor, err := s.apiKeys().Find(ctx, bson.M{"user_id": userId}, opts)
+	if err != nil {
+		return nil, NewErrorInternal(err, "error listing API keys")
+	}
+	entities := []*apiKeyEntity{}
+	if err = cursor.All(ctx, &entities); err != nil {
+		return nil, NewErrorInternal(err, "error decoding API keys")
+	}
+	keys := make([]*statspb.ApiKey, len(entities))
+	for i, ke := range entities {
+		keys[i] = ke.toPB()
+	}
+	return keys, nil
+}
+
+func (s *storage) RevokeApiKey(ctx context.Context, userId, keyId string) error {
+	res, err := s.apiKeys().DeleteOne(ctx, bson.M{"key_id": keyId, "user_id": userId})
+	if err != nil {
+		return NewErrorInternal(err, "error revoking API key")
+	}
+	if res.DeletedCount == 0 {
+		return NewErrorNotFound(nil, "API key not found")
+	}
+	return nil
+}
+
+func (s *memoryStorage) CreateApiKey(ctx context.Context, key *statspb.ApiKey, keyHash string) (*statspb.ApiKey, error) {
+	ke, err := newApiKeyEntity(key, keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = putJournaled(s, apiKeysCollectionName, s.apiKeys, ke.KeyHash, ke); err != nil {
+		return nil, err
+	}
+	return ke.toPB(), nil
+}
+
+func (s *memoryStorage) GetApiKey(ctx context.Context, keyHash string) (*statspb.ApiKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ke, ok := s.apiKeys[keyHash]
+	if !ok {
+		return nil, NewErrorNotFound(nil, "API key not found")
+	}
+	return ke.toPB(), nil
+}
+
+func (s *memoryStorage) ListApiKeys(ctx context.Context, userId string) ([]*statspb.ApiKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entities := []*apiKeyEntity{}
+	for _, ke := range s.apiKeys {
+		if ke.UserId == userId {
+			entities = append(entities, ke)
+		}
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		if c := compareTimes(entities[i].CreatedAt, entities[j].CreatedAt); c != 0 {
+			return c < 0
+		}
+		return entities[i].Id < entities[j].Id
+	})
+	keys := make([]*statspb.ApiKey, len(entities))
+	for i, ke := range entities {
+		keys[i] = ke.toPB()
+	}
+	return keys, nil
+}
+
+func (s *memoryStorage) RevokeApiKey(ctx context.Context, userId, keyId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for keyHash, ke := range s.apiKeys {
+		if ke.Id == keyId && ke.UserId == userId {
+			return removeJournaled(s, apiKeysCollectionName, s.apiKeys, keyHash)
+		}
+	}
+	return NewErrorNotFound(nil, "API key not found")
+}
@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Component describes a type of statistic component, which is one of the fields of the "component" oneof
+// of statspb.StatisticEntity. The storage and the server work with components only through this interface,
+// so a new type of component is added by implementing it and registering it with RegisterComponent.
+type Component interface {
+	// Type returns the ComponentType that represents this component.
+	Type() statspb.ComponentType
+
+	// Path returns the field-mask path of this component in UpdateStatistic, which is also the key it's
+	// stored under in the database.
+	Path() string
+
+	// New returns a new, empty value of this component.
+	New() proto.Message
+
+	// Get returns the value of this component in entity, or nil if entity doesn't have this component.
+	Get(entity *statspb.StatisticEntity) proto.Message
+
+	// Set sets value as the component of entity.
+	Set(entity *statspb.StatisticEntity, value proto.Message)
+
+	// Validate returns an INVALID_ARGUMENT error if value has invalid data.
+	Validate(value proto.Message) error
+
+	// Merge returns the value that existing becomes when it's updated with update.
+	Merge(existing, update proto.Message) proto.Message
+
+	// Encode returns the representation of value that is stored in the database.
+	Encode(value proto.Message) (any, error)
+
+	// Decode returns the value that raw, the stored representation of a value, represents.
+	Decode(raw bson.RawValue) (proto.Message, error)
+}
+
+var (
+	componentsMu sync.RWMutex
+	components   []Component
+)
+
+func init() {
+	RegisterComponent(&protoComponent[statspb.ComponentCounter, *statspb.ComponentCounter]{
+		typ:  statspb.ComponentType_COUNTER,
+		path: "counter",
+		get:  (*statspb.StatisticEntity).GetCounter,
+		set: func(entity *statspb.StatisticEntity, value *statspb.ComponentCounter) {
+			entity.Component = &statspb.StatisticEntity_Counter{Counter: value}
+		},
+	})
+	RegisterComponent(&protoComponent[statspb.ComponentDate, *statspb.ComponentDate]{
+		typ:  statspb.ComponentType_DATE,
+		path: "date",
+		get:  (*statspb.StatisticEntity).GetDate,
+		set: func(entity *statspb.StatisticEntity, value *statspb.ComponentDate) {
+			entity.Component = &statspb.StatisticEntity_Date{Date: value}
+		},
+		validate: func(value *statspb.ComponentDate) error {
+			return validateTimestamps(value.Timestamps...)
+		},
+	})
+	RegisterComponent(&protoComponent[statspb.ComponentMultiValue, *statspb.ComponentMultiValue]{
+		typ:  statspb.ComponentType_MULTI_VALUE,
+		path: "multi_value",
+		get:  (*statspb.StatisticEntity).GetMultiValue,
+		set: func(entity *statspb.StatisticEntity, value *statspb.ComponentMultiValue) {
+			entity.Component = &statspb.StatisticEntity_MultiValue{MultiValue: value}
+		},
+		validate: validateMultiValue,
+	})
+}
+
+// RegisterComponent makes the given component available to the storage. It's intended to be called
+// from init functions. It panics if a component with the same type, path or value type is already
+// registered.
+func RegisterComponent(c Component) {
+	componentsMu.Lock()
+	defer componentsMu.Unlock()
+
+	for _, registered := range components {
+		if registered.Type() == c.Type() || registered.Path() == c.Path() || reflect.TypeOf(registered.New()) == reflect.TypeOf(c.New()) {
+			panic(fmt.Sprintf("storage: component %s (%s) is registered twice", c.Type(), c.Path()))
+		}
+	}
+	components = append(components, c)
+}
+
+// Components returns all registered components, in the order they're registered.
+func Components() []Component {
+	componentsMu.RLock()
+	defer componentsMu.RUnlock()
+	return append([]Component{}, components...)
+}
+
+// ComponentByPath returns the registered component with the given path, or nil if there is none.
+func ComponentByPath(path string) Component {
+	for _, c := range Components() {
+		if c.Path() == path {
+			return c
+		}
+	}
+	return nil
+}
+
+// ComponentByType returns the registered component with the given type, or nil if there is none.
+func ComponentByType(typ statspb.ComponentType) Component {
+	for _, c := range Components() {
+		if c.Type() == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+// ComponentOf returns the registered component of entity along with its value. It returns nil values if
+// entity doesn't have a component or its component is not registered.
+func ComponentOf(entity *statspb.StatisticEntity) (Component, proto.Message) {
+	for _, c := range Components() {
+		if value := c.Get(entity); value != nil {
+			return c, value
+		}
+	}
+	return nil, nil
+}
+
+// componentOfValue returns the registered component that value is a value of, or nil if there is none.
+func componentOfValue(value proto.Message) Component {
+	for _, c := range Components() {
+		if reflect.TypeOf(c.New()) == reflect.TypeOf(value) {
+			return c
+		}
+	}
+	return nil
+}
+
+// componentTypeOf returns the type of the component with the given value, which may be nil.
+func componentTypeOf(value proto.Message) statspb.ComponentType {
+	if c := componentOfValue(value); c != nil {
+		return c.Type()
+	}
+	return statspb.ComponentType_NONE
+}
+
+// protoComponent is a generic implementation of Component whose values are of type *T. Values are
+// stored in the database as they're encoded by the default bson codecs, and they're replaced as a whole
+// when they're updated.
+type protoComponent[T any, PT interface {
+	*T
+	proto.Message
+}] struct {
+	typ  statspb.ComponentType
+	path string
+	get  func(entity *statspb.StatisticEntity) PT
+	set  func(entity *statspb.StatisticEntity, value PT)
+	// validate is optional, values are considered valid if it's nil.
+	validate func(value PT) error
+}
+
+func (c *protoComponent[T, PT]) Type() statspb.ComponentType {
+	return c.typ
+}
+
+func (c *protoComponent[T, PT]) Path() string {
+	return c.path
+}
+
+func (c *protoComponent[T, PT]) New() proto.Message {
+	return PT(new(T))
+}
+
+func (c *protoComponent[T, PT]) Get(entity *statspb.StatisticEntity) proto.Message {
+	if value := c.get(entity); value != nil {
+		return value
+	}
+	// a nil PT would make a non-nil proto.Message
+	return nil
+}
+
+func (c *protoComponent[T, PT]) Set(entity *statspb.StatisticEntity, value proto.Message) {
+	c.set(entity, value.(PT))
+}
+
+func (c *protoComponent[T, PT]) Validate(value proto.Message) error {
+	v, ok := value.(PT)
+	if !ok || v == nil {
+		return NewErrorInvalidArgument(nil, "invalid %s component", c.typ)
+	}
+	if c.validate == nil {
+		return nil
+	}
+	return c.validate(v)
+}
+
+func (c *protoComponent[T, PT]) Merge(existing, update proto.Message) proto.Message {
+	return update
+}
+
+func (c *protoComponent[T, PT]) Encode(value proto.Message) (any, error) {
+	return value, nil
+}
+
+func (c *protoComponent[T, PT]) Decode(raw bson.RawValue) (proto.Message, error) {
+	value := PT(new(T))
+	if err := raw.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// validateComponent returns an INVALID_ARGUMENT error if entity doesn't have a registered component or
+// its component has invalid values.
+func validateComponent(entity *statspb.StatisticEntity) error {
+	c, value := ComponentOf(entity)
+	if c == nil {
+		return NewErrorInvalidField("component", nil, "component cannot be empty")
+	}
+	return c.Validate(value)
+}
+
+// validateMultiValue returns an INVALID_ARGUMENT error if comp has no fields, a field is invalid or any
+// sample has a value for a field that is not one of comp's fields.
+func validateMultiValue(comp *statspb.ComponentMultiValue) error {
+	if len(comp.Fields) == 0 {
+		return NewErrorInvalidArgument(nil, "multi-value component must have at least one field")
+	}
+	fields := map[string]bool{}
+	for _, f := range comp.Fields {
+		// field names are used as keys of documents, which cannot have these characters
+		if f == "" || strings.HasPrefix(f, "$") || strings.Contains(f, ".") {
+			return NewErrorInvalidArgument(nil, "invalid multi-value field %q", f)
+		}
+		if fields[f] {
+			return NewErrorInvalidArgument(nil, "duplicate multi-value field %q", f)
+		}
+		fields[f] = true
+	}
+
+	for i, sample := range comp.Samples {
+		if err := sample.GetTimestamp().CheckValid(); err != nil {
+			return NewErrorInvalidArgument(err, "invalid timestamp in sample %d", i)
+		}
+		if len(sample.Values) == 0 {
+			return NewErrorInvalidArgument(nil, "sample %d has no values", i)
+		}
+		for f, v := range sample.Values {
+			if !fields[f] {
+				return NewErrorInvalidArgument(nil, "sample %d has a value for unknown field %q", i, f)
+			}
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return NewErrorInvalidArgument(nil, "sample %d has a non-finite value for field %q", i, f)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_RegisterComponent_Duplicate(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("registering a component twice did not panic")
+		}
+	}()
+	RegisterComponent(ComponentByPath("counter"))
+}
+
+func Test_ComponentOf(t *testing.T) {
+	for _, c := range Components() {
+		entity := &statspb.StatisticEntity{}
+		c.Set(entity, c.New())
+		if entity.GetComponentType() != c.Type() {
+			t.Fatalf("wrong component type: expected=%s, got=%s", c.Type(), entity.GetComponentType())
+		}
+		if got, _ := ComponentOf(entity); got != c {
+			t.Fatalf("ComponentOf returned the wrong component for %s", c.Type())
+		}
+	}
+
+	if c, value := ComponentOf(&statspb.StatisticEntity{}); c != nil || value != nil {
+		t.Fatalf("ComponentOf returned non-nil values for an entity without a component")
+	}
+}
+
+func Test_statisticEntity_BSON(t *testing.T) {
+	se := &statisticEntity{
+		Id:     "id-1",
+		Name:   "entity-1",
+		UserId: "user-1",
+		Component: &statspb.ComponentDate{
+			Timestamps: []*timestamppb.Timestamp{{Seconds: 1, Nanos: 2}},
+		},
+	}
+	data, err := bson.Marshal(se)
+	if err != nil {
+		t.Fatalf("error marshaling statisticEntity: %v", err)
+	}
+	if _, err = bson.Raw(data).LookupErr("date", "timestamps"); err != nil {
+		t.Fatalf("component is not stored under its path: %v", err)
+	}
+
+	got := &statisticEntity{}
+	if err = bson.Unmarshal(data, got); err != nil {
+		t.Fatalf("error unmarshaling statisticEntity: %v", err)
+	}
+	if !proto.Equal(se.toPB(), got.toPB()) {
+		t.Fatalf("wrong result: expected=%v, got=%v", se.toPB(), got.toPB())
+	}
+
+	// documents that are written before components were registered have null values for the components
+	// the entity doesn't have
+	data, err = bson.Marshal(bson.D{
+		{Key: "_id", Value: "id-1"},
+		{Key: "name", Value: "entity-1"},
+		{Key: "user_id", Value: "user-1"},
+		{Key: "counter", Value: nil},
+		{Key: "date", Value: bson.D{{Key: "timestamps", Value: bson.A{bson.D{{Key: "seconds", Value: int64(1)}, {Key: "nanos", Value: int32(2)}}}}}},
+		{Key: "deleted", Value: false},
+	})
+	if err != nil {
+		t.Fatalf("error marshaling document: %v", err)
+	}
+	got = &statisticEntity{}
+	if err = bson.Unmarshal(data, got); err != nil {
+		t.Fatalf("error unmarshaling statisticEntity: %v", err)
+	}
+	if !proto.Equal(se.toPB(), got.toPB()) {
+		t.Fatalf("wrong result: expected=%v, got=%v", se.toPB(), got.toPB())
+	}
+}
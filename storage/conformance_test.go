@@ -0,0 +1,1410 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// runConformanceTests runs the tests that check the documented contract of StatsKeeperStorage against
+// the implementation created by newStorage. Every implementation of StatsKeeperStorage should run them.
+// newStorage is called once for each test and must return an empty storage.
+func runConformanceTests(t *testing.T, newStorage func(t *testing.T) StatsKeeperStorage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s StatsKeeperStorage)
+	}{
+		{name: "create overrides id", test: conformanceCreateOverridesId},
+		{name: "get not found", test: conformanceGetNotFound},
+		{name: "update immutable fields", test: conformanceUpdateImmutableFields},
+		{name: "update component type", test: conformanceUpdateComponentType},
+		{name: "update no update possible", test: conformanceUpdateNoUpdate},
+		{name: "update success", test: conformanceUpdateSuccess},
+		{name: "delete hides entity", test: conformanceDeleteHidesEntity},
+		{name: "delete not found", test: conformanceDeleteNotFound},
+		{name: "delete deleted", test: conformanceDeleteDeleted},
+		{name: "increment counter", test: conformanceIncrementCounter},
+		{name: "multi-value", test: conformanceMultiValue},
+		{name: "append timestamps", test: conformanceAppendTimestamps},
+		{name: "remove timestamps", test: conformanceRemoveTimestamps},
+		{name: "list", test: conformanceList},
+		{name: "timestamps", test: conformanceTimestamps},
+		{name: "list order", test: conformanceListOrder},
+		{name: "list pages", test: conformanceListPages},
+		{name: "list filters", test: conformanceListFilters},
+		{name: "trash", test: conformanceTrash},
+		{name: "purge deleted", test: conformancePurgeDeleted},
+		{name: "versions", test: conformanceVersions},
+		{name: "concurrent conditional updates", test: conformanceConcurrentConditionalUpdates},
+		{name: "history", test: conformanceHistory},
+		{name: "history pages", test: conformanceHistoryPages},
+		{name: "revert", test: conformanceRevert},
+		{name: "ownership", test: conformanceOwnership},
+		{name: "users", test: conformanceUsers},
+		{name: "login failures", test: conformanceLoginFailures},
+		{name: "sessions", test: conformanceSessions},
+		{name: "api keys", test: conformanceApiKeys},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+func conformanceCreateOverridesId(t *testing.T, s StatsKeeperStorage) {
+	in := newTestDateEntity("user-1", "entity-1", &timestamppb.Timestamp{Seconds: 1, Nanos: 1})
+	in.Id = "overriden-id"
+
+	created, err := s.CreateStatistic(context.TODO(), in)
+	if err != nil {
+		t.Fatalf("CreateStatistic returned unexpected error: %v", err)
+	}
+	if created.Id == "" || created.Id == "overriden-id" {
+		t.Fatalf("CreateStatistic did not generate a new id, got=%q", created.Id)
+	}
+	in.Id = created.Id
+	copyManagedFields(in, created)
+	compareEntities(t, in, created)
+
+	got, err := s.GetStatistic(context.TODO(), created.UserId, created.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned error after creating the entity: %v", err)
+	}
+	compareEntities(t, created, got)
+}
+
+func conformanceGetNotFound(t *testing.T, s StatsKeeperStorage) {
+	_, err := s.GetStatistic(context.TODO(), "user-1", "id-1")
+	compareErrors(t, NewErrorNotFound(nil, "statistic not found"), err)
+}
+
+func conformanceUpdateImmutableFields(t *testing.T, s StatsKeeperStorage) {
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+
+	for _, field := range []string{"id", "user_id"} {
+		_, err := s.UpdateStatistic(context.TODO(), "user-1", []string{"name", field}, &statspb.StatisticEntity{
+			Id:     created.Id,
+			Name:   "entity-1-updated",
+			UserId: "user-2",
+		}, 0)
+		compareErrors(t, NewErrorInvalidArgument(nil, "fields 'id', 'user_id' cannot be modified"), err)
+	}
+
+	// rejected updates must not be applied partially
+	got, err := s.GetStatistic(context.TODO(), created.UserId, created.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	compareEntities(t, created, got)
+}
+
+func conformanceUpdateComponentType(t *testing.T, s StatsKeeperStorage) {
+	counter := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	date := createTestEntity(t, s, newTestDateEntity("user-1", "entity-2"))
+
+	values := newTestDateEntity("", "")
+	values.Id = counter.Id
+	_, err := s.UpdateStatistic(context.TODO(), "user-1", []string{"date"}, values, 0)
+	compareErrors(t, NewErrorInvalidArgument(nil, "component cannot be changed from %s to %s", statspb.ComponentType_COUNTER, statspb.ComponentType_DATE), err)
+
+	values = newTestCounterEntity("", "", 2)
+	values.Id = date.Id
+	_, err = s.UpdateStatistic(context.TODO(), "user-1", []string{"counter"}, values, 0)
+	compareErrors(t, NewErrorInvalidArgument(nil, "component cannot be changed from %s to %s", statspb.ComponentType_DATE, statspb.ComponentType_COUNTER), err)
+}
+
+func conformanceUpdateNoUpdate(t *testing.T, s StatsKeeperStorage) {
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	tests := []struct {
+		name   string
+		fields []string
+		values *statspb.StatisticEntity
+	}{
+		{
+			name:   "no fields",
+			fields: nil,
+			values: &statspb.StatisticEntity{Id: created.Id, Name: "entity-1-updated"},
+		},
+		{
+			name:   "unknown fields",
+			fields: []string{"invalid-field-1", "invalid-field-2"},
+			values: &statspb.StatisticEntity{Id: created.Id, Name: "entity-1-updated"},
+		},
+		{
+			name:   "component not set in values",
+			fields: []string{"counter"},
+			values: &statspb.StatisticEntity{Id: created.Id},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.UpdateStatistic(context.TODO(), "user-1", tt.fields, tt.values, 0)
+			compareErrors(t, NewErrorNoUpdate(nil, "no update possible"), err)
+		})
+	}
+}
+
+func conformanceUpdateSuccess(t *testing.T, s StatsKeeperStorage) {
+	counter := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	date := createTestEntity(t, s, newTestDateEntity("user-1", "entity-2"))
+
+	values := newTestCounterEntity("", "entity-1-updated", 5)
+	values.Id = counter.Id
+	got, err := s.UpdateStatistic(context.TODO(), "user-1", []string{"name", "counter"}, values, 0)
+	if err != nil {
+		t.Fatalf("UpdateStatistic returned unexpected error: %v", err)
+	}
+	expected := newTestCounterEntity("user-1", "entity-1-updated", 5)
+	expected.Id = counter.Id
+	copyManagedFields(expected, got)
+	compareEntities(t, expected, got)
+
+	// only the requested fields are updated
+	values = newTestDateEntity("", "entity-2-updated", &timestamppb.Timestamp{Seconds: 2, Nanos: 2})
+	values.Id = date.Id
+	got, err = s.UpdateStatistic(context.TODO(), "user-1", []string{"date"}, values, 0)
+	if err != nil {
+		t.Fatalf("UpdateStatistic returned unexpected error: %v", err)
+	}
+	expected = newTestDateEntity("user-1", "entity-2", &timestamppb.Timestamp{Seconds: 2, Nanos: 2})
+	expected.Id = date.Id
+	copyManagedFields(expected, got)
+	compareEntities(t, expected, got)
+
+	// the update must be persisted
+	got, err = s.GetStatistic(context.TODO(), date.UserId, date.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	compareEntities(t, expected, got)
+}
+
+func conformanceDeleteHidesEntity(t *testing.T, s StatsKeeperStorage) {
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	if err := s.DeleteStatistic(context.TODO(), created.UserId, created.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+
+	_, err := s.GetStatistic(context.TODO(), created.UserId, created.Id)
+	compareErrors(t, NewErrorNotFound(nil, "statistic not found"), err)
+
+	values := newTestCounterEntity("", "entity-1-updated", 2)
+	values.Id = created.Id
+	_, err = s.UpdateStatistic(context.TODO(), "user-1", []string{"name"}, values, 0)
+	compareErrors(t, NewErrorNotFound(nil, "statistic not found"), err)
+
+	list, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	compareEntityLists(t, []*statspb.StatisticEntity{}, list.Entities)
+}
+
+func conformanceDeleteNotFound(t *testing.T, s StatsKeeperStorage) {
+	err := s.DeleteStatistic(context.TODO(), "user-1", "id-1", 0)
+	compareErrors(t, NewErrorNotFound(nil, "statistic not found"), err)
+}
+
+func conformanceDeleteDeleted(t *testing.T, s StatsKeeperStorage) {
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	if err := s.DeleteStatistic(context.TODO(), created.UserId, created.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+	trash, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", Deleted: true})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+
+	// deleting it again must neither restart its retention nor record another change
+	err = s.DeleteStatistic(context.TODO(), created.UserId, created.Id, 0)
+	compareErrors(t, NewErrorNotFound(nil, "statistic not found"), err)
+	err = s.DeleteStatistic(context.TODO(), created.UserId, created.Id, 2)
+	compareErrors(t, NewErrorNotFound(nil, "statistic not found"), err)
+
+	list, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", Deleted: true})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	compareEntityLists(t, trash.Entities, list.Entities)
+	history, err := s.ListHistory(context.TODO(), created.UserId, &statspb.ListHistoryRequest{EntityId: created.Id})
+	if err != nil {
+		t.Fatalf("ListHistory returned unexpected error: %v", err)
+	}
+	if len(history.Events) != 2 {
+		t.Fatalf("wrong number of history events: expected=2, got=%d", len(history.Events))
+	}
+}
+
+func conformanceIncrementCounter(t *testing.T, s StatsKeeperStorage) {
+	counter := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	date := createTestEntity(t, s, newTestDateEntity("user-1", "entity-2"))
+	deleted := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-3", 1))
+	if err := s.DeleteStatistic(context.TODO(), deleted.UserId, deleted.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		entityId      string
+		delta         int64
+		expectedError error
+		expectedCount uint32
+	}{
+		{name: "not found", entityId: "id-1", delta: 1, expectedError: NewErrorNotFound(nil, "statistic not found")},
+		{name: "deleted", entityId: deleted.Id, delta: 1, expectedError: NewErrorNotFound(nil, "statistic not found")},
+		{name: "not a counter", entityId: date.Id, delta: 1, expectedError: NewErrorInvalidArgument(nil, "component must be %s, not %s", statspb.ComponentType_COUNTER, statspb.ComponentType_DATE)},
+		{name: "zero delta", entityId: counter.Id, delta: 0, expectedError: NewErrorNoUpdate(nil, "no update possible")},
+		{name: "increment", entityId: counter.Id, delta: 5, expectedCount: 6},
+		{name: "decrement", entityId: counter.Id, delta: -2, expectedCount: 4},
+		{name: "underflow", entityId: counter.Id, delta: -5, expectedError: NewErrorInvalidArgument(nil, "count cannot go below zero")},
+		{name: "overflow", entityId: counter.Id, delta: math.MaxUint32, expectedError: NewErrorInvalidArgument(nil, "count cannot exceed %d", uint32(math.MaxUint32))},
+		{name: "huge negative", entityId: counter.Id, delta: math.MinInt64, expectedError: NewErrorInvalidArgument(nil, "count cannot go below zero")},
+		{name: "to zero", entityId: counter.Id, delta: -4, expectedCount: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.IncrementCounter(context.TODO(), "user-1", tt.entityId, tt.delta)
+			if hasError := compareErrors(t, tt.expectedError, err); hasError {
+				return
+			}
+			if got.GetCounter().GetCount() != tt.expectedCount {
+				t.Fatalf("wrong count: expected=%d, got=%d", tt.expectedCount, got.GetCounter().GetCount())
+			}
+
+			stored, err := s.GetStatistic(context.TODO(), "user-1", tt.entityId)
+			if err != nil {
+				t.Fatalf("GetStatistic returned unexpected error: %v", err)
+			}
+			compareEntities(t, got, stored)
+		})
+	}
+}
+
+func conformanceMultiValue(t *testing.T, s StatsKeeperStorage) {
+	_, err := s.CreateStatistic(context.TODO(), newTestMultiValueEntity("user-1", "entity-1", []string{"weight"},
+		&statspb.MultiValueSample{Timestamp: ts(1), Values: map[string]float64{"height": 180}},
+	))
+	compareErrors(t, NewErrorInvalidArgument(nil, "sample %d has a value for unknown field %q", 0, "height"), err)
+
+	created := createTestEntity(t, s, newTestMultiValueEntity("user-1", "entity-1", []string{"systolic", "diastolic"},
+		&statspb.MultiValueSample{Timestamp: ts(1), Values: map[string]float64{"systolic": 120, "diastolic": 80}},
+	))
+	got, err := s.GetStatistic(context.TODO(), created.UserId, created.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	compareEntities(t, created, got)
+
+	values := newTestCounterEntity("", "", 1)
+	values.Id = created.Id
+	_, err = s.UpdateStatistic(context.TODO(), "user-1", []string{"counter"}, values, 0)
+	compareErrors(t, NewErrorInvalidArgument(nil, "component cannot be changed from %s to %s", statspb.ComponentType_MULTI_VALUE, statspb.ComponentType_COUNTER), err)
+
+	values = newTestMultiValueEntity("", "", []string{"systolic", "diastolic", "pulse"},
+		&statspb.MultiValueSample{Timestamp: ts(1), Values: map[string]float64{"systolic": 120, "diastolic": 80}},
+		&statspb.MultiValueSample{Timestamp: ts(2), Values: map[string]float64{"systolic": 125.5, "diastolic": 85, "pulse": 70}},
+	)
+	values.Id = created.Id
+	got, err = s.UpdateStatistic(context.TODO(), "user-1", []string{"multi_value"}, values, 0)
+	if err != nil {
+		t.Fatalf("UpdateStatistic returned unexpected error: %v", err)
+	}
+	values.Name = created.Name
+	values.UserId = created.UserId
+	copyManagedFields(values, got)
+	compareEntities(t, values, got)
+
+	values.GetMultiValue().Fields = []string{"systolic", "systolic"}
+	_, err = s.UpdateStatistic(context.TODO(), "user-1", []string{"multi_value"}, values, 0)
+	compareErrors(t, NewErrorInvalidArgument(nil, "duplicate multi-value field %q", "systolic"), err)
+}
+
+func conformanceAppendTimestamps(t *testing.T, s StatsKeeperStorage) {
+	counter := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	date := createTestEntity(t, s, newTestDateEntity("user-1", "entity-2", ts(5), ts(1)))
+
+	tests := []struct {
+		name          string
+		entityId      string
+		timestamps    []*timestamppb.Timestamp
+		unique        bool
+		expectedError error
+		expected      []*timestamppb.Timestamp
+	}{
+		{name: "not found", entityId: "id-1", timestamps: []*timestamppb.Timestamp{ts(1)}, expectedError: NewErrorNotFound(nil, "statistic not found")},
+		{name: "not a date", entityId: counter.Id, timestamps: []*timestamppb.Timestamp{ts(1)}, expectedError: NewErrorInvalidArgument(nil, "component must be %s, not %s", statspb.ComponentType_DATE, statspb.ComponentType_COUNTER)},
+		{name: "invalid timestamp", entityId: date.Id, timestamps: []*timestamppb.Timestamp{{Nanos: -1}}, expectedError: NewErrorInvalidArgument((&timestamppb.Timestamp{Nanos: -1}).CheckValid(), "invalid timestamp")},
+		{name: "no timestamps", entityId: date.Id, expectedError: NewErrorNoUpdate(nil, "no update possible")},
+		{name: "sorted", entityId: date.Id, timestamps: []*timestamppb.Timestamp{ts(3), ts(1)}, expected: []*timestamppb.Timestamp{ts(1), ts(1), ts(3), ts(5)}},
+		{name: "unique", entityId: date.Id, timestamps: []*timestamppb.Timestamp{ts(3), ts(4), ts(4)}, unique: true, expected: []*timestamppb.Timestamp{ts(1), ts(3), ts(4), ts(5)}},
+		{name: "nanos", entityId: date.Id, timestamps: []*timestamppb.Timestamp{{Seconds: 3, Nanos: 1}}, expected: []*timestamppb.Timestamp{ts(1), ts(3), {Seconds: 3, Nanos: 1}, ts(4), ts(5)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.AppendTimestamps(context.TODO(), "user-1", tt.entityId, tt.timestamps, tt.unique)
+			if hasError := compareErrors(t, tt.expectedError, err); hasError {
+				return
+			}
+			compareTimestampLists(t, tt.expected, got.GetDate().GetTimestamps())
+
+			stored, err := s.GetStatistic(context.TODO(), "user-1", tt.entityId)
+			if err != nil {
+				t.Fatalf("GetStatistic returned unexpected error: %v", err)
+			}
+			compareEntities(t, got, stored)
+		})
+	}
+}
+
+func conformanceRemoveTimestamps(t *testing.T, s StatsKeeperStorage) {
+	counter := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	date := createTestEntity(t, s, newTestDateEntity("user-1", "entity-2", ts(1), ts(2), ts(2), ts(3), ts(4), ts(5), ts(6)))
+
+	_, err := s.RemoveTimestamps(context.TODO(), counter.UserId, counter.Id, []*timestamppb.Timestamp{ts(1)})
+	compareErrors(t, NewErrorInvalidArgument(nil, "component must be %s, not %s", statspb.ComponentType_DATE, statspb.ComponentType_COUNTER), err)
+	_, err = s.RemoveTimestampRange(context.TODO(), date.UserId, date.Id, ts(2), ts(1))
+	compareErrors(t, NewErrorInvalidField("from", nil, "from cannot be after to"), err)
+
+	got, err := s.RemoveTimestamps(context.TODO(), date.UserId, date.Id, []*timestamppb.Timestamp{ts(2), ts(6), ts(7)})
+	if err != nil {
+		t.Fatalf("RemoveTimestamps returned unexpected error: %v", err)
+	}
+	compareTimestampLists(t, []*timestamppb.Timestamp{ts(1), ts(3), ts(4), ts(5)}, got.GetDate().GetTimestamps())
+
+	got, err = s.RemoveTimestampRange(context.TODO(), date.UserId, date.Id, ts(3), ts(4))
+	if err != nil {
+		t.Fatalf("RemoveTimestampRange returned unexpected error: %v", err)
+	}
+	compareTimestampLists(t, []*timestamppb.Timestamp{ts(1), ts(5)}, got.GetDate().GetTimestamps())
+
+	// nanos are taken into account at both ends of the range
+	got, err = s.RemoveTimestampRange(context.TODO(), date.UserId, date.Id, &timestamppb.Timestamp{Seconds: 1, Nanos: 1}, &timestamppb.Timestamp{Seconds: 5, Nanos: 1})
+	if err != nil {
+		t.Fatalf("RemoveTimestampRange returned unexpected error: %v", err)
+	}
+	compareTimestampLists(t, []*timestamppb.Timestamp{ts(1)}, got.GetDate().GetTimestamps())
+
+	stored, err := s.GetStatistic(context.TODO(), date.UserId, date.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	compareEntities(t, got, stored)
+}
+
+func conformanceList(t *testing.T, s StatsKeeperStorage) {
+	list, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	if list.Entities == nil {
+		t.Fatalf("ListUserStatistics returned nil entities instead of an empty slice")
+	}
+
+	var expected []*statspb.StatisticEntity
+	for _, e := range []*statspb.StatisticEntity{
+		newTestCounterEntity("user-1", "entity-1", 1),
+		newTestDateEntity("user-2", "entity-2"),
+		newTestDateEntity("user-1", "entity-3", &timestamppb.Timestamp{Seconds: 1, Nanos: 2}),
+		newTestCounterEntity("user-1", "entity-4", 4),
+	} {
+		created := createTestEntity(t, s, e)
+		if created.UserId == "user-1" {
+			expected = append(expected, created)
+		}
+	}
+
+	list, err = s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	for i := 1; i < len(list.Entities); i++ {
+		if list.Entities[i-1].Id >= list.Entities[i].Id {
+			t.Fatalf("entities are not ordered by id: %q is before %q", list.Entities[i-1].Id, list.Entities[i].Id)
+		}
+	}
+	sortEntitiesById(expected)
+	compareEntityLists(t, expected, list.Entities)
+}
+
+func conformanceTimestamps(t *testing.T, s StatsKeeperStorage) {
+	clock := newTestClock(t)
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	compareTimestamp(t, clock.timestamp(), created.CreatedAt)
+	compareTimestamp(t, clock.timestamp(), created.UpdatedAt)
+	compareTimestamp(t, nil, created.DeletedAt)
+
+	clock.advance(time.Second)
+	values := newTestCounterEntity("", "entity-1-updated", 2)
+	values.Id = created.Id
+	got, err := s.UpdateStatistic(context.TODO(), "user-1", []string{"name"}, values, 0)
+	if err != nil {
+		t.Fatalf("UpdateStatistic returned unexpected error: %v", err)
+	}
+	compareTimestamp(t, created.CreatedAt, got.CreatedAt)
+	compareTimestamp(t, clock.timestamp(), got.UpdatedAt)
+
+	clock.advance(time.Second)
+	if got, err = s.IncrementCounter(context.TODO(), created.UserId, created.Id, 1); err != nil {
+		t.Fatalf("IncrementCounter returned unexpected error: %v", err)
+	}
+	compareTimestamp(t, created.CreatedAt, got.CreatedAt)
+	compareTimestamp(t, clock.timestamp(), got.UpdatedAt)
+
+	date := createTestEntity(t, s, newTestDateEntity("user-1", "entity-2"))
+	clock.advance(time.Second)
+	if got, err = s.AppendTimestamps(context.TODO(), date.UserId, date.Id, []*timestamppb.Timestamp{ts(1)}, false); err != nil {
+		t.Fatalf("AppendTimestamps returned unexpected error: %v", err)
+	}
+	compareTimestamp(t, clock.timestamp(), got.UpdatedAt)
+	clock.advance(time.Second)
+	if got, err = s.RemoveTimestamps(context.TODO(), date.UserId, date.Id, []*timestamppb.Timestamp{ts(1)}); err != nil {
+		t.Fatalf("RemoveTimestamps returned unexpected error: %v", err)
+	}
+	compareTimestamp(t, clock.timestamp(), got.UpdatedAt)
+
+	// the timestamps are managed by the storage only
+	for _, field := range []string{"created_at", "updated_at", "deleted_at", "version"} {
+		values := newTestCounterEntity("", "", 3)
+		values.Id = created.Id
+		values.CreatedAt = ts(1)
+		values.UpdatedAt = ts(1)
+		values.DeletedAt = ts(1)
+		_, err = s.UpdateStatistic(context.TODO(), "user-1", []string{"counter", field}, values, 0)
+		compareErrors(t, NewErrorInvalidArgument(nil, "fields 'created_at', 'updated_at', 'deleted_at', 'version' are managed by the server"), err)
+	}
+	in := newTestCounterEntity("user-1", "entity-3", 1)
+	in.CreatedAt = ts(1)
+	in.DeletedAt = ts(1)
+	got = createTestEntity(t, s, in)
+	compareTimestamp(t, clock.timestamp(), got.CreatedAt)
+	compareTimestamp(t, nil, got.DeletedAt)
+}
+
+func conformanceListOrder(t *testing.T, s StatsKeeperStorage) {
+	clock := newTestClock(t)
+	// ids are in the order of creation, which is different from the order of the timestamps
+	clock.advance(3 * time.Second)
+	first := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	clock.advance(-2 * time.Second)
+	second := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-2", 2))
+	third := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-3", 3))
+	clock.advance(5 * time.Second)
+	second, err := s.IncrementCounter(context.TODO(), second.UserId, second.Id, 1)
+	if err != nil {
+		t.Fatalf("IncrementCounter returned unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		req      *statspb.ListUserStatisticsRequest
+		expected []*statspb.StatisticEntity
+	}{
+		{
+			name:     "id",
+			req:      &statspb.ListUserStatisticsRequest{OrderBy: statspb.ListUserStatisticsRequest_ID},
+			expected: []*statspb.StatisticEntity{first, second, third},
+		},
+		{
+			name:     "id descending",
+			req:      &statspb.ListUserStatisticsRequest{OrderBy: statspb.ListUserStatisticsRequest_ID, Descending: true},
+			expected: []*statspb.StatisticEntity{third, second, first},
+		},
+		{
+			name:     "created_at",
+			req:      &statspb.ListUserStatisticsRequest{OrderBy: statspb.ListUserStatisticsRequest_CREATED_AT},
+			expected: []*statspb.StatisticEntity{second, third, first},
+		},
+		{
+			name:     "created_at descending",
+			req:      &statspb.ListUserStatisticsRequest{OrderBy: statspb.ListUserStatisticsRequest_CREATED_AT, Descending: true},
+			expected: []*statspb.StatisticEntity{first, third, second},
+		},
+		{
+			name:     "updated_at",
+			req:      &statspb.ListUserStatisticsRequest{OrderBy: statspb.ListUserStatisticsRequest_UPDATED_AT},
+			expected: []*statspb.StatisticEntity{third, first, second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.UserId = "user-1"
+			list, err := s.ListUserStatistics(context.TODO(), tt.req)
+			if err != nil {
+				t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+			}
+			compareEntityLists(t, tt.expected, list.Entities)
+		})
+	}
+
+	_, err = s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", OrderBy: 100})
+	compareErrors(t, NewErrorInvalidField("order_by", nil, "invalid order_by %d", 100), err)
+}
+
+func conformanceListPages(t *testing.T, s StatsKeeperStorage) {
+	clock := newTestClock(t)
+	var entities []*statspb.StatisticEntity
+	for _, name := range []string{"c", "a", "e", "b", "d"} {
+		entities = append(entities, createTestEntity(t, s, newTestCounterEntity("user-1", name, 1)))
+		// entities with equal timestamps are paged by their ids
+		if name != "e" {
+			clock.advance(time.Second)
+		}
+	}
+	byName := append([]*statspb.StatisticEntity{}, entities...)
+	sort.Slice(byName, func(i, j int) bool {
+		return byName[i].Name < byName[j].Name
+	})
+	byCreatedAtDescending := []*statspb.StatisticEntity{entities[4], entities[3], entities[2], entities[1], entities[0]}
+
+	tests := []struct {
+		name     string
+		req      *statspb.ListUserStatisticsRequest
+		expected []*statspb.StatisticEntity
+	}{
+		{
+			name:     "name",
+			req:      &statspb.ListUserStatisticsRequest{OrderBy: statspb.ListUserStatisticsRequest_NAME, PageSize: 2},
+			expected: byName,
+		},
+		{
+			name:     "created_at descending",
+			req:      &statspb.ListUserStatisticsRequest{OrderBy: statspb.ListUserStatisticsRequest_CREATED_AT, Descending: true, PageSize: 2},
+			expected: byCreatedAtDescending,
+		},
+		{
+			name:     "exact pages",
+			req:      &statspb.ListUserStatisticsRequest{OrderBy: statspb.ListUserStatisticsRequest_NAME, PageSize: 5},
+			expected: byName,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.UserId = "user-1"
+			var got []*statspb.StatisticEntity
+			for pages := 1; ; pages++ {
+				resp, err := s.ListUserStatistics(context.TODO(), tt.req)
+				if err != nil {
+					t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+				}
+				if len(resp.Entities) > int(tt.req.PageSize) {
+					t.Fatalf("page has more entities than page_size: %d", len(resp.Entities))
+				}
+				got = append(got, resp.Entities...)
+				if resp.NextPageToken == "" {
+					break
+				}
+				if pages > len(tt.expected) {
+					t.Fatalf("too many pages")
+				}
+				tt.req.PageToken = resp.NextPageToken
+			}
+			compareEntityLists(t, tt.expected, got)
+		})
+	}
+
+	resp, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", PageSize: 2})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	_, err = s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", PageSize: 2, PageToken: resp.NextPageToken, Descending: true})
+	compareErrors(t, NewErrorInvalidField("page_token", nil, "page_token doesn't match the request"), err)
+	_, err = s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", PageToken: "invalid"})
+	if err == nil || err.(*storageError).Type != storageErrorType_INVALID_ARGUMENT {
+		t.Fatalf("expected INVALID_ARGUMENT error for an invalid page_token, got: %v", err)
+	}
+	_, err = s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", PageSize: -1})
+	compareErrors(t, NewErrorInvalidField("page_size", nil, "page_size cannot be negative"), err)
+	_, err = s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", PageSize: maxListPageSize + 1})
+	compareErrors(t, NewErrorInvalidField("page_size", nil, "page_size cannot be more than %d", maxListPageSize), err)
+}
+
+func conformanceListFilters(t *testing.T, s StatsKeeperStorage) {
+	running := createTestEntity(t, s, newTestCounterEntity("user-1", "running", 1))
+	runs := createTestEntity(t, s, newTestDateEntity("user-1", "runs"))
+	dotted := createTestEntity(t, s, newTestCounterEntity("user-1", "r.n", 1))
+	createTestEntity(t, s, newTestCounterEntity("user-1", "walking", 1))
+	createTestEntity(t, s, newTestCounterEntity("user-2", "running", 1))
+
+	tests := []struct {
+		name     string
+		req      *statspb.ListUserStatisticsRequest
+		expected []*statspb.StatisticEntity
+	}{
+		{
+			name:     "name prefix",
+			req:      &statspb.ListUserStatisticsRequest{NamePrefix: "run"},
+			expected: []*statspb.StatisticEntity{running, runs},
+		},
+		{
+			name:     "name prefix is not a pattern",
+			req:      &statspb.ListUserStatisticsRequest{NamePrefix: "r."},
+			expected: []*statspb.StatisticEntity{dotted},
+		},
+		{
+			name:     "component type",
+			req:      &statspb.ListUserStatisticsRequest{ComponentType: statspb.ComponentType_DATE},
+			expected: []*statspb.StatisticEntity{runs},
+		},
+		{
+			name:     "name prefix and component type",
+			req:      &statspb.ListUserStatisticsRequest{NamePrefix: "run", ComponentType: statspb.ComponentType_COUNTER},
+			expected: []*statspb.StatisticEntity{running},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.UserId = "user-1"
+			resp, err := s.ListUserStatistics(context.TODO(), tt.req)
+			if err != nil {
+				t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+			}
+			sortEntitiesById(tt.expected)
+			compareEntityLists(t, tt.expected, resp.Entities)
+		})
+	}
+
+	_, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", ComponentType: 100})
+	compareErrors(t, NewErrorInvalidField("component_type", nil, "invalid component_type %s", statspb.ComponentType(100)), err)
+}
+
+func conformanceTrash(t *testing.T, s StatsKeeperStorage) {
+	clock := newTestClock(t)
+	kept := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	deleted := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-2", 2))
+	clock.advance(time.Second)
+	if err := s.DeleteStatistic(context.TODO(), deleted.UserId, deleted.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+
+	trash, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", Deleted: true})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	deleted.DeletedAt = clock.timestamp()
+	deleted.UpdatedAt = clock.timestamp()
+	deleted.Version = 2
+	compareEntityLists(t, []*statspb.StatisticEntity{deleted}, trash.Entities)
+
+	// only deleted entities can be restored or purged
+	_, err = s.RestoreStatistic(context.TODO(), kept.UserId, kept.Id)
+	compareErrors(t, NewErrorNotFound(nil, "deleted statistic not found"), err)
+	err = s.PurgeStatistic(context.TODO(), kept.UserId, kept.Id)
+	compareErrors(t, NewErrorNotFound(nil, "deleted statistic not found"), err)
+
+	clock.advance(time.Second)
+	restored, err := s.RestoreStatistic(context.TODO(), deleted.UserId, deleted.Id)
+	if err != nil {
+		t.Fatalf("RestoreStatistic returned unexpected error: %v", err)
+	}
+	deleted.DeletedAt = nil
+	deleted.UpdatedAt = clock.timestamp()
+	deleted.Version = 3
+	compareEntities(t, deleted, restored)
+	got, err := s.GetStatistic(context.TODO(), deleted.UserId, deleted.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	compareEntities(t, restored, got)
+	_, err = s.RestoreStatistic(context.TODO(), deleted.UserId, deleted.Id)
+	compareErrors(t, NewErrorNotFound(nil, "deleted statistic not found"), err)
+
+	if err = s.DeleteStatistic(context.TODO(), deleted.UserId, deleted.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+	if err = s.PurgeStatistic(context.TODO(), deleted.UserId, deleted.Id); err != nil {
+		t.Fatalf("PurgeStatistic returned unexpected error: %v", err)
+	}
+	err = s.PurgeStatistic(context.TODO(), deleted.UserId, deleted.Id)
+	compareErrors(t, NewErrorNotFound(nil, "deleted statistic not found"), err)
+	_, err = s.RestoreStatistic(context.TODO(), deleted.UserId, deleted.Id)
+	compareErrors(t, NewErrorNotFound(nil, "deleted statistic not found"), err)
+	trash, err = s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", Deleted: true})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	compareEntityLists(t, []*statspb.StatisticEntity{}, trash.Entities)
+}
+
+func conformancePurgeDeleted(t *testing.T, s StatsKeeperStorage) {
+	clock := newTestClock(t)
+	kept := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	old := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-2", 2))
+	recent := createTestEntity(t, s, newTestCounterEntity("user-2", "entity-3", 3))
+	if err := s.DeleteStatistic(context.TODO(), old.UserId, old.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+	clock.advance(time.Hour)
+	if err := s.DeleteStatistic(context.TODO(), recent.UserId, recent.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+
+	purged, err := s.PurgeDeletedStatistics(context.TODO(), clock.now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("PurgeDeletedStatistics returned unexpected error: %v", err)
+	}
+	if purged != 1 {
+		t.Fatalf("wrong number of purged entities: expected=1, got=%d", purged)
+	}
+	if err = s.PurgeStatistic(context.TODO(), old.UserId, old.Id); err == nil {
+		t.Fatalf("entity deleted before the given time is not purged")
+	}
+	if _, err = s.RestoreStatistic(context.TODO(), recent.UserId, recent.Id); err != nil {
+		t.Fatalf("RestoreStatistic returned unexpected error: %v", err)
+	}
+	if _, err = s.GetStatistic(context.TODO(), kept.UserId, kept.Id); err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+}
+
+func conformanceVersions(t *testing.T, s StatsKeeperStorage) {
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	if created.Version != 1 {
+		t.Fatalf("wrong version of created entity: expected=1, got=%d", created.Version)
+	}
+
+	values := newTestCounterEntity("", "entity-1-updated", 2)
+	values.Id = created.Id
+	updated, err := s.UpdateStatistic(context.TODO(), "user-1", []string{"name"}, values, 1)
+	if err != nil {
+		t.Fatalf("UpdateStatistic returned unexpected error: %v", err)
+	}
+	if updated.Version != 2 {
+		t.Fatalf("wrong version of updated entity: expected=2, got=%d", updated.Version)
+	}
+
+	// the version has changed since it's read
+	values.Name = "entity-1-conflict"
+	_, err = s.UpdateStatistic(context.TODO(), "user-1", []string{"name"}, values, 1)
+	compareErrors(t, NewErrorConflict(nil, "statistic version is not %d", 1), err)
+	got, err := s.GetStatistic(context.TODO(), created.UserId, created.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	compareEntities(t, updated, got)
+
+	// every modification increments the version
+	incremented, err := s.IncrementCounter(context.TODO(), created.UserId, created.Id, 1)
+	if err != nil {
+		t.Fatalf("IncrementCounter returned unexpected error: %v", err)
+	}
+	if incremented.Version != 3 {
+		t.Fatalf("wrong version of incremented entity: expected=3, got=%d", incremented.Version)
+	}
+
+	err = s.DeleteStatistic(context.TODO(), created.UserId, created.Id, 2)
+	compareErrors(t, NewErrorConflict(nil, "statistic version is not %d", 2), err)
+	if err = s.DeleteStatistic(context.TODO(), created.UserId, created.Id, 3); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+	err = s.DeleteStatistic(context.TODO(), "user-1", "id-1", 1)
+	compareErrors(t, NewErrorNotFound(nil, "statistic not found"), err)
+}
+
+func conformanceConcurrentConditionalUpdates(t *testing.T, s StatsKeeperStorage) {
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+
+	const n = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values := newTestCounterEntity("", fmt.Sprintf("entity-%d", i), 0)
+			values.Id = created.Id
+			_, err := s.UpdateStatistic(context.TODO(), "user-1", []string{"name"}, values, created.Version)
+			if err != nil {
+				if se, ok := err.(*storageError); !ok || se.Type != storageErrorType_CONFLICT {
+					t.Errorf("UpdateStatistic returned unexpected error: %v", err)
+				}
+				return
+			}
+			mu.Lock()
+			succeeded++
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Fatalf("wrong number of successful updates: expected=1, got=%d", succeeded)
+	}
+}
+
+func conformanceHistory(t *testing.T, s StatsKeeperStorage) {
+	clock := newTestClock(t)
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	clock.advance(time.Second)
+	values := newTestCounterEntity("", "entity-1-updated", 0)
+	values.Id = created.Id
+	updated, err := s.UpdateStatistic(context.TODO(), "user-1", []string{"name"}, values, 0)
+	if err != nil {
+		t.Fatalf("UpdateStatistic returned unexpected error: %v", err)
+	}
+	clock.advance(time.Second)
+	incremented, err := s.IncrementCounter(context.TODO(), created.UserId, created.Id, 2)
+	if err != nil {
+		t.Fatalf("IncrementCounter returned unexpected error: %v", err)
+	}
+	clock.advance(time.Second)
+	if err = s.DeleteStatistic(context.TODO(), created.UserId, created.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+	deleted := proto.Clone(incremented).(*statspb.StatisticEntity)
+	deleted.DeletedAt = clock.timestamp()
+	deleted.UpdatedAt = clock.timestamp()
+	deleted.Version = 4
+	clock.advance(time.Second)
+	restored, err := s.RestoreStatistic(context.TODO(), created.UserId, created.Id)
+	if err != nil {
+		t.Fatalf("RestoreStatistic returned unexpected error: %v", err)
+	}
+	// failed changes are not recorded
+	_, err = s.IncrementCounter(context.TODO(), created.UserId, created.Id, -10)
+	compareErrors(t, NewErrorInvalidArgument(nil, "count cannot go below zero"), err)
+
+	expected := []*statspb.HistoryEvent{
+		{
+			Type: statspb.HistoryEvent_RESTORE, OldValue: deleted, NewValue: restored,
+		},
+		{
+			Type: statspb.HistoryEvent_DELETE, OldValue: incremented, NewValue: deleted,
+		},
+		{
+			Type: statspb.HistoryEvent_UPDATE, Paths: []string{"counter"}, OldValue: updated, NewValue: incremented,
+		},
+		{
+			Type: statspb.HistoryEvent_UPDATE, Paths: []string{"name"}, OldValue: created, NewValue: updated,
+		},
+		{
+			Type: statspb.HistoryEvent_CREATE, Paths: []string{"name", "user_id", "counter"}, NewValue: created,
+		},
+	}
+	for _, he := range expected {
+		he.Id = fmt.Sprintf("%s-%d", created.Id, he.NewValue.Version)
+		he.EntityId = created.Id
+		he.UserId = "user-1"
+		he.Timestamp = he.NewValue.UpdatedAt
+		he.Version = he.NewValue.Version
+	}
+	resp, err := s.ListHistory(context.TODO(), created.UserId, &statspb.ListHistoryRequest{EntityId: created.Id})
+	if err != nil {
+		t.Fatalf("ListHistory returned unexpected error: %v", err)
+	}
+	compareHistoryEvents(t, expected, resp.Events)
+	if resp.NextPageToken != "" {
+		t.Fatalf("unexpected next page token: %q", resp.NextPageToken)
+	}
+
+	// the history is kept after the entity is purged
+	if err = s.DeleteStatistic(context.TODO(), created.UserId, created.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+	if err = s.PurgeStatistic(context.TODO(), created.UserId, created.Id); err != nil {
+		t.Fatalf("PurgeStatistic returned unexpected error: %v", err)
+	}
+	resp, err = s.ListHistory(context.TODO(), created.UserId, &statspb.ListHistoryRequest{EntityId: created.Id})
+	if err != nil {
+		t.Fatalf("ListHistory returned unexpected error: %v", err)
+	}
+	if len(resp.Events) != len(expected)+1 {
+		t.Fatalf("wrong number of events after purge: expected=%d, got=%d", len(expected)+1, len(resp.Events))
+	}
+
+	resp, err = s.ListHistory(context.TODO(), "user-1", &statspb.ListHistoryRequest{EntityId: "id-1"})
+	if err != nil {
+		t.Fatalf("ListHistory returned unexpected error: %v", err)
+	}
+	compareHistoryEvents(t, []*statspb.HistoryEvent{}, resp.Events)
+}
+
+func conformanceHistoryPages(t *testing.T, s StatsKeeperStorage) {
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 0))
+	other := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-2", 0))
+	for i := 0; i < 4; i++ {
+		if _, err := s.IncrementCounter(context.TODO(), created.UserId, created.Id, 1); err != nil {
+			t.Fatalf("IncrementCounter returned unexpected error: %v", err)
+		}
+	}
+
+	var versions []int64
+	req := &statspb.ListHistoryRequest{EntityId: created.Id, PageSize: 2}
+	for pages := 1; ; pages++ {
+		resp, err := s.ListHistory(context.TODO(), "user-1", req)
+		if err != nil {
+			t.Fatalf("ListHistory returned unexpected error: %v", err)
+		}
+		for _, he := range resp.Events {
+			versions = append(versions, he.Version)
+		}
+		if resp.NextPageToken == "" {
+			if pages != 3 {
+				t.Fatalf("wrong number of pages: expected=3, got=%d", pages)
+			}
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if fmt.Sprint(versions) != "[5 4 3 2 1]" {
+		t.Fatalf("wrong versions: expected=[5 4 3 2 1], got=%v", versions)
+	}
+
+	// a token cannot be used for another entity
+	_, err := s.ListHistory(context.TODO(), other.UserId, &statspb.ListHistoryRequest{EntityId: other.Id, PageSize: 2, PageToken: req.PageToken})
+	compareErrors(t, NewErrorInvalidField("page_token", nil, "page_token doesn't match the request"), err)
+	_, err = s.ListHistory(context.TODO(), created.UserId, &statspb.ListHistoryRequest{EntityId: created.Id, PageToken: "invalid"})
+	if err == nil || err.(*storageError).Type != storageErrorType_INVALID_ARGUMENT {
+		t.Fatalf("expected INVALID_ARGUMENT error for an invalid page_token, got: %v", err)
+	}
+	_, err = s.ListHistory(context.TODO(), created.UserId, &statspb.ListHistoryRequest{EntityId: created.Id, PageSize: -1})
+	compareErrors(t, NewErrorInvalidField("page_size", nil, "page_size cannot be negative"), err)
+}
+
+func conformanceRevert(t *testing.T, s StatsKeeperStorage) {
+	clock := newTestClock(t)
+	created := createTestEntity(t, s, newTestDateEntity("user-1", "entity-1", ts(1)))
+	clock.advance(time.Minute)
+	values := newTestDateEntity("", "entity-1-renamed")
+	values.Id = created.Id
+	renamed, err := s.UpdateStatistic(context.TODO(), "user-1", []string{"name"}, values, 0)
+	if err != nil {
+		t.Fatalf("UpdateStatistic returned unexpected error: %v", err)
+	}
+	clock.advance(time.Minute)
+	if _, err = s.AppendTimestamps(context.TODO(), created.UserId, created.Id, []*timestamppb.Timestamp{ts(2)}, false); err != nil {
+		t.Fatalf("AppendTimestamps returned unexpected error: %v", err)
+	}
+
+	// revert to the first version
+	clock.advance(time.Minute)
+	reverted, err := s.RevertStatistic(context.TODO(), created.UserId, &statspb.RevertStatisticRequest{EntityId: created.Id, Version: 1})
+	if err != nil {
+		t.Fatalf("RevertStatistic returned unexpected error: %v", err)
+	}
+	expected := proto.Clone(created).(*statspb.StatisticEntity)
+	expected.UpdatedAt = clock.timestamp()
+	expected.Version = 4
+	compareEntities(t, expected, reverted)
+	got, err := s.GetStatistic(context.TODO(), created.UserId, created.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	compareEntities(t, reverted, got)
+
+	history, err := s.ListHistory(context.TODO(), created.UserId, &statspb.ListHistoryRequest{EntityId: created.Id, PageSize: 1})
+	if err != nil {
+		t.Fatalf("ListHistory returned unexpected error: %v", err)
+	}
+	if len(history.Events) != 1 || history.Events[0].Type != statspb.HistoryEvent_REVERT ||
+		fmt.Sprint(history.Events[0].Paths) != "[name date]" || !proto.Equal(history.Events[0].NewValue, reverted) {
+		t.Fatalf("revert is not recorded in the history: %v", history.Events)
+	}
+
+	// revert to the version at a time, which is the renamed one with the first timestamps
+	clock.advance(time.Minute)
+	reverted, err = s.RevertStatistic(context.TODO(), "user-1", &statspb.RevertStatisticRequest{
+		EntityId:  created.Id,
+		Timestamp: timestamppb.New(renamed.UpdatedAt.AsTime().Add(30 * time.Second)),
+	})
+	if err != nil {
+		t.Fatalf("RevertStatistic returned unexpected error: %v", err)
+	}
+	expected = proto.Clone(renamed).(*statspb.StatisticEntity)
+	expected.UpdatedAt = clock.timestamp()
+	expected.Version = 5
+	compareEntities(t, expected, reverted)
+
+	// the entity already has the name and the component of the requested version
+	_, err = s.RevertStatistic(context.TODO(), created.UserId, &statspb.RevertStatisticRequest{EntityId: created.Id, Version: 2})
+	compareErrors(t, NewErrorNoUpdate(nil, "no update possible"), err)
+	_, err = s.RevertStatistic(context.TODO(), created.UserId, &statspb.RevertStatisticRequest{EntityId: created.Id, Version: 1, ExpectedVersion: 4})
+	compareErrors(t, NewErrorConflict(nil, "statistic version is not %d", 4), err)
+	_, err = s.RevertStatistic(context.TODO(), created.UserId, &statspb.RevertStatisticRequest{EntityId: created.Id, Version: 10})
+	compareErrors(t, NewErrorNotFound(nil, "revision not found"), err)
+	_, err = s.RevertStatistic(context.TODO(), created.UserId, &statspb.RevertStatisticRequest{EntityId: created.Id, Timestamp: ts(0)})
+	compareErrors(t, NewErrorNotFound(nil, "revision not found"), err)
+	_, err = s.RevertStatistic(context.TODO(), created.UserId, &statspb.RevertStatisticRequest{EntityId: created.Id, Version: 1, Timestamp: ts(0)})
+	compareErrors(t, NewErrorInvalidArgument(nil, "exactly one of version and timestamp must be set"), err)
+	_, err = s.RevertStatistic(context.TODO(), created.UserId, &statspb.RevertStatisticRequest{EntityId: created.Id})
+	compareErrors(t, NewErrorInvalidArgument(nil, "exactly one of version and timestamp must be set"), err)
+	_, err = s.RevertStatistic(context.TODO(), "user-1", &statspb.RevertStatisticRequest{EntityId: "id-1", Version: 1})
+	compareErrors(t, NewErrorNotFound(nil, "statistic not found"), err)
+}
+
+func conformanceOwnership(t *testing.T, s StatsKeeperStorage) {
+	counter := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	date := createTestEntity(t, s, newTestDateEntity("user-1", "entity-2", ts(1)))
+	notFound := NewErrorNotFound(nil, "statistic not found")
+
+	// entities of another user are reported as if they don't exist
+	_, err := s.GetStatistic(context.TODO(), "user-2", counter.Id)
+	compareErrors(t, notFound, err)
+	values := newTestCounterEntity("", "entity-1-updated", 2)
+	values.Id = counter.Id
+	_, err = s.UpdateStatistic(context.TODO(), "user-2", []string{"name", "counter"}, values, 0)
+	compareErrors(t, notFound, err)
+	_, err = s.IncrementCounter(context.TODO(), "user-2", counter.Id, 1)
+	compareErrors(t, notFound, err)
+	_, err = s.AppendTimestamps(context.TODO(), "user-2", date.Id, []*timestamppb.Timestamp{ts(2)}, false)
+	compareErrors(t, notFound, err)
+	_, err = s.RemoveTimestamps(context.TODO(), "user-2", date.Id, []*timestamppb.Timestamp{ts(1)})
+	compareErrors(t, notFound, err)
+	_, err = s.RemoveTimestampRange(context.TODO(), "user-2", date.Id, ts(0), ts(2))
+	compareErrors(t, notFound, err)
+	_, err = s.RevertStatistic(context.TODO(), "user-2", &statspb.RevertStatisticRequest{EntityId: counter.Id, Version: 1})
+	compareErrors(t, notFound, err)
+	err = s.DeleteStatistic(context.TODO(), "user-2", counter.Id, 0)
+	compareErrors(t, notFound, err)
+	err = s.DeleteStatistic(context.TODO(), "user-2", counter.Id, 1)
+	compareErrors(t, notFound, err)
+	history, err := s.ListHistory(context.TODO(), "user-2", &statspb.ListHistoryRequest{EntityId: counter.Id})
+	if err != nil {
+		t.Fatalf("ListHistory returned unexpected error: %v", err)
+	}
+	compareHistoryEvents(t, []*statspb.HistoryEvent{}, history.Events)
+
+	if err = s.DeleteStatistic(context.TODO(), "user-1", counter.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+	_, err = s.RestoreStatistic(context.TODO(), "user-2", counter.Id)
+	compareErrors(t, NewErrorNotFound(nil, "deleted statistic not found"), err)
+	err = s.PurgeStatistic(context.TODO(), "user-2", counter.Id)
+	compareErrors(t, NewErrorNotFound(nil, "deleted statistic not found"), err)
+
+	// none of the above has changed the entities
+	restored, err := s.RestoreStatistic(context.TODO(), "user-1", counter.Id)
+	if err != nil {
+		t.Fatalf("RestoreStatistic returned unexpected error: %v", err)
+	}
+	if restored.Name != counter.Name || restored.GetCounter().Count != 1 || restored.Version != 3 {
+		t.Fatalf("entity is modified by another user: %v", restored)
+	}
+	got, err := s.GetStatistic(context.TODO(), "user-1", date.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	compareEntities(t, date, got)
+}
+
+func conformanceUsers(t *testing.T, s StatsKeeperStorage) {
+	created, err := s.CreateUser(context.TODO(), "Alice", []byte("hash-1"))
+	if err != nil {
+		t.Fatalf("CreateUser returned unexpected error: %v", err)
+	}
+	if created.Id == "" || created.Username != "alice" || created.CreatedAt == nil {
+		t.Fatalf("user is not initialized: %v", created)
+	}
+	_, err = s.CreateUser(context.TODO(), "ALICE", []byte("hash-2"))
+	compareErrors(t, NewErrorConflict(nil, "username is taken"), err)
+	for _, username := range []string{"", "al", "-alice", "alice smith"} {
+		_, err = s.CreateUser(context.TODO(), username, []byte("hash-2"))
+		if se, ok := err.(*storageError); !ok || se.Type != storageErrorType_INVALID_ARGUMENT {
+			t.Fatalf("expected INVALID_ARGUMENT error for username %q, got: %v", username, err)
+		}
+	}
+
+	got, err := s.GetUserCredentials(context.TODO(), "aLiCe")
+	if err != nil {
+		t.Fatalf("GetUserCredentials returned unexpected error: %v", err)
+	}
+	if !proto.Equal(created, got.User) || string(got.PasswordHash) != "hash-1" || !got.LockedUntil.IsZero() {
+		t.Fatalf("wrong credentials: expected user=%v, got=%+v", created, got)
+	}
+	_, err = s.GetUserCredentials(context.TODO(), "bob")
+	compareErrors(t, NewErrorNotFound(nil, "user not found"), err)
+}
+
+func conformanceLoginFailures(t *testing.T, s StatsKeeperStorage) {
+	clock := newTestClock(t)
+	user, err := s.CreateUser(context.TODO(), "alice", []byte("hash"))
+	if err != nil {
+		t.Fatalf("CreateUser returned unexpected error: %v", err)
+	}
+	fail := func() {
+		t.Helper()
+		if err := s.RecordLoginFailure(context.TODO(), user.Id, 3, time.Hour); err != nil {
+			t.Fatalf("RecordLoginFailure returned unexpected error: %v", err)
+		}
+	}
+	lockedUntil := func() time.Time {
+		t.Helper()
+		got, err := s.GetUserCredentials(context.TODO(), user.Username)
+		if err != nil {
+			t.Fatalf("GetUserCredentials returned unexpected error: %v", err)
+		}
+		return got.LockedUntil
+	}
+
+	// a success resets the failures before the user is locked
+	fail()
+	fail()
+	if err = s.RecordLoginSuccess(context.TODO(), user.Id); err != nil {
+		t.Fatalf("RecordLoginSuccess returned unexpected error: %v", err)
+	}
+	fail()
+	fail()
+	if !lockedUntil().IsZero() {
+		t.Fatalf("user is locked before the maximum number of failures")
+	}
+	fail()
+	if expected, got := clock.now.Add(time.Hour), lockedUntil(); !got.Equal(expected) {
+		t.Fatalf("wrong lock time: expected=%v, got=%v", expected, got)
+	}
+
+	err = s.RecordLoginFailure(context.TODO(), "unknown", 3, time.Hour)
+	compareErrors(t, NewErrorNotFound(nil, "user not found"), err)
+	err = s.RecordLoginSuccess(context.TODO(), "unknown")
+	compareErrors(t, NewErrorNotFound(nil, "user not found"), err)
+}
+
+func conformanceSessions(t *testing.T, s StatsKeeperStorage) {
+	clock := newTestClock(t)
+	if err := s.CreateSession(context.TODO(), "user-1", "token-1", clock.now.Add(time.Hour)); err != nil {
+		t.Fatalf("CreateSession returned unexpected error: %v", err)
+	}
+	if err := s.CreateSession(context.TODO(), "user-1", "token-2", clock.now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("CreateSession returned unexpected error: %v", err)
+	}
+	userId, err := s.GetSession(context.TODO(), "token-1")
+	if err != nil {
+		t.Fatalf("GetSession returned unexpected error: %v", err)
+	}
+	if userId != "user-1" {
+		t.Fatalf("wrong user of session: expected=user-1, got=%s", userId)
+	}
+
+	clock.advance(time.Hour)
+	_, err = s.GetSession(context.TODO(), "token-1")
+	compareErrors(t, NewErrorNotFound(nil, "session not found"), err)
+	if _, err = s.GetSession(context.TODO(), "token-2"); err != nil {
+		t.Fatalf("GetSession returned unexpected error: %v", err)
+	}
+	// creating a session removes the expired ones
+	if err = s.CreateSession(context.TODO(), "user-1", "token-3", clock.now.Add(time.Hour)); err != nil {
+		t.Fatalf("CreateSession returned unexpected error: %v", err)
+	}
+	err = s.DeleteSession(context.TODO(), "token-1")
+	compareErrors(t, NewErrorNotFound(nil, "session not found"), err)
+
+	if err = s.DeleteSession(context.TODO(), "token-2"); err != nil {
+		t.Fatalf("DeleteSession returned unexpected error: %v", err)
+	}
+	_, err = s.GetSession(context.TODO(), "token-2")
+	compareErrors(t, NewErrorNotFound(nil, "session not found"), err)
+	if _, err = s.GetSession(context.TODO(), "token-3"); err != nil {
+		t.Fatalf("GetSession returned unexpected error: %v", err)
+	}
+}
+
+func conformanceApiKeys(t *testing.T, s StatsKeeperStorage) {
+	clock := newTestClock(t)
+	in := &statspb.ApiKey{
+		Id:        "overridden-id",
+		UserId:    "user-1",
+		Name:      "backup script",
+		Prefix:    "sk_abcd",
+		Scope:     statspb.ApiKey_READ_ONLY,
+		EntityIds: []string{"entity-1", "entity-2"},
+	}
+	readOnly, err := s.CreateApiKey(context.TODO(), in, "hash-1")
+	if err != nil {
+		t.Fatalf("CreateApiKey returned unexpected error: %v", err)
+	}
+	if readOnly.Id == "" || readOnly.Id == in.Id || !proto.Equal(readOnly.CreatedAt, clock.timestamp()) {
+		t.Fatalf("API key is not initialized: %v", readOnly)
+	}
+	in.Id, in.CreatedAt = readOnly.Id, readOnly.CreatedAt
+	if !proto.Equal(in, readOnly) {
+		t.Fatalf("wrong API key: expected=%v, got=%v", in, readOnly)
+	}
+	clock.advance(time.Second)
+	readWrite, err := s.CreateApiKey(context.TODO(), &statspb.ApiKey{UserId: "user-1", Scope: statspb.ApiKey_READ_WRITE}, "hash-2")
+	if err != nil {
+		t.Fatalf("CreateApiKey returned unexpected error: %v", err)
+	}
+	other, err := s.CreateApiKey(context.TODO(), &statspb.ApiKey{UserId: "user-2", Scope: statspb.ApiKey_READ_WRITE}, "hash-3")
+	if err != nil {
+		t.Fatalf("CreateApiKey returned unexpected error: %v", err)
+	}
+	for _, key := range []*statspb.ApiKey{
+		{Scope: statspb.ApiKey_READ_WRITE},
+		{UserId: "user-1"},
+		{UserId: "user-1", Scope: statspb.ApiKey_READ_WRITE, EntityIds: []string{""}},
+	} {
+		_, err = s.CreateApiKey(context.TODO(), key, "hash-4")
+		if se, ok := err.(*storageError); !ok || se.Type != storageErrorType_INVALID_ARGUMENT {
+			t.Fatalf("expected INVALID_ARGUMENT error for API key %v, got: %v", key, err)
+		}
+	}
+
+	got, err := s.GetApiKey(context.TODO(), "hash-1")
+	if err != nil {
+		t.Fatalf("GetApiKey returned unexpected error: %v", err)
+	}
+	if !proto.Equal(readOnly, got) {
+		t.Fatalf("wrong API key: expected=%v, got=%v", readOnly, got)
+	}
+	_, err = s.GetApiKey(context.TODO(), "hash-4")
+	compareErrors(t, NewErrorNotFound(nil, "API key not found"), err)
+	list, err := s.ListApiKeys(context.TODO(), "user-1")
+	if err != nil {
+		t.Fatalf("ListApiKeys returned unexpected error: %v", err)
+	}
+	if len(list) != 2 || !proto.Equal(readOnly, list[0]) || !proto.Equal(readWrite, list[1]) {
+		t.Fatalf("wrong API keys: expected=%v, got=%v", []*statspb.ApiKey{readOnly, readWrite}, list)
+	}
+
+	err = s.RevokeApiKey(context.TODO(), "user-1", other.Id)
+	compareErrors(t, NewErrorNotFound(nil, "API key not found"), err)
+	if err = s.RevokeApiKey(context.TODO(), "user-1", readOnly.Id); err != nil {
+		t.Fatalf("RevokeApiKey returned unexpected error: %v", err)
+	}
+	_, err = s.GetApiKey(context.TODO(), "hash-1")
+	compareErrors(t, NewErrorNotFound(nil, "API key not found"), err)
+	if _, err = s.GetApiKey(context.TODO(), "hash-3"); err != nil {
+		t.Fatalf("GetApiKey returned unexpected error: %v", err)
+	}
+	list, err = s.ListApiKeys(context.TODO(), "user-1")
+	if err != nil {
+		t.Fatalf("ListApiKeys returned unexpected error: %v", err)
+	}
+	if len(list) != 1 || !proto.Equal(readWrite, list[0]) {
+		t.Fatalf("wrong API keys: expected=%v, got=%v", []*statspb.ApiKey{readWrite}, list)
+	}
+}
+
+func newTestCounterEntity(userId, name string, count uint32) *statspb.StatisticEntity {
+	return &statspb.StatisticEntity{
+		Name:   name,
+		UserId: userId,
+		Component: &statspb.StatisticEntity_Counter{
+			Counter: &statspb.ComponentCounter{Count: count},
+		},
+	}
+}
+
+func newTestDateEntity(userId, name string, timestamps ...*timestamppb.Timestamp) *statspb.StatisticEntity {
+	if timestamps == nil {
+		timestamps = []*timestamppb.Timestamp{}
+	}
+	return &statspb.StatisticEntity{
+		Name:   name,
+		UserId: userId,
+		Component: &statspb.StatisticEntity_Date{
+			Date: &statspb.ComponentDate{Timestamps: timestamps},
+		},
+	}
+}
+
+func newTestMultiValueEntity(userId, name string, fields []string, samples ...*statspb.MultiValueSample) *statspb.StatisticEntity {
+	return &statspb.StatisticEntity{
+		Name:   name,
+		UserId: userId,
+		Component: &statspb.StatisticEntity_MultiValue{
+			MultiValue: &statspb.ComponentMultiValue{Fields: fields, Samples: samples},
+		},
+	}
+}
+
+// ts returns a timestamp at the given seconds.
+func ts(seconds int64) *timestamppb.Timestamp {
+	return &timestamppb.Timestamp{Seconds: seconds}
+}
+
+func createTestEntity(t *testing.T, s StatsKeeperStorage, entity *statspb.StatisticEntity) *statspb.StatisticEntity {
+	created, err := s.CreateStatistic(context.TODO(), entity)
+	if err != nil {
+		t.Fatalf("error creating test entity: %v", err)
+	}
+	return created
+}
+
+// copyManagedFields copies the fields that are managed by the storage, such as the timestamps, from src to
+// dst, so that entities can be compared regardless of them.
+func copyManagedFields(dst, src *statspb.StatisticEntity) {
+	dst.CreatedAt = src.CreatedAt
+	dst.UpdatedAt = src.UpdatedAt
+	dst.DeletedAt = src.DeletedAt
+	dst.Version = src.Version
+}
+
+// testClock replaces the clock of the storage for the duration of a test. It only moves when it's advanced.
+type testClock struct {
+	now time.Time
+}
+
+func newTestClock(t *testing.T) *testClock {
+	c := &testClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	prev := timeNow
+	timeNow = func() time.Time { return c.now }
+	t.Cleanup(func() {
+		timeNow = prev
+	})
+	return c
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func (c *testClock) timestamp() *timestamppb.Timestamp {
+	return timestamppb.New(c.now)
+}
+
+func sortEntitiesById(entities []*statspb.StatisticEntity) {
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].Id < entities[j].Id
+	})
+}
+
+func compareEntities(t *testing.T, expected, got *statspb.StatisticEntity) {
+	t.Helper()
+	if !proto.Equal(expected, got) {
+		t.Fatalf("wrong entity: expected=%v, got=%v", expected, got)
+	}
+}
+
+func compareEntityLists(t *testing.T, expected, got []*statspb.StatisticEntity) {
+	t.Helper()
+	if len(expected) != len(got) {
+		t.Fatalf("wrong number of entities: expected=%d, got=%d", len(expected), len(got))
+	}
+	for i := range expected {
+		compareEntities(t, expected[i], got[i])
+	}
+}
+
+func compareTimestamp(t *testing.T, expected, got *timestamppb.Timestamp) {
+	t.Helper()
+	if !proto.Equal(expected, got) {
+		t.Fatalf("wrong timestamp: expected=%v, got=%v", expected, got)
+	}
+}
+
+func compareTimestampLists(t *testing.T, expected, got []*timestamppb.Timestamp) {
+	t.Helper()
+	if !proto.Equal(&statspb.ComponentDate{Timestamps: expected}, &statspb.ComponentDate{Timestamps: got}) {
+		t.Fatalf("wrong timestamps: expected=%v, got=%v", expected, got)
+	}
+}
+
+func compareHistoryEvents(t *testing.T, expected, got []*statspb.HistoryEvent) {
+	t.Helper()
+	if len(expected) != len(got) {
+		t.Fatalf("wrong number of events: expected=%d, got=%d", len(expected), len(got))
+	}
+	for i := range expected {
+		if !proto.Equal(expected[i], got[i]) {
+			t.Fatalf("wrong event %d: expected=%v, got=%v", i, expected[i], got[i])
+		}
+	}
+}
@@ -3,28 +3,49 @@ package storage
 import (
 	"context"
 	"errors"
+	"math"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/umutozd/stats-keeper/protos/statspb"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func (s *storage) CreateStatistic(ctx context.Context, entity *statspb.StatisticEntity) (*statspb.StatisticEntity, error) {
+	if err := validateComponent(entity); err != nil {
+		return nil, err
+	}
 	se := &statisticEntity{}
 	se.fromPB(entity)
 	se.Id = primitive.NewObjectID().Hex()
+	se.CreatedAt = now()
+	se.UpdatedAt = se.CreatedAt
+	se.DeletedAt = time.Time{}
+	se.Version = 1
 
 	if _, err := s.statistics().InsertOne(ctx, se); err != nil {
 		return nil, NewErrorInternal(err, "error creating statistic")
 	}
+	s.recordHistory(ctx, newHistoryEvent(statspb.HistoryEvent_CREATE, createdPaths(se), nil, se))
+	return se.toPB(), nil
+}
+
+func (s *storage) GetStatistic(ctx context.Context, userId, entityId string) (*statspb.StatisticEntity, error) {
+	se, err := s.getStatistic(ctx, userId, entityId)
+	if err != nil {
+		return nil, err
+	}
 	return se.toPB(), nil
 }
 
-func (s *storage) GetStatistic(ctx context.Context, entityId string) (*statspb.StatisticEntity, error) {
+// getStatistic is the same as GetStatistic, except that it returns the internal representation of the entity.
+func (s *storage) getStatistic(ctx context.Context, userId, entityId string) (*statisticEntity, error) {
 	se := &statisticEntity{}
-	filter := bson.M{"_id": entityId}
+	filter := bson.M{"_id": entityId, "user_id": userId}
 	if err := s.statistics().FindOne(ctx, filter).Decode(se); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, NewErrorNotFound(nil, "statistic not found")
@@ -34,89 +55,459 @@ func (s *storage) GetStatistic(ctx context.Context, entityId string) (*statspb.S
 	if se.Deleted {
 		return nil, NewErrorNotFound(nil, "statistic not found")
 	}
-	return se.toPB(), nil
+	return se, nil
 }
 
-func (s *storage) UpdateStatistic(ctx context.Context, fields []string, values *statspb.StatisticEntity) (*statspb.StatisticEntity, error) {
-	se := &statisticEntity{}
-	filter := bson.M{"_id": values.Id}
-	set := bson.M{}
+func (s *storage) UpdateStatistic(ctx context.Context, userId string, fields []string, values *statspb.StatisticEntity, expectedVersion int64) (*statspb.StatisticEntity, error) {
+	return s.modifyStatistic(ctx, statspb.HistoryEvent_UPDATE, userId, values.Id, expectedVersion, func(se *statisticEntity) ([]string, error) {
+		return se.update(fields, values)
+	})
+}
+
+func (s *storage) RevertStatistic(ctx context.Context, userId string, req *statspb.RevertStatisticRequest) (*statspb.StatisticEntity, error) {
+	if err := validateRevertRequest(req); err != nil {
+		return nil, err
+	}
+
+	return s.modifyStatistic(ctx, statspb.HistoryEvent_REVERT, userId, req.EntityId, req.ExpectedVersion, func(se *statisticEntity) ([]string, error) {
+		filter, opts := revisionFilter(userId, req)
+		he := &historyEvent{}
+		if err := s.history().FindOne(ctx, filter, opts).Decode(he); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, NewErrorNotFound(nil, "revision not found")
+			}
+			return nil, NewErrorInternal(err, "error getting revision from database")
+		}
+		return se.revert(he.New)
+	})
+}
+
+// modifyStatistic calls modify with the entity of userId specified by entityId and stores the fields whose
+// paths modify returns, along with an updated UpdatedAt and Version, unless modify returns an error. The change
+// is recorded in the history with the given type. It returns the modified entity. If expectedVersion is not
+// zero and the entity has another version, it returns a CONFLICT error.
+func (s *storage) modifyStatistic(ctx context.Context, typ statspb.HistoryEvent_Type, userId, entityId string, expectedVersion int64, modify func(se *statisticEntity) ([]string, error)) (*statspb.StatisticEntity, error) {
+	se, err := s.getStatistic(ctx, userId, entityId)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != 0 && se.Version != expectedVersion {
+		return nil, NewErrorConflict(nil, "statistic version is not %d", expectedVersion)
+	}
+	// the update is applied only if the entity is not modified since it's read
+	filter := bson.M{"_id": entityId, "user_id": userId, "deleted": false, "version": versionFilter(se.Version)}
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
-	entity, err := s.GetStatistic(ctx, values.Id)
+	old := se.clone()
+	updated, err := modify(se)
 	if err != nil {
 		return nil, err
 	}
-	compType := entity.GetComponentType()
-
-	for _, f := range fields {
-		switch f {
-		case "id", "user_id":
-			return nil, NewErrorInvalidArgument(nil, "fields 'id', 'user_id' cannot be modified")
-		case "name":
-			set[f] = values.Name
-		case "counter":
-			if compType != statspb.ComponentType_COUNTER {
-				return nil, NewErrorInvalidArgument(nil, "component cannot be changed from %s to %s", compType, statspb.ComponentType_COUNTER)
-			}
-			if comp := values.GetCounter(); comp != nil {
-				set[f] = comp
-			}
-		case "date":
-			if compType != statspb.ComponentType_DATE {
-				return nil, NewErrorInvalidArgument(nil, "component cannot be changed from %s to %s", compType, statspb.ComponentType_DATE)
+
+	// set the updated fields as they're encoded in the updated entity
+	doc, err := bson.Marshal(se)
+	if err != nil {
+		return nil, NewErrorInternal(err, "error encoding statistic")
+	}
+	set := bson.M{"updated_at": now()}
+	for _, f := range updated {
+		set[f] = bson.Raw(doc).Lookup(f)
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	se = &statisticEntity{}
+	if err := s.statistics().FindOneAndUpdate(ctx, filter, update, opts).Decode(se); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, NewErrorInternal(err, "error updating statistic")
+		}
+		if _, err = s.getStatistic(ctx, userId, entityId); err != nil {
+			return nil, err
+		}
+		return nil, NewErrorConflict(nil, "statistic was modified concurrently, try again")
+	}
+	// the version filter guarantees that old is the entity the update is applied to
+	s.recordHistory(ctx, newHistoryEvent(typ, updated, old, se))
+	return se.toPB(), nil
+}
+
+// versionFilter returns the filter that matches the given version of an entity. Documents that are created
+// before versions are introduced don't have a version, which is zero.
+func versionFilter(version int64) any {
+	if version == 0 {
+		return nil
+	}
+	return version
+}
+
+func (s *storage) DeleteStatistic(ctx context.Context, userId, entityId string, expectedVersion int64) error {
+	filter := bson.M{"_id": entityId, "user_id": userId, "deleted": false}
+	if expectedVersion != 0 {
+		filter["version"] = expectedVersion
+	}
+	deletedAt := now()
+	update := bson.M{
+		"$set": bson.M{
+			"deleted":    true,
+			"deleted_at": deletedAt,
+			"updated_at": deletedAt,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	old := &statisticEntity{}
+	if err := s.statistics().FindOneAndUpdate(ctx, filter, update, opts).Decode(old); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return NewErrorInternal(err, "error deleting statistic")
+		}
+		if expectedVersion != 0 {
+			// find out why the entity didn't match
+			err = s.statistics().FindOne(ctx, bson.M{"_id": entityId, "user_id": userId, "deleted": false}).Err()
+			if err == nil {
+				return NewErrorConflict(nil, "statistic version is not %d", expectedVersion)
 			}
-			if comp := values.GetDate(); comp != nil {
-				set[f] = comp
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				return NewErrorInternal(err, "error deleting statistic")
 			}
 		}
+		return NewErrorNotFound(nil, "statistic not found")
 	}
-	if len(set) == 0 {
-		// no need to make ineffectual update, short-circuit here
+
+	se := old.clone()
+	se.Deleted = true
+	se.DeletedAt = deletedAt
+	se.UpdatedAt = deletedAt
+	se.Version++
+	s.recordHistory(ctx, newHistoryEvent(statspb.HistoryEvent_DELETE, nil, old, se))
+	return nil
+}
+
+func (s *storage) IncrementCounter(ctx context.Context, userId, entityId string, delta int64) (*statspb.StatisticEntity, error) {
+	if delta == 0 {
 		return nil, NewErrorNoUpdate(nil, "no update possible")
 	}
-	update := bson.M{"$set": set}
-	if err := s.statistics().FindOneAndUpdate(ctx, filter, update, opts).Decode(&se); err != nil {
-		return nil, NewErrorInternal(err, "error updating statistic")
+
+	// the count is checked in the filter so that it's never out of bounds, even with concurrent updates
+	countFilter := bson.M{"$gte": -delta}
+	if delta > 0 {
+		countFilter = bson.M{"$lte": math.MaxUint32 - delta}
+	}
+	filter := bson.M{"_id": entityId, "user_id": userId, "deleted": false, "counter.count": countFilter}
+	updatedAt := now()
+	update := bson.M{
+		"$inc": bson.M{"counter.count": delta, "version": 1},
+		"$set": bson.M{"updated_at": updatedAt},
+	}
+	// the entity before the update is recorded in the history, the updated one is derived from it
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	old := &statisticEntity{}
+	err := mongo.ErrNoDocuments
+	if delta >= -math.MaxUint32 && delta <= math.MaxUint32 {
+		// otherwise it cannot possibly fit, and negating it may overflow
+		err = s.statistics().FindOneAndUpdate(ctx, filter, update, opts).Decode(old)
+	}
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, NewErrorInternal(err, "error incrementing counter")
+		}
+
+		// find out why the entity didn't match
+		entity, err := s.GetStatistic(ctx, userId, entityId)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = incrementCount(entity, delta); err != nil {
+			return nil, err
+		}
+		// the count changed between the update and the get, so that the delta now fits
+		return nil, NewErrorConflict(nil, "statistic was modified concurrently, try again")
 	}
+
+	se := old.clone()
+	if err = incrementCounter(se, delta); err != nil {
+		return nil, NewErrorInternal(err, "error incrementing counter")
+	}
+	se.UpdatedAt = updatedAt
+	se.Version++
+	s.recordHistory(ctx, newHistoryEvent(statspb.HistoryEvent_UPDATE, []string{"counter"}, old, se))
 	return se.toPB(), nil
 }
 
-func (s *storage) DeleteStatistic(ctx context.Context, entityId string) error {
-	filter := bson.M{"_id": entityId}
+// incrementCount returns the count of the ComponentCounter of entity after adding delta to it. It returns
+// an INVALID_ARGUMENT error if entity doesn't have a ComponentCounter or the result is out of bounds.
+func incrementCount(entity *statspb.StatisticEntity, delta int64) (uint32, error) {
+	counter := entity.GetCounter()
+	if counter == nil {
+		return 0, NewErrorInvalidArgument(nil, "component must be %s, not %s", statspb.ComponentType_COUNTER, entity.GetComponentType())
+	}
+
+	count := int64(counter.Count) + delta
+	if count < 0 {
+		return 0, NewErrorInvalidArgument(nil, "count cannot go below zero")
+	}
+	if count > math.MaxUint32 {
+		return 0, NewErrorInvalidArgument(nil, "count cannot exceed %d", uint32(math.MaxUint32))
+	}
+	return uint32(count), nil
+}
+
+// incrementCounter adds delta to the count of the ComponentCounter of se. It returns an INVALID_ARGUMENT error
+// if se doesn't have a ComponentCounter or the result is out of bounds.
+func incrementCounter(se *statisticEntity, delta int64) error {
+	entity := se.toPB()
+	count, err := incrementCount(entity, delta)
+	if err != nil {
+		return err
+	}
+	entity.GetCounter().Count = count
+	return nil
+}
+
+func (s *storage) AppendTimestamps(ctx context.Context, userId, entityId string, timestamps []*timestamppb.Timestamp, unique bool) (*statspb.StatisticEntity, error) {
+	if len(timestamps) == 0 {
+		return nil, NewErrorNoUpdate(nil, "no update possible")
+	}
+	if err := validateTimestamps(timestamps...); err != nil {
+		return nil, err
+	}
+
+	if unique {
+		// $push cannot drop the duplicates that are already in the array, so the timestamps are merged by
+		// modifyStatistic, whose version filter makes the change atomic; it's retried if it loses a race
+		for attempt := 1; ; attempt++ {
+			entity, err := s.modifyStatistic(ctx, statspb.HistoryEvent_UPDATE, userId, entityId, 0, func(se *statisticEntity) ([]string, error) {
+				return []string{"date"}, appendTimestampsTo(se, timestamps, true)
+			})
+			if attempt == appendTimestampsAttempts || !isConflict(err) {
+				return entity, err
+			}
+		}
+	}
+
+	// $push fails on the timestamps that are encoded as null, which are the same as no timestamps
+	filter := bson.M{"_id": entityId, "user_id": userId, "date.timestamps": bson.M{"$type": "null"}}
+	if _, err := s.statistics().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"date.timestamps": bson.A{}}}); err != nil {
+		return nil, NewErrorInternal(err, "error updating timestamps")
+	}
+
+	values := bson.A{}
+	for _, ts := range timestamps {
+		values = append(values, timestampDoc(ts))
+	}
+	updatedAt := now()
 	update := bson.M{
-		"$set": bson.M{
-			"deleted": true,
-		},
+		"$push": bson.M{"date.timestamps": bson.M{
+			"$each": values,
+			"$sort": bson.D{{Key: "seconds", Value: 1}, {Key: "nanos", Value: 1}},
+		}},
+		"$set": bson.M{"updated_at": updatedAt},
+		"$inc": bson.M{"version": 1},
 	}
-	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	if err := s.statistics().FindOneAndUpdate(ctx, filter, update, opts).Err(); err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return NewErrorNotFound(nil, "statistic not found")
+	return s.updateTimestamps(ctx, userId, entityId, update, updatedAt, func(se *statisticEntity) error {
+		return appendTimestampsTo(se, timestamps, false)
+	})
+}
+
+func (s *storage) RemoveTimestamps(ctx context.Context, userId, entityId string, timestamps []*timestamppb.Timestamp) (*statspb.StatisticEntity, error) {
+	if len(timestamps) == 0 {
+		return nil, NewErrorNoUpdate(nil, "no update possible")
+	}
+	if err := validateTimestamps(timestamps...); err != nil {
+		return nil, err
+	}
+
+	conditions := bson.A{}
+	for _, ts := range timestamps {
+		conditions = append(conditions, timestampDoc(ts))
+	}
+	updatedAt := now()
+	update := bson.M{
+		"$pull": bson.M{"date.timestamps": bson.M{"$or": conditions}},
+		"$set":  bson.M{"updated_at": updatedAt},
+		"$inc":  bson.M{"version": 1},
+	}
+	return s.updateTimestamps(ctx, userId, entityId, update, updatedAt, func(se *statisticEntity) error {
+		return removeTimestampsFrom(se, equalToAny(timestamps))
+	})
+}
+
+func (s *storage) RemoveTimestampRange(ctx context.Context, userId, entityId string, from, to *timestamppb.Timestamp) (*statspb.StatisticEntity, error) {
+	if err := validateTimestamps(from, to); err != nil {
+		return nil, err
+	}
+	if compareTimestamps(from, to) > 0 {
+		return nil, NewErrorInvalidField("from", nil, "from cannot be after to")
+	}
+
+	between := bson.M{"$and": bson.A{
+		bson.M{"$or": bson.A{
+			bson.M{"seconds": bson.M{"$gt": from.Seconds}},
+			bson.M{"seconds": from.Seconds, "nanos": bson.M{"$gte": from.Nanos}},
+		}},
+		bson.M{"$or": bson.A{
+			bson.M{"seconds": bson.M{"$lt": to.Seconds}},
+			bson.M{"seconds": to.Seconds, "nanos": bson.M{"$lte": to.Nanos}},
+		}},
+	}}
+	updatedAt := now()
+	update := bson.M{
+		"$pull": bson.M{"date.timestamps": between},
+		"$set":  bson.M{"updated_at": updatedAt},
+		"$inc":  bson.M{"version": 1},
+	}
+	return s.updateTimestamps(ctx, userId, entityId, update, updatedAt, func(se *statisticEntity) error {
+		return removeTimestampsFrom(se, inRange(from, to))
+	})
+}
+
+// updateTimestamps applies update, which sets updated_at to updatedAt, to the entity of userId specified by
+// entityId, if it has a ComponentDate, and returns the updated entity. apply must make the same change to the
+// ComponentDate of an entity as update, so that the updated entity is recorded in the history.
+func (s *storage) updateTimestamps(ctx context.Context, userId, entityId string, update any, updatedAt time.Time, apply func(se *statisticEntity) error) (*statspb.StatisticEntity, error) {
+	filter := bson.M{"_id": entityId, "user_id": userId, "deleted": false, "date": bson.M{"$type": "object"}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	old := &statisticEntity{}
+	if err := s.statistics().FindOneAndUpdate(ctx, filter, update, opts).Decode(old); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, NewErrorInternal(err, "error updating timestamps")
+		}
+
+		// find out why the entity didn't match
+		entity, err := s.GetStatistic(ctx, userId, entityId)
+		if err != nil {
+			return nil, err
 		}
-		return NewErrorInternal(err, "error deleting statistic")
+		if _, err = dateComponent(entity); err != nil {
+			return nil, err
+		}
+		// the entity was deleted or its component was changed between the update and the get
+		return nil, NewErrorConflict(nil, "statistic was modified concurrently, try again")
 	}
-	return nil
+
+	se := old.clone()
+	if err := apply(se); err != nil {
+		return nil, NewErrorInternal(err, "error updating timestamps")
+	}
+	se.UpdatedAt = updatedAt
+	se.Version++
+	s.recordHistory(ctx, newHistoryEvent(statspb.HistoryEvent_UPDATE, []string{"date"}, old, se))
+	return se.toPB(), nil
 }
 
-func (s *storage) ListUserStatistics(ctx context.Context, userId string) ([]*statspb.StatisticEntity, error) {
-	var result []*statspb.StatisticEntity
-	var internalResult []*statisticEntity
+// appendTimestampsAttempts is the number of times AppendTimestamps tries to append unique timestamps to an entity
+// that is modified concurrently.
+const appendTimestampsAttempts = 3
 
-	filter := bson.M{"user_id": userId, "deleted": false}
-	cursor, err := s.statistics().Find(ctx, filter)
+// isConflict reports whether err is a CONFLICT error.
+func isConflict(err error) bool {
+	var se *storageError
+	return errors.As(err, &se) && se.Type == storageErrorType_CONFLICT
+}
+
+// timestampDoc returns the document that ts is stored as in the database.
+func timestampDoc(ts *timestamppb.Timestamp) bson.D {
+	return bson.D{{Key: "seconds", Value: ts.Seconds}, {Key: "nanos", Value: ts.Nanos}}
+}
+
+func (s *storage) ListUserStatistics(ctx context.Context, req *statspb.ListUserStatisticsRequest) (*statspb.ListUserStatisticsResponse, error) {
+	q, err := newListQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// one more entity than the page size tells if there is a next page
+	opts := options.Find().SetSort(q.sort()).SetLimit(int64(q.pageSize + 1))
+	cursor, err := s.statistics().Find(ctx, q.filter(), opts)
 	if err != nil {
 		return nil, NewErrorInternal(err, "error listing statistics")
 	}
-	if err = cursor.All(ctx, &internalResult); err != nil {
+	var entities []*statisticEntity
+	if err = cursor.All(ctx, &entities); err != nil {
 		return nil, NewErrorInternal(err, "error decoding statistics")
 	}
+	return q.response(entities)
+}
+
+func (s *storage) RestoreStatistic(ctx context.Context, userId, entityId string) (*statspb.StatisticEntity, error) {
+	filter := bson.M{"_id": entityId, "user_id": userId, "deleted": true}
+	updatedAt := now()
+	update := bson.M{
+		"$set":   bson.M{"deleted": false, "updated_at": updatedAt},
+		"$unset": bson.M{"deleted_at": ""},
+		"$inc":   bson.M{"version": 1},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	old := &statisticEntity{}
+	if err := s.statistics().FindOneAndUpdate(ctx, filter, update, opts).Decode(old); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, NewErrorNotFound(nil, "deleted statistic not found")
+		}
+		return nil, NewErrorInternal(err, "error restoring statistic")
+	}
+
+	se := old.clone()
+	se.Deleted = false
+	se.DeletedAt = time.Time{}
+	se.UpdatedAt = updatedAt
+	se.Version++
+	s.recordHistory(ctx, newHistoryEvent(statspb.HistoryEvent_RESTORE, nil, old, se))
+	return se.toPB(), nil
+}
+
+func (s *storage) PurgeStatistic(ctx context.Context, userId, entityId string) error {
+	filter := bson.M{"_id": entityId, "user_id": userId, "deleted": true}
+	res, err := s.statistics().DeleteOne(ctx, filter)
+	if err != nil {
+		return NewErrorInternal(err, "error purging statistic")
+	}
+	if res.DeletedCount == 0 {
+		return NewErrorNotFound(nil, "deleted statistic not found")
+	}
+	return nil
+}
 
-	for _, se := range internalResult {
-		result = append(result, se.toPB())
+func (s *storage) PurgeDeletedStatistics(ctx context.Context, before time.Time) (int64, error) {
+	filter := bson.M{"deleted": true, "deleted_at": bson.M{"$lt": before}}
+	res, err := s.statistics().DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, NewErrorInternal(err, "error purging deleted statistics")
 	}
-	if result == nil {
-		result = []*statspb.StatisticEntity{}
+	return res.DeletedCount, nil
+}
+
+func (s *storage) ListHistory(ctx context.Context, userId string, req *statspb.ListHistoryRequest) (*statspb.ListHistoryResponse, error) {
+	q, err := newHistoryQuery(userId, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// one more event than the page size tells if there is a next page
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}}).SetLimit(int64(q.pageSize + 1))
+	cursor, err := s.history().Find(ctx, q.filter(), opts)
+	if err != nil {
+		return nil, NewErrorInternal(err, "error listing history")
+	}
+	var events []*historyEvent
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, NewErrorInternal(err, "error decoding history")
+	}
+	return q.response(events)
+}
+
+// recordHistoryAttempts is the number of times recordHistory tries to write a history event.
+const recordHistoryAttempts = 3
+
+// recordHistory writes he to the history collection. The change that he records is already committed, so it
+// must not be reported as failed: the write is retried, which is safe as the id of he is determined by the
+// entity and its version, and if it still fails, the failure is logged.
+func (s *storage) recordHistory(ctx context.Context, he *historyEvent) {
+	opts := options.Replace().SetUpsert(true)
+	var err error
+	for attempt := 0; attempt < recordHistoryAttempts; attempt++ {
+		if _, err = s.history().ReplaceOne(ctx, bson.M{"_id": he.Id}, he, opts); err == nil {
+			return
+		}
 	}
-	return result, nil
+	logrus.WithError(err).Errorf("recordHistory: error recording %s event %s", statspb.HistoryEvent_Type(he.Type), he.Id)
 }
@@ -12,8 +12,8 @@ import (
 )
 
 // newTestStorage creates a new storage instance, using the mongodb url in the environment
-// or the default one if env url is empty. After creation, it drops collections and returns
-// the storage instance.
+// or the default one if env url is empty. After creation, it drops collections, recreates
+// their indexes and returns the storage instance.
 func newTestStorage(t *testing.T) *storage {
 	url := os.Getenv("TEST_MONGODB_URL")
 	if url == "" {
@@ -29,9 +29,30 @@ func newTestStorage(t *testing.T) *storage {
 	if err = ss.statistics().Drop(context.Background()); err != nil {
 		t.Fatalf("error dropping statistics collection: %v", err)
 	}
+	if err = ss.history().Drop(context.Background()); err != nil {
+		t.Fatalf("error dropping history collection: %v", err)
+	}
+	if err = ss.users().Drop(context.Background()); err != nil {
+		t.Fatalf("error dropping users collection: %v", err)
+	}
+	if err = ss.sessions().Drop(context.Background()); err != nil {
+		t.Fatalf("error dropping sessions collection: %v", err)
+	}
+	if err = ss.apiKeys().Drop(context.Background()); err != nil {
+		t.Fatalf("error dropping API keys collection: %v", err)
+	}
+	if err = ss.createUserIndexes(context.Background()); err != nil {
+		t.Fatalf("error creating user indexes: %v", err)
+	}
 	return ss
 }
 
+func Test_storage_Conformance(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) StatsKeeperStorage {
+		return newTestStorage(t)
+	})
+}
+
 func Test_storage_CreateStatistic(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -77,7 +98,7 @@ func Test_storage_CreateStatistic(t *testing.T) {
 			}
 
 			// check if it's actually created
-			got, err := s.GetStatistic(context.TODO(), result.Id)
+			got, err := s.GetStatistic(context.TODO(), result.UserId, result.Id)
 			if err != nil {
 				t.Fatalf("GetStatistic returned error after creating the entity: %v", err)
 			}
@@ -139,11 +160,10 @@ func Test_storage_GetStatistic(t *testing.T) {
 			name:   "case 4 - deleted entity",
 			entity: nil,
 			internalEntity: &statisticEntity{
-				Id:      "id-1",
-				Name:    "entity-1",
-				UserId:  "user-1",
-				Counter: nil,
-				Date: &statspb.ComponentDate{
+				Id:     "id-1",
+				Name:   "entity-1",
+				UserId: "user-1",
+				Component: &statspb.ComponentDate{
 					Timestamps: []*timestamppb.Timestamp{},
 				},
 				Deleted: true,
@@ -162,7 +182,11 @@ func Test_storage_GetStatistic(t *testing.T) {
 				insertInternalTestEntity(t, s, tt.internalEntity)
 			}
 
-			got, err := s.GetStatistic(context.TODO(), tt.entityId)
+			userId := "user-1"
+			if tt.entity != nil {
+				userId = tt.entity.UserId
+			}
+			got, err := s.GetStatistic(context.TODO(), userId, tt.entityId)
 			if hasError := compareErrors(t, tt.expectedError, err); hasError {
 				return
 			}
@@ -409,10 +433,18 @@ func Test_storage_UpdateStatistic(t *testing.T) {
 				insertTestEntity(t, s, tt.entity)
 			}
 
-			got, err := s.UpdateStatistic(context.TODO(), tt.fields, tt.values)
+			got, err := s.UpdateStatistic(context.TODO(), "user-1", tt.fields, tt.values, 0)
 			if hasError := compareErrors(t, tt.expectedError, err); hasError {
 				return
 			}
+			if got.UpdatedAt == nil {
+				t.Fatalf("UpdateStatistic did not set updated_at")
+			}
+			if got.Version != tt.entity.Version+1 {
+				t.Fatalf("UpdateStatistic did not increment version, got=%d", got.Version)
+			}
+			got.UpdatedAt = nil
+			got.Version = 0
 			if diff := pretty.Compare(got, tt.expectedEntity); diff != "" {
 				t.Fatalf("wrong result, diff: %s", diff)
 			}
@@ -457,14 +489,14 @@ func Test_storage_DeleteStatistic(t *testing.T) {
 				insertTestEntity(t, s, tt.entity)
 			}
 
-			err := s.DeleteStatistic(context.TODO(), tt.entityId)
+			err := s.DeleteStatistic(context.TODO(), "user-1", tt.entityId, 0)
 			if hasError := compareErrors(t, tt.expectedError, err); hasError {
 				return
 			}
 
 			// check if it was actually deleted
 			t.Log("checking if statistic is actually deleted")
-			_, err = s.GetStatistic(context.TODO(), tt.entityId)
+			_, err = s.GetStatistic(context.TODO(), "user-1", tt.entityId)
 			_ = compareErrors(t, NewErrorNotFound(nil, "statistic not found"), err)
 		})
 	}
@@ -600,13 +632,13 @@ func Test_storage_ListUserStatistics(t *testing.T) {
 				insertTestEntity(t, s, e)
 			}
 
-			got, err := s.ListUserStatistics(context.TODO(), tt.userId)
+			got, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: tt.userId})
 			if hasError := compareErrors(t, nil, err); hasError {
 				return
 			}
 
-			got = sortStatsSlice(got)
-			if diff := pretty.Compare(got, tt.expectedResult); diff != "" {
+			entities := sortStatsSlice(got.Entities)
+			if diff := pretty.Compare(entities, tt.expectedResult); diff != "" {
 				t.Fatalf("wrong result, diff: %s", diff)
 			}
 		})
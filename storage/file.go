@@ -0,0 +1,449 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// fileRecordHeaderSize is the size of the header preceding each record in the storage file; 4 bytes
+	// for the length of the record and 4 bytes for its checksum.
+	fileRecordHeaderSize = 8
+
+	// fileRecordMaxSize is the maximum size of a record, which is the maximum size of a BSON document.
+	fileRecordMaxSize = 16 * 1024 * 1024
+
+	// fileCompactionMinRecords is the number of records the storage file must have before it's compacted.
+	fileCompactionMinRecords = 1000
+)
+
+var fileChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// fileRecord is a single entry in the storage file. It records that Doc is stored with Id in
+// Collection. A record without Doc records that the document is removed. A record with Batch records
+// all of the records in Batch instead, so that they're applied either all together or not at all.
+type fileRecord struct {
+	Collection string        `bson:"collection,omitempty"`
+	Id         string        `bson:"id,omitempty"`
+	Doc        bson.Raw      `bson:"doc,omitempty"`
+	Batch      []*fileRecord `bson:"batch,omitempty"`
+}
+
+// fileStorage is an implementation of StatsKeeperStorage that keeps its data in a single local file,
+// without the need of a database server. The data is served from memory, while every change is
+// appended to the file and synced to disk before it's applied. When most of the records in the file
+// are stale, the file is compacted by rewriting it with only the latest records.
+type fileStorage struct {
+	*memoryStorage
+
+	path string
+	file *os.File
+	// size is the number of bytes of valid records in file.
+	size int64
+	// records is the number of records in file.
+	records int
+	// historyEvents is the number of history events, which are never removed, so that the number of latest
+	// records can be counted without iterating over the history.
+	historyEvents int
+	// lock is the file that is locked while the storage is open, so that the storage file is not opened by
+	// another process. It's next to the storage file rather than the storage file itself, which is replaced
+	// when it's compacted.
+	lock *os.File
+}
+
+// newFileStorage opens the storage file at path, creating it if it doesn't exist, and loads its
+// contents. A record at the end of the file that is only partially written, e.g. because of a crash, is
+// discarded. It fails if the storage file is already opened by another process.
+func newFileStorage(path string) (StatsKeeperStorage, error) {
+	if path == "" {
+		return nil, fmt.Errorf("invalid database url: file path cannot be empty")
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening storage lock file: %w", err)
+	}
+	if err = lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("error locking storage file: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("error opening storage file: %w", err)
+	}
+
+	fs := &fileStorage{
+		memoryStorage: newMemoryStorage(),
+		path:          path,
+		file:          file,
+		lock:          lock,
+	}
+	if err = fs.load(); err != nil {
+		file.Close()
+		lock.Close()
+		return nil, err
+	}
+	fs.journal = fs
+	return fs, nil
+}
+
+// load reads all records in the storage file and applies them in order. The file is truncated after
+// the last valid record so that new records are appended after it. Only the last record can be partially
+// written, a corrupt record that is followed by more data fails the load instead of discarding the valid
+// records after it.
+func (fs *fileStorage) load() error {
+	r := bufio.NewReader(fs.file)
+	for {
+		rec, n, err := readFileRecord(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, errIncompleteFileRecord) {
+				break
+			}
+			if errors.Is(err, errCorruptFileRecord) {
+				// a record may be written in full but with garbage if the process crashes, which is harmless
+				// only at the end of the file
+				if _, peekErr := r.Peek(1); errors.Is(peekErr, io.EOF) {
+					break
+				}
+				return fmt.Errorf("error reading storage file at offset %d: %w", fs.size, err)
+			}
+			return fmt.Errorf("error reading storage file: %w", err)
+		}
+		if err = fs.apply(rec); err != nil {
+			return fmt.Errorf("error reading storage file: %w", err)
+		}
+		fs.size += n
+		fs.records++
+	}
+
+	if err := fs.file.Truncate(fs.size); err != nil {
+		return fmt.Errorf("error truncating storage file: %w", err)
+	}
+	if _, err := fs.file.Seek(fs.size, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking storage file: %w", err)
+	}
+	return fs.compact()
+}
+
+// apply applies the given record to the in-memory data.
+func (fs *fileStorage) apply(rec *fileRecord) error {
+	for _, r := range rec.Batch {
+		if err := fs.apply(r); err != nil {
+			return err
+		}
+	}
+	if rec.Batch != nil {
+		return nil
+	}
+
+	switch rec.Collection {
+	case statisticsCollectionName:
+		if rec.Doc == nil {
+			delete(fs.statistics, rec.Id)
+			return nil
+		}
+		se := &statisticEntity{}
+		if err := bson.Unmarshal(rec.Doc, se); err != nil {
+			return fmt.Errorf("error decoding statistic %q: %w", rec.Id, err)
+		}
+		fs.statistics[rec.Id] = se
+		return nil
+	case historyCollectionName:
+		he := &historyEvent{}
+		if err := bson.Unmarshal(rec.Doc, he); err != nil {
+			return fmt.Errorf("error decoding history event %q: %w", rec.Id, err)
+		}
+		fs.history[he.EntityId] = append(fs.history[he.EntityId], he)
+		fs.historyEvents++
+		return nil
+	case usersCollectionName:
+		ue := &userEntity{}
+		if err := bson.Unmarshal(rec.Doc, ue); err != nil {
+			return fmt.Errorf("error decoding user %q: %w", rec.Id, err)
+		}
+		fs.users[rec.Id] = ue
+		return nil
+	case sessionsCollectionName:
+		if rec.Doc == nil {
+			delete(fs.sessions, rec.Id)
+			return nil
+		}
+		se := &sessionEntity{}
+		if err := bson.Unmarshal(rec.Doc, se); err != nil {
+			return fmt.Errorf("error decoding session %q: %w", rec.Id, err)
+		}
+		fs.sessions[rec.Id] = se
+		return nil
+	case apiKeysCollectionName:
+		if rec.Doc == nil {
+			delete(fs.apiKeys, rec.Id)
+			return nil
+		}
+		ke := &apiKeyEntity{}
+		if err := bson.Unmarshal(rec.Doc, ke); err != nil {
+			return fmt.Errorf("error decoding API key %q: %w", rec.Id, err)
+		}
+		fs.apiKeys[rec.Id] = ke
+		return nil
+	default:
+		return fmt.Errorf("unknown collection %q", rec.Collection)
+	}
+}
+
+// Ping implements StatsKeeperStorage. It checks that the storage file is still open and exists at its path,
+// since the changes cannot be persisted otherwise.
+func (fs *fileStorage) Ping(ctx context.Context) error {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if _, err := fs.file.Stat(); err != nil {
+		return NewErrorInternal(err, "storage file is unavailable")
+	}
+	if _, err := os.Stat(fs.path); err != nil {
+		return NewErrorInternal(err, "storage file is unavailable")
+	}
+	return nil
+}
+
+// Close implements StatsKeeperStorage. It closes the storage file once the ongoing operations finish, which
+// have already synced their changes to it, and then unlocks it.
+func (fs *fileStorage) Close(ctx context.Context) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	err := fs.file.Close()
+	// closing the lock file releases the lock
+	if lockErr := fs.lock.Close(); err == nil {
+		err = lockErr
+	}
+	if err != nil {
+		return NewErrorInternal(err, "error closing storage file")
+	}
+	return nil
+}
+
+// append implements journal. Multiple changes are written as a single record, which is either written in full
+// or discarded when the file is loaded.
+func (fs *fileStorage) append(changes ...journalChange) error {
+	var records []*fileRecord
+	for _, c := range changes {
+		rec, err := newFileRecord(c.collection, c.id, c.doc)
+		if err != nil {
+			return err
+		}
+		records = append(records, rec)
+	}
+	rec := records[0]
+	if len(records) > 1 {
+		rec = &fileRecord{Batch: records}
+	}
+	data, err := frameFileRecord(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = fs.file.Write(data); err == nil {
+		err = fs.file.Sync()
+	}
+	if err != nil {
+		// don't leave a partial record behind, new records must follow the last valid one
+		_ = fs.file.Truncate(fs.size)
+		_, _ = fs.file.Seek(fs.size, io.SeekStart)
+		return fmt.Errorf("error writing to storage file: %w", err)
+	}
+	fs.size += int64(len(data))
+	fs.records++
+	for _, c := range changes {
+		if c.collection == historyCollectionName {
+			fs.historyEvents++
+		}
+	}
+	return nil
+}
+
+// compact implements journal. It rewrites the storage file with only the latest records if the number
+// of stale records exceed half the number of latest ones. History events are never stale, so a file
+// always has about as many latest records as stale ones when the entities are modified. The new file
+// is written next to the current one and renamed over it, so that a crash leaves either the old or the
+// new file in place.
+func (fs *fileStorage) compact() error {
+	live := len(fs.statistics) + len(fs.users) + len(fs.sessions) + len(fs.apiKeys) + fs.historyEvents
+	if fs.records < fileCompactionMinRecords || 2*fs.records <= 3*live {
+		return nil
+	}
+
+	tmpPath := fs.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating compacted storage file: %w", err)
+	}
+	w := &fileRecordWriter{w: bufio.NewWriter(tmp)}
+	writeErr := func() error {
+		if err := writeFileRecords(w, statisticsCollectionName, fs.statistics); err != nil {
+			return err
+		}
+		// the events of each entity are kept in order, which is the order they're loaded in
+		for _, events := range fs.history {
+			for _, he := range events {
+				if err := w.write(historyCollectionName, he.Id, he); err != nil {
+					return err
+				}
+			}
+		}
+		if err := writeFileRecords(w, usersCollectionName, fs.users); err != nil {
+			return err
+		}
+		if err := writeFileRecords(w, sessionsCollectionName, fs.sessions); err != nil {
+			return err
+		}
+		if err := writeFileRecords(w, apiKeysCollectionName, fs.apiKeys); err != nil {
+			return err
+		}
+		if err := w.w.Flush(); err != nil {
+			return err
+		}
+		return tmp.Sync()
+	}()
+	if writeErr != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("error writing compacted storage file: %w", writeErr)
+	}
+
+	if err = os.Rename(tmpPath, fs.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("error replacing storage file: %w", err)
+	}
+	// the new file is at the path now, so it must be used even if the rename cannot be synced, since the
+	// records appended to the old one would be lost
+	fs.file.Close()
+	fs.file = tmp
+	fs.size = w.size
+	fs.records = live
+	if err = syncDir(filepath.Dir(fs.path)); err != nil {
+		return fmt.Errorf("error syncing storage directory: %w", err)
+	}
+	return nil
+}
+
+// fileRecordWriter writes records to a storage file, counting the bytes written.
+type fileRecordWriter struct {
+	w    *bufio.Writer
+	size int64
+}
+
+// write writes the record of the given document.
+func (rw *fileRecordWriter) write(collection, id string, doc any) error {
+	data, err := encodeFileRecord(collection, id, doc)
+	if err != nil {
+		return err
+	}
+	n, err := rw.w.Write(data)
+	rw.size += int64(n)
+	return err
+}
+
+// writeFileRecords writes the records of docs, which are the documents of collection by their ids, to w.
+func writeFileRecords[V any](w *fileRecordWriter, collection string, docs map[string]V) error {
+	for id, doc := range docs {
+		if err := w.write(collection, id, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir syncs the directory at path, so that the changes to its entries, such as renames, are durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+var (
+	errIncompleteFileRecord = errors.New("incomplete record")
+	errCorruptFileRecord    = errors.New("corrupt record")
+)
+
+// encodeFileRecord encodes a fileRecord for the given document, along with its header.
+func encodeFileRecord(collection, id string, doc any) ([]byte, error) {
+	rec, err := newFileRecord(collection, id, doc)
+	if err != nil {
+		return nil, err
+	}
+	return frameFileRecord(rec)
+}
+
+// newFileRecord returns the fileRecord for the given document, which is nil for a removal.
+func newFileRecord(collection, id string, doc any) (*fileRecord, error) {
+	rec := &fileRecord{Collection: collection, Id: id}
+	if doc != nil {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding record: %w", err)
+		}
+		rec.Doc = raw
+	}
+	return rec, nil
+}
+
+// frameFileRecord encodes rec along with its header.
+func frameFileRecord(rec *fileRecord) ([]byte, error) {
+	payload, err := bson.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding record: %w", err)
+	}
+
+	data := make([]byte, fileRecordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(data[4:8], crc32.Checksum(payload, fileChecksumTable))
+	copy(data[fileRecordHeaderSize:], payload)
+	return data, nil
+}
+
+// readFileRecord reads the next record from r and returns it along with the number of bytes read. It
+// returns io.EOF if there are no more records, errIncompleteFileRecord if r ends in the middle of the next
+// record, and errCorruptFileRecord if the next record doesn't match its checksum or cannot be decoded.
+func readFileRecord(r io.Reader) (*fileRecord, int64, error) {
+	header := make([]byte, fileRecordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.EOF
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, errIncompleteFileRecord
+		}
+		return nil, 0, err
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > fileRecordMaxSize {
+		return nil, 0, errCorruptFileRecord
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, errIncompleteFileRecord
+		}
+		return nil, 0, err
+	}
+	if crc32.Checksum(payload, fileChecksumTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, 0, errCorruptFileRecord
+	}
+
+	rec := &fileRecord{}
+	if err := bson.Unmarshal(payload, rec); err != nil {
+		return nil, 0, errCorruptFileRecord
+	}
+	return rec, int64(len(payload) + fileRecordHeaderSize), nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile fails on the platforms without flock, since the storage file could be corrupted by another process
+// that opens it without the lock.
+func lockFile(file *os.File) error {
+	return errors.New("locking files is not supported on this platform")
+}
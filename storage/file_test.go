@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestFileStorage opens the fileStorage at path, which is closed when the test ends.
+func newTestFileStorage(t *testing.T, path string) *fileStorage {
+	s, err := NewStatsKeeperStorage("file://" + path)
+	if err != nil {
+		t.Fatalf("error creating new storage: %v", err)
+	}
+	fs := s.(*fileStorage)
+	t.Cleanup(func() {
+		_ = fs.Close(context.TODO())
+	})
+	return fs
+}
+
+func Test_fileStorage_Conformance(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) StatsKeeperStorage {
+		return newTestFileStorage(t, filepath.Join(t.TempDir(), "stats.db"))
+	})
+}
+
+func Test_fileStorage_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	s := newTestFileStorage(t, path)
+
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	deleted := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-2", 2))
+	values := newTestCounterEntity("", "entity-1-updated", 5)
+	values.Id = created.Id
+	updated, err := s.UpdateStatistic(context.TODO(), "user-1", []string{"name", "counter"}, values, 0)
+	if err != nil {
+		t.Fatalf("UpdateStatistic returned unexpected error: %v", err)
+	}
+	if err = s.DeleteStatistic(context.TODO(), deleted.UserId, deleted.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+	purged := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-3", 3))
+	if err = s.DeleteStatistic(context.TODO(), purged.UserId, purged.Id, 0); err != nil {
+		t.Fatalf("DeleteStatistic returned unexpected error: %v", err)
+	}
+	if err = s.PurgeStatistic(context.TODO(), purged.UserId, purged.Id); err != nil {
+		t.Fatalf("PurgeStatistic returned unexpected error: %v", err)
+	}
+	trash, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", Deleted: true})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	user, err := s.CreateUser(context.TODO(), "alice", []byte("hash"))
+	if err != nil {
+		t.Fatalf("CreateUser returned unexpected error: %v", err)
+	}
+	if err = s.CreateSession(context.TODO(), user.Id, "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CreateSession returned unexpected error: %v", err)
+	}
+	s.Close(context.TODO())
+
+	s = newTestFileStorage(t, path)
+	list, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	compareEntityLists(t, []*statspb.StatisticEntity{updated}, list.Entities)
+	list, err = s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1", Deleted: true})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	compareEntityLists(t, trash.Entities, list.Entities)
+	credentials, err := s.GetUserCredentials(context.TODO(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserCredentials returned unexpected error: %v", err)
+	}
+	if !proto.Equal(user, credentials.User) {
+		t.Fatalf("wrong user: expected=%v, got=%v", user, credentials.User)
+	}
+	if userId, err := s.GetSession(context.TODO(), "token"); err != nil || userId != user.Id {
+		t.Fatalf("wrong session: expected=%s, got=%s, err=%v", user.Id, userId, err)
+	}
+}
+
+func Test_fileStorage_PartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	s := newTestFileStorage(t, path)
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	s.Close(context.TODO())
+
+	// simulate a crash in the middle of writing a record
+	data, err := encodeFileRecord(statisticsCollectionName, "id-2", &statisticEntity{Id: "id-2", UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("error encoding record: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("error opening storage file: %v", err)
+	}
+	if _, err = f.Write(data[:len(data)/2]); err != nil {
+		t.Fatalf("error writing storage file: %v", err)
+	}
+	f.Close()
+
+	s = newTestFileStorage(t, path)
+	second := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-2", 2))
+	s.Close(context.TODO())
+
+	s = newTestFileStorage(t, path)
+	list, err := s.ListUserStatistics(context.TODO(), &statspb.ListUserStatisticsRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	compareEntityLists(t, []*statspb.StatisticEntity{created, second}, list.Entities)
+}
+
+func Test_fileStorage_PartialHistoryRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	s := newTestFileStorage(t, path)
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	s.Close(context.TODO())
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("error getting storage file info: %v", err)
+	}
+
+	s = newTestFileStorage(t, path)
+	if _, err = s.IncrementCounter(context.TODO(), "user-1", created.Id, 1); err != nil {
+		t.Fatalf("IncrementCounter returned unexpected error: %v", err)
+	}
+	s.Close(context.TODO())
+	updated, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("error getting storage file info: %v", err)
+	}
+
+	// simulate a crash in the middle of writing the change, which is written along with its history event
+	if err = os.Truncate(path, (info.Size()+updated.Size())/2); err != nil {
+		t.Fatalf("error truncating storage file: %v", err)
+	}
+
+	s = newTestFileStorage(t, path)
+	got, err := s.GetStatistic(context.TODO(), "user-1", created.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	compareEntities(t, created, got)
+	history, err := s.ListHistory(context.TODO(), "user-1", &statspb.ListHistoryRequest{EntityId: created.Id})
+	if err != nil {
+		t.Fatalf("ListHistory returned unexpected error: %v", err)
+	}
+	if len(history.Events) != 1 || history.Events[0].Version != created.Version {
+		t.Fatalf("wrong history: expected only the event of version %d, got=%v", created.Version, history.Events)
+	}
+}
+
+func Test_fileStorage_CorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	s := newTestFileStorage(t, path)
+	createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	createTestEntity(t, s, newTestCounterEntity("user-1", "entity-2", 2))
+	s.Close(context.TODO())
+
+	// flip a bit in the payload of the first record, which is followed by valid ones
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading storage file: %v", err)
+	}
+	data[fileRecordHeaderSize+10] ^= 1
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("error writing storage file: %v", err)
+	}
+
+	if _, err = NewStatsKeeperStorage("file://" + path); err == nil {
+		t.Fatalf("opening a storage file with a corrupt record did not fail")
+	}
+	if got, err := os.ReadFile(path); err != nil || len(got) != len(data) {
+		t.Fatalf("storage file is truncated: expected=%d bytes, got=%d, err=%v", len(data), len(got), err)
+	}
+}
+
+func Test_fileStorage_Lock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	s := newTestFileStorage(t, path)
+	if _, err := NewStatsKeeperStorage("file://" + path); err == nil {
+		t.Fatalf("opening a storage file that is already open did not fail")
+	}
+	s.Close(context.TODO())
+
+	newTestFileStorage(t, path)
+}
+
+func Test_fileStorage_Compaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	s := newTestFileStorage(t, path)
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 0))
+
+	var last *statspb.StatisticEntity
+	for i := uint32(1); i <= fileCompactionMinRecords; i++ {
+		values := newTestCounterEntity("", "", i)
+		values.Id = created.Id
+		updated, err := s.UpdateStatistic(context.TODO(), "user-1", []string{"counter"}, values, 0)
+		if err != nil {
+			t.Fatalf("UpdateStatistic returned unexpected error: %v", err)
+		}
+		last = updated
+	}
+	// every update writes a statistic and a history event, only the statistics become stale
+	if s.records >= 2*fileCompactionMinRecords {
+		t.Fatalf("storage file is not compacted, records=%d", s.records)
+	}
+	s.Close(context.TODO())
+
+	s = newTestFileStorage(t, path)
+	got, err := s.GetStatistic(context.TODO(), created.UserId, created.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	compareEntities(t, last, got)
+}
+
+func Test_fileStorage_Ping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	s := newTestFileStorage(t, path)
+	if err := s.Ping(context.TODO()); err != nil {
+		t.Fatalf("Ping returned unexpected error: %v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("error removing storage file: %v", err)
+	}
+	err := s.Ping(context.TODO())
+	if err == nil || err.(*storageError).Type != storageErrorType_INTERNAL {
+		t.Fatalf("expected INTERNAL error after removing the storage file, got %v", err)
+	}
+}
+
+func Test_fileStorage_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	s := newTestFileStorage(t, path)
+	created := createTestEntity(t, s, newTestCounterEntity("user-1", "entity-1", 1))
+	if err := s.Close(context.TODO()); err != nil {
+		t.Fatalf("Close returned unexpected error: %v", err)
+	}
+
+	if _, err := s.IncrementCounter(context.TODO(), "user-1", created.Id, 1); err == nil {
+		t.Fatalf("IncrementCounter did not fail after Close")
+	}
+	if err := s.Ping(context.TODO()); err == nil {
+		t.Fatalf("Ping did not fail after Close")
+	}
+
+	s = newTestFileStorage(t, path)
+	got, err := s.GetStatistic(context.TODO(), "user-1", created.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	if !proto.Equal(created, got) {
+		t.Fatalf("entity changed after Close: expected=%v, got=%v", created, got)
+	}
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const historyCollectionName = "History"
+
+// historyEvent is the internal representation of statspb.HistoryEvent.
+type historyEvent struct {
+	Id        string           `bson:"_id"`
+	EntityId  string           `bson:"entity_id"`
+	UserId    string           `bson:"user_id"`
+	Type      int32            `bson:"type"`
+	Paths     []string         `bson:"paths,omitempty"`
+	Old       *statisticEntity `bson:"old,omitempty"`
+	New       *statisticEntity `bson:"new"`
+	Timestamp time.Time        `bson:"timestamp"`
+	Version   int64            `bson:"version"`
+}
+
+// newHistoryEvent returns the event of a change of the given type from old to new, which is nil for CREATE
+// events. old and new must not be modified afterwards.
+func newHistoryEvent(typ statspb.HistoryEvent_Type, paths []string, old, new *statisticEntity) *historyEvent {
+	return &historyEvent{
+		// there is a single event for each version of an entity
+		Id:        fmt.Sprintf("%s-%d", new.Id, new.Version),
+		EntityId:  new.Id,
+		UserId:    new.UserId,
+		Type:      int32(typ),
+		Paths:     paths,
+		Old:       old,
+		New:       new,
+		Timestamp: new.UpdatedAt,
+		Version:   new.Version,
+	}
+}
+
+// toPB converts this historyEvent to *statspb.HistoryEvent.
+func (he *historyEvent) toPB() *statspb.HistoryEvent {
+	out := &statspb.HistoryEvent{
+		Id:        he.Id,
+		EntityId:  he.EntityId,
+		UserId:    he.UserId,
+		Type:      statspb.HistoryEvent_Type(he.Type),
+		Paths:     he.Paths,
+		Timestamp: timestampOf(he.Timestamp),
+		Version:   he.Version,
+	}
+	if he.Old != nil {
+		out.OldValue = he.Old.toPB()
+	}
+	if he.New != nil {
+		out.NewValue = he.New.toPB()
+	}
+	return out
+}
+
+// createdPaths returns the paths of the fields that are set by creating se.
+func createdPaths(se *statisticEntity) []string {
+	paths := []string{"name", "user_id"}
+	if c := componentOfValue(se.Component); c != nil {
+		paths = append(paths, c.Path())
+	}
+	return paths
+}
+
+// historyQuery is the validated form of a statspb.ListHistoryRequest, which is shared by the
+// implementations of ListHistory. Events are listed from the latest version to the first one.
+type historyQuery struct {
+	userId   string
+	req      *statspb.ListHistoryRequest
+	pageSize int
+	// before is the version of the last event of the previous page, or zero for the first page.
+	before int64
+}
+
+// historyPageToken is the content of a page token of ListHistory.
+type historyPageToken struct {
+	EntityId string `bson:"entity_id"`
+	Version  int64  `bson:"version"`
+}
+
+// newHistoryQuery validates req and returns its historyQuery, which lists only the events of the entities
+// of userId. It returns an INVALID_ARGUMENT error if any of
+// the fields of req is invalid.
+func newHistoryQuery(userId string, req *statspb.ListHistoryRequest) (*historyQuery, error) {
+	pageSize, err := pageSizeOf(req.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	q := &historyQuery{userId: userId, req: req, pageSize: pageSize}
+
+	if req.PageToken != "" {
+		data, err := base64.RawURLEncoding.DecodeString(req.PageToken)
+		if err != nil {
+			return nil, NewErrorInvalidField("page_token", err, "invalid page_token")
+		}
+		token := &historyPageToken{}
+		if err = bson.Unmarshal(data, token); err != nil {
+			return nil, NewErrorInvalidField("page_token", err, "invalid page_token")
+		}
+		if token.EntityId != req.EntityId {
+			return nil, NewErrorInvalidField("page_token", nil, "page_token doesn't match the request")
+		}
+		q.before = token.Version
+	}
+	return q, nil
+}
+
+// matches reports whether he is one of the events that the query lists, on the requested page or after it.
+func (q *historyQuery) matches(he *historyEvent) bool {
+	return he.EntityId == q.req.EntityId && he.UserId == q.userId && (q.before == 0 || he.Version < q.before)
+}
+
+// filter returns the MongoDB filter that matches the same events as matches.
+func (q *historyQuery) filter() bson.M {
+	filter := bson.M{"entity_id": q.req.EntityId, "user_id": q.userId}
+	if q.before != 0 {
+		filter["version"] = bson.M{"$lt": q.before}
+	}
+	return filter
+}
+
+// response returns the page of the query from events, which are the matching events ordered from the
+// latest version. Only the first pageSize+1 events are needed to tell whether there is a next page.
+func (q *historyQuery) response(events []*historyEvent) (*statspb.ListHistoryResponse, error) {
+	resp := &statspb.ListHistoryResponse{Events: []*statspb.HistoryEvent{}}
+	for i, he := range events {
+		if i == q.pageSize {
+			break
+		}
+		resp.Events = append(resp.Events, he.toPB())
+	}
+	if len(events) <= q.pageSize {
+		return resp, nil
+	}
+
+	token := &historyPageToken{EntityId: q.req.EntityId, Version: events[q.pageSize-1].Version}
+	data, err := bson.Marshal(token)
+	if err != nil {
+		return nil, NewErrorInternal(err, "error encoding page_token")
+	}
+	resp.NextPageToken = base64.RawURLEncoding.EncodeToString(data)
+	return resp, nil
+}
+
+// validateRevertRequest returns an INVALID_ARGUMENT error if req doesn't specify exactly one valid version or
+// timestamp to revert to.
+func validateRevertRequest(req *statspb.RevertStatisticRequest) error {
+	if (req.Version == 0) == (req.Timestamp == nil) {
+		return NewErrorInvalidArgument(nil, "exactly one of version and timestamp must be set")
+	}
+	if req.Version < 0 {
+		return NewErrorInvalidField("version", nil, "version cannot be negative")
+	}
+	if req.Timestamp != nil {
+		return validateTimestamps(req.Timestamp)
+	}
+	return nil
+}
+
+// isRevision reports whether he is the event that created the revision requested by req, given that events
+// are checked from the latest version to the first one. req must be validated by validateRevertRequest.
+func isRevision(he *historyEvent, req *statspb.RevertStatisticRequest) bool {
+	if req.Version != 0 {
+		return he.Version == req.Version
+	}
+	return !he.Timestamp.After(req.Timestamp.AsTime())
+}
+
+// revisionFilter returns the MongoDB filter and options that find the same event as isRevision, among the
+// events of the entities of userId.
+func revisionFilter(userId string, req *statspb.RevertStatisticRequest) (bson.M, *options.FindOneOptions) {
+	if req.Version != 0 {
+		return bson.M{"entity_id": req.EntityId, "user_id": userId, "version": req.Version}, options.FindOne()
+	}
+	filter := bson.M{"entity_id": req.EntityId, "user_id": userId, "timestamp": bson.M{"$lte": req.Timestamp.AsTime()}}
+	return filter, options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})
+}
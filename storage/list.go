@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// defaultListPageSize is the page size of the list methods if the request doesn't specify one.
+	defaultListPageSize = 100
+
+	// maxListPageSize is the maximum page size of the list methods.
+	maxListPageSize = 1000
+)
+
+// listQuery is the validated form of a statspb.ListUserStatisticsRequest, which is shared by the
+// implementations of ListUserStatistics.
+type listQuery struct {
+	req      *statspb.ListUserStatisticsRequest
+	pageSize int
+	sortKey  string
+	// component is nil if the entities are not filtered by their component.
+	component Component
+	// after is the position of the last entity of the previous page, or nil for the first page.
+	after *statisticEntity
+}
+
+// listPageToken is the content of a page token. It has the fields of the request, so that a token cannot
+// be used with another request, and the position of the last entity of the page.
+type listPageToken struct {
+	OrderBy       int32  `bson:"order_by"`
+	Descending    bool   `bson:"descending"`
+	ComponentType int32  `bson:"component_type"`
+	NamePrefix    string `bson:"name_prefix"`
+	Deleted       bool   `bson:"deleted"`
+
+	Id   string    `bson:"id"`
+	Name string    `bson:"name,omitempty"`
+	Time time.Time `bson:"time,omitempty"`
+}
+
+// newListQuery validates req and returns its listQuery. It returns an INVALID_ARGUMENT error if any of the
+// fields of req is invalid.
+func newListQuery(req *statspb.ListUserStatisticsRequest) (*listQuery, error) {
+	pageSize, err := pageSizeOf(req.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	q := &listQuery{req: req, pageSize: pageSize}
+	if q.sortKey, err = listSortKey(req.OrderBy); err != nil {
+		return nil, err
+	}
+	if req.ComponentType != statspb.ComponentType_NONE {
+		if q.component = ComponentByType(req.ComponentType); q.component == nil {
+			return nil, NewErrorInvalidField("component_type", nil, "invalid component_type %s", req.ComponentType)
+		}
+	}
+
+	if req.PageToken != "" {
+		data, err := base64.RawURLEncoding.DecodeString(req.PageToken)
+		if err != nil {
+			return nil, NewErrorInvalidField("page_token", err, "invalid page_token")
+		}
+		token := &listPageToken{}
+		if err = bson.Unmarshal(data, token); err != nil {
+			return nil, NewErrorInvalidField("page_token", err, "invalid page_token")
+		}
+		if token.OrderBy != int32(req.OrderBy) || token.Descending != req.Descending ||
+			token.ComponentType != int32(req.ComponentType) || token.NamePrefix != req.NamePrefix ||
+			token.Deleted != req.Deleted {
+			return nil, NewErrorInvalidField("page_token", nil, "page_token doesn't match the request")
+		}
+		q.after = &statisticEntity{Id: token.Id, Name: token.Name, CreatedAt: token.Time, UpdatedAt: token.Time, DeletedAt: token.Time}
+	}
+	return q, nil
+}
+
+// pageSizeOf returns the page size of a list request with the given page_size field. It returns an
+// INVALID_ARGUMENT error if pageSize is out of bounds.
+func pageSizeOf(pageSize int32) (int, error) {
+	switch {
+	case pageSize < 0:
+		return 0, NewErrorInvalidField("page_size", nil, "page_size cannot be negative")
+	case pageSize == 0:
+		return defaultListPageSize, nil
+	case pageSize > maxListPageSize:
+		return 0, NewErrorInvalidField("page_size", nil, "page_size cannot be more than %d", maxListPageSize)
+	default:
+		return int(pageSize), nil
+	}
+}
+
+// matches reports whether se is one of the entities that the query lists, on the requested page or after it.
+func (q *listQuery) matches(se *statisticEntity) bool {
+	if se.UserId != q.req.UserId || se.Deleted != q.req.Deleted {
+		return false
+	}
+	if q.component != nil && componentTypeOf(se.Component) != q.component.Type() {
+		return false
+	}
+	if !strings.HasPrefix(se.Name, q.req.NamePrefix) {
+		return false
+	}
+	return q.after == nil || q.less(q.after, se)
+}
+
+// less reports whether a comes before b in the order of the query.
+func (q *listQuery) less(a, b *statisticEntity) bool {
+	if q.req.Descending {
+		return compareStatistics(a, b, q.req.OrderBy) > 0
+	}
+	return compareStatistics(a, b, q.req.OrderBy) < 0
+}
+
+// filter returns the MongoDB filter that matches the same entities as matches.
+func (q *listQuery) filter() bson.M {
+	filter := bson.M{"user_id": q.req.UserId, "deleted": q.req.Deleted}
+	if q.component != nil {
+		filter[q.component.Path()] = bson.M{"$ne": nil}
+	}
+	if q.req.NamePrefix != "" {
+		filter["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(q.req.NamePrefix)}
+	}
+	if q.after == nil {
+		return filter
+	}
+
+	op := "$gt"
+	if q.req.Descending {
+		op = "$lt"
+	}
+	afterId := bson.M{"_id": bson.M{op: q.after.Id}}
+	if q.sortKey == "_id" {
+		return bson.M{"$and": bson.A{filter, afterId}}
+	}
+
+	value := listSortValue(q.after, q.req.OrderBy)
+	var after bson.A
+	switch {
+	case value == nil && !q.req.Descending:
+		// entities without a value come first
+		after = bson.A{
+			bson.M{q.sortKey: nil, "_id": afterId["_id"]},
+			bson.M{q.sortKey: bson.M{"$ne": nil}},
+		}
+	case value == nil:
+		after = bson.A{bson.M{q.sortKey: nil, "_id": afterId["_id"]}}
+	case !q.req.Descending:
+		after = bson.A{
+			bson.M{q.sortKey: bson.M{op: value}},
+			bson.M{q.sortKey: value, "_id": afterId["_id"]},
+		}
+	default:
+		// entities without a value come last
+		after = bson.A{
+			bson.M{q.sortKey: bson.M{op: value}},
+			bson.M{q.sortKey: value, "_id": afterId["_id"]},
+			bson.M{q.sortKey: nil},
+		}
+	}
+	return bson.M{"$and": bson.A{filter, bson.M{"$or": after}}}
+}
+
+// sort returns the MongoDB sort document of the query.
+func (q *listQuery) sort() bson.D {
+	direction := 1
+	if q.req.Descending {
+		direction = -1
+	}
+	sort := bson.D{{Key: q.sortKey, Value: direction}}
+	if q.sortKey != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+	return sort
+}
+
+// response returns the page of the query from entities, which are the sorted entities that match the
+// query. Only the first pageSize+1 entities are needed to tell whether there is a next page.
+func (q *listQuery) response(entities []*statisticEntity) (*statspb.ListUserStatisticsResponse, error) {
+	resp := &statspb.ListUserStatisticsResponse{Entities: []*statspb.StatisticEntity{}}
+	for i, se := range entities {
+		if i == q.pageSize {
+			break
+		}
+		resp.Entities = append(resp.Entities, se.toPB())
+	}
+	if len(entities) <= q.pageSize {
+		return resp, nil
+	}
+
+	last := entities[q.pageSize-1]
+	token := &listPageToken{
+		OrderBy:       int32(q.req.OrderBy),
+		Descending:    q.req.Descending,
+		ComponentType: int32(q.req.ComponentType),
+		NamePrefix:    q.req.NamePrefix,
+		Deleted:       q.req.Deleted,
+		Id:            last.Id,
+	}
+	switch q.req.OrderBy {
+	case statspb.ListUserStatisticsRequest_NAME:
+		token.Name = last.Name
+	case statspb.ListUserStatisticsRequest_CREATED_AT:
+		token.Time = last.CreatedAt
+	case statspb.ListUserStatisticsRequest_UPDATED_AT:
+		token.Time = last.UpdatedAt
+	case statspb.ListUserStatisticsRequest_DELETED_AT:
+		token.Time = last.DeletedAt
+	}
+	data, err := bson.Marshal(token)
+	if err != nil {
+		return nil, NewErrorInternal(err, "error encoding page_token")
+	}
+	resp.NextPageToken = base64.RawURLEncoding.EncodeToString(data)
+	return resp, nil
+}
+
+// listSortKey returns the key of the field that entities are sorted by, as it's stored in the database.
+// It returns an INVALID_ARGUMENT error if orderBy is unknown.
+func listSortKey(orderBy statspb.ListUserStatisticsRequest_OrderBy) (string, error) {
+	switch orderBy {
+	case statspb.ListUserStatisticsRequest_ID:
+		return "_id", nil
+	case statspb.ListUserStatisticsRequest_CREATED_AT:
+		return "created_at", nil
+	case statspb.ListUserStatisticsRequest_UPDATED_AT:
+		return "updated_at", nil
+	case statspb.ListUserStatisticsRequest_NAME:
+		return "name", nil
+	case statspb.ListUserStatisticsRequest_DELETED_AT:
+		return "deleted_at", nil
+	default:
+		return "", NewErrorInvalidField("order_by", nil, "invalid order_by %d", orderBy)
+	}
+}
+
+// listSortValue returns the value of se that is sorted by orderBy, as it's stored in the database. It
+// returns nil if se doesn't have a value, which is the case for the timestamps of older documents.
+func listSortValue(se *statisticEntity, orderBy statspb.ListUserStatisticsRequest_OrderBy) any {
+	var t time.Time
+	switch orderBy {
+	case statspb.ListUserStatisticsRequest_NAME:
+		return se.Name
+	case statspb.ListUserStatisticsRequest_CREATED_AT:
+		t = se.CreatedAt
+	case statspb.ListUserStatisticsRequest_UPDATED_AT:
+		t = se.UpdatedAt
+	case statspb.ListUserStatisticsRequest_DELETED_AT:
+		t = se.DeletedAt
+	default:
+		return se.Id
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// compareStatistics returns -1, 0 or 1 if a comes before, at the same position as or after b in a list
+// that is sorted by orderBy in ascending order. Entities with equal values are sorted by their ids.
+func compareStatistics(a, b *statisticEntity, orderBy statspb.ListUserStatisticsRequest_OrderBy) int {
+	result := 0
+	switch orderBy {
+	case statspb.ListUserStatisticsRequest_CREATED_AT:
+		result = compareTimes(a.CreatedAt, b.CreatedAt)
+	case statspb.ListUserStatisticsRequest_UPDATED_AT:
+		result = compareTimes(a.UpdatedAt, b.UpdatedAt)
+	case statspb.ListUserStatisticsRequest_DELETED_AT:
+		result = compareTimes(a.DeletedAt, b.DeletedAt)
+	case statspb.ListUserStatisticsRequest_NAME:
+		result = strings.Compare(a.Name, b.Name)
+	}
+	if result == 0 {
+		result = strings.Compare(a.Id, b.Id)
+	}
+	return result
+}
+
+// compareTimes returns -1, 0 or 1 if a is before, equal to or after b, respectively.
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
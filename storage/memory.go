@@ -0,0 +1,374 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// memoryStorage is an implementation of StatsKeeperStorage that keeps everything in memory. It's
+// useful for running the server and its tests without a database server. It has the same semantics
+// with the MongoDB implementation and is safe for concurrent use.
+type memoryStorage struct {
+	mu         sync.RWMutex
+	statistics map[string]*statisticEntity
+	// history has the events of each entity, ordered by their versions.
+	history map[string][]*historyEvent
+	// users has the users by their usernames.
+	users map[string]*userEntity
+	// sessions has the sessions by their token hashes.
+	sessions map[string]*sessionEntity
+	// apiKeys has the API keys by their key hashes.
+	apiKeys map[string]*apiKeyEntity
+
+	// journal, if not nil, is where every change is written to before it's applied.
+	journal journal
+}
+
+// journal persists the changes made to a memoryStorage. Its methods are called while the storage is
+// locked for writing.
+type journal interface {
+	// append durably records the given changes, either all of them or none of them. If it returns an error,
+	// the changes are not applied.
+	append(changes ...journalChange) error
+
+	// compact is called after a change is applied, giving the journal a chance to discard stale records.
+	compact() error
+}
+
+// journalChange is a change that is recorded by a journal: doc is stored with id in collection, or the
+// document with id is removed from collection if doc is nil.
+type journalChange struct {
+	collection string
+	id         string
+	doc        any
+}
+
+// NewMemoryStorage creates a new, empty StatsKeeperStorage that keeps its data in memory. All data
+// is lost when the process exits.
+func NewMemoryStorage() StatsKeeperStorage {
+	return newMemoryStorage()
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		statistics: map[string]*statisticEntity{},
+		history:    map[string][]*historyEvent{},
+		users:      map[string]*userEntity{},
+		sessions:   map[string]*sessionEntity{},
+		apiKeys:    map[string]*apiKeyEntity{},
+	}
+}
+
+func (s *memoryStorage) CreateStatistic(ctx context.Context, entity *statspb.StatisticEntity) (*statspb.StatisticEntity, error) {
+	if err := validateComponent(entity); err != nil {
+		return nil, err
+	}
+	se := &statisticEntity{}
+	se.fromPB(entity)
+	se.Id = primitive.NewObjectID().Hex()
+	se.CreatedAt = now()
+	se.UpdatedAt = se.CreatedAt
+	se.DeletedAt = time.Time{}
+	se.Version = 1
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.commitStatistic(statspb.HistoryEvent_CREATE, createdPaths(se), nil, se.clone()); err != nil {
+		return nil, err
+	}
+	return se.toPB(), nil
+}
+
+func (s *memoryStorage) GetStatistic(ctx context.Context, userId, entityId string) (*statspb.StatisticEntity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	se, ok := s.lookupStatistic(userId, entityId)
+	if !ok || se.Deleted {
+		return nil, NewErrorNotFound(nil, "statistic not found")
+	}
+	return se.clone().toPB(), nil
+}
+
+func (s *memoryStorage) UpdateStatistic(ctx context.Context, userId string, fields []string, values *statspb.StatisticEntity, expectedVersion int64) (*statspb.StatisticEntity, error) {
+	return s.modifyStatistic(statspb.HistoryEvent_UPDATE, userId, values.Id, expectedVersion, func(se *statisticEntity) ([]string, error) {
+		return se.update(fields, values)
+	})
+}
+
+func (s *memoryStorage) DeleteStatistic(ctx context.Context, userId, entityId string, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.lookupStatistic(userId, entityId)
+	if !ok || stored.Deleted {
+		return NewErrorNotFound(nil, "statistic not found")
+	}
+	if expectedVersion != 0 && stored.Version != expectedVersion {
+		return NewErrorConflict(nil, "statistic version is not %d", expectedVersion)
+	}
+	se := stored.clone()
+	se.Deleted = true
+	se.DeletedAt = now()
+	se.UpdatedAt = se.DeletedAt
+	se.Version++
+	return s.commitStatistic(statspb.HistoryEvent_DELETE, nil, stored, se)
+}
+
+func (s *memoryStorage) IncrementCounter(ctx context.Context, userId, entityId string, delta int64) (*statspb.StatisticEntity, error) {
+	if delta == 0 {
+		return nil, NewErrorNoUpdate(nil, "no update possible")
+	}
+
+	return s.modifyStatistic(statspb.HistoryEvent_UPDATE, userId, entityId, 0, func(se *statisticEntity) ([]string, error) {
+		return []string{"counter"}, incrementCounter(se, delta)
+	})
+}
+
+func (s *memoryStorage) AppendTimestamps(ctx context.Context, userId, entityId string, timestamps []*timestamppb.Timestamp, unique bool) (*statspb.StatisticEntity, error) {
+	if len(timestamps) == 0 {
+		return nil, NewErrorNoUpdate(nil, "no update possible")
+	}
+	if err := validateTimestamps(timestamps...); err != nil {
+		return nil, err
+	}
+
+	return s.modifyStatistic(statspb.HistoryEvent_UPDATE, userId, entityId, 0, func(se *statisticEntity) ([]string, error) {
+		return []string{"date"}, appendTimestampsTo(se, timestamps, unique)
+	})
+}
+
+func (s *memoryStorage) RemoveTimestamps(ctx context.Context, userId, entityId string, timestamps []*timestamppb.Timestamp) (*statspb.StatisticEntity, error) {
+	if len(timestamps) == 0 {
+		return nil, NewErrorNoUpdate(nil, "no update possible")
+	}
+	if err := validateTimestamps(timestamps...); err != nil {
+		return nil, err
+	}
+
+	return s.modifyStatistic(statspb.HistoryEvent_UPDATE, userId, entityId, 0, func(se *statisticEntity) ([]string, error) {
+		return []string{"date"}, removeTimestampsFrom(se, equalToAny(timestamps))
+	})
+}
+
+func (s *memoryStorage) RemoveTimestampRange(ctx context.Context, userId, entityId string, from, to *timestamppb.Timestamp) (*statspb.StatisticEntity, error) {
+	if err := validateTimestamps(from, to); err != nil {
+		return nil, err
+	}
+	if compareTimestamps(from, to) > 0 {
+		return nil, NewErrorInvalidField("from", nil, "from cannot be after to")
+	}
+
+	return s.modifyStatistic(statspb.HistoryEvent_UPDATE, userId, entityId, 0, func(se *statisticEntity) ([]string, error) {
+		return []string{"date"}, removeTimestampsFrom(se, inRange(from, to))
+	})
+}
+
+func (s *memoryStorage) ListUserStatistics(ctx context.Context, req *statspb.ListUserStatisticsRequest) (*statspb.ListUserStatisticsResponse, error) {
+	q, err := newListQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entities := []*statisticEntity{}
+	for _, se := range s.statistics {
+		if q.matches(se) {
+			entities = append(entities, se)
+		}
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		return q.less(entities[i], entities[j])
+	})
+	if len(entities) > q.pageSize+1 {
+		entities = entities[:q.pageSize+1]
+	}
+	for i, se := range entities {
+		entities[i] = se.clone()
+	}
+	return q.response(entities)
+}
+
+func (s *memoryStorage) RestoreStatistic(ctx context.Context, userId, entityId string) (*statspb.StatisticEntity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.lookupStatistic(userId, entityId)
+	if !ok || !stored.Deleted {
+		return nil, NewErrorNotFound(nil, "deleted statistic not found")
+	}
+	se := stored.clone()
+	se.Deleted = false
+	se.DeletedAt = time.Time{}
+	se.UpdatedAt = now()
+	se.Version++
+	if err := s.commitStatistic(statspb.HistoryEvent_RESTORE, nil, stored, se.clone()); err != nil {
+		return nil, err
+	}
+	return se.toPB(), nil
+}
+
+func (s *memoryStorage) PurgeStatistic(ctx context.Context, userId, entityId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.lookupStatistic(userId, entityId)
+	if !ok || !stored.Deleted {
+		return NewErrorNotFound(nil, "deleted statistic not found")
+	}
+	// the history of the entity is kept
+	return removeJournaled(s, statisticsCollectionName, s.statistics, entityId)
+}
+
+func (s *memoryStorage) PurgeDeletedStatistics(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := int64(0)
+	for id, se := range s.statistics {
+		if !se.Deleted || se.DeletedAt.IsZero() || !se.DeletedAt.Before(before) {
+			continue
+		}
+		if err := removeJournaled(s, statisticsCollectionName, s.statistics, id); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (s *memoryStorage) ListHistory(ctx context.Context, userId string, req *statspb.ListHistoryRequest) (*statspb.ListHistoryResponse, error) {
+	q, err := newHistoryQuery(userId, req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []*historyEvent{}
+	history := s.history[req.EntityId]
+	for i := len(history) - 1; i >= 0 && len(events) <= q.pageSize; i-- {
+		if q.matches(history[i]) {
+			events = append(events, history[i])
+		}
+	}
+	// events are never modified, they can be converted without copying them
+	return q.response(events)
+}
+
+func (s *memoryStorage) RevertStatistic(ctx context.Context, userId string, req *statspb.RevertStatisticRequest) (*statspb.StatisticEntity, error) {
+	if err := validateRevertRequest(req); err != nil {
+		return nil, err
+	}
+
+	return s.modifyStatistic(statspb.HistoryEvent_REVERT, userId, req.EntityId, req.ExpectedVersion, func(se *statisticEntity) ([]string, error) {
+		history := s.history[se.Id]
+		for i := len(history) - 1; i >= 0; i-- {
+			if isRevision(history[i], req) {
+				return se.revert(history[i].New)
+			}
+		}
+		return nil, NewErrorNotFound(nil, "revision not found")
+	})
+}
+
+// Ping implements StatsKeeperStorage. The memory is always available.
+func (s *memoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close implements StatsKeeperStorage. There is nothing to release, the data is dropped with the storage.
+func (s *memoryStorage) Close(ctx context.Context) error {
+	return nil
+}
+
+// modifyStatistic calls modify with a copy of the entity of userId specified by entityId and stores the copy with an
+// updated UpdatedAt and Version, unless modify returns an error. modify is called with the write lock held
+// and returns the paths of the fields it changes, which are recorded in the history as a change of the
+// given type. It returns the modified entity. If expectedVersion is not zero and the entity has another
+// version, it returns a CONFLICT error.
+func (s *memoryStorage) modifyStatistic(typ statspb.HistoryEvent_Type, userId, entityId string, expectedVersion int64, modify func(se *statisticEntity) ([]string, error)) (*statspb.StatisticEntity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.lookupStatistic(userId, entityId)
+	if !ok || stored.Deleted {
+		return nil, NewErrorNotFound(nil, "statistic not found")
+	}
+	if expectedVersion != 0 && stored.Version != expectedVersion {
+		return nil, NewErrorConflict(nil, "statistic version is not %d", expectedVersion)
+	}
+	se := stored.clone()
+	paths, err := modify(se)
+	if err != nil {
+		return nil, err
+	}
+	se.UpdatedAt = now()
+	se.Version++
+	// modify may have set values that belong to the caller, store a copy
+	if err := s.commitStatistic(typ, paths, stored, se.clone()); err != nil {
+		return nil, err
+	}
+	return se.toPB(), nil
+}
+
+// lookupStatistic returns the stored entity specified by entityId, including a deleted one, if it belongs to
+// userId. The caller must hold the lock.
+func (s *memoryStorage) lookupStatistic(userId, entityId string) (*statisticEntity, bool) {
+	se, ok := s.statistics[entityId]
+	if !ok || se.UserId != userId {
+		return nil, false
+	}
+	return se, true
+}
+
+// commitStatistic stores se, which is the result of a change of the given type to old, and records the
+// change in the history. Both are written to the journal at once if there is one, so that a change is never
+// persisted without its history event. The caller must hold the write lock and must not modify old or se
+// afterwards.
+func (s *memoryStorage) commitStatistic(typ statspb.HistoryEvent_Type, paths []string, old, se *statisticEntity) error {
+	he := newHistoryEvent(typ, paths, old, se)
+	apply := func() {
+		s.statistics[se.Id] = se
+		s.history[he.EntityId] = append(s.history[he.EntityId], he)
+	}
+	return s.write(apply, journalChange{statisticsCollectionName, se.Id, se}, journalChange{historyCollectionName, he.Id, he})
+}
+
+// write writes changes to the journal if there is one, and then applies them to the in-memory data with apply.
+// If the changes cannot be written, they're not applied and an INTERNAL error is returned. The caller must hold
+// the write lock.
+func (s *memoryStorage) write(apply func(), changes ...journalChange) error {
+	if s.journal != nil {
+		if err := s.journal.append(changes...); err != nil {
+			return NewErrorInternal(err, "error writing to %s", changes[0].collection)
+		}
+	}
+	apply()
+	if s.journal != nil {
+		// the changes are already durable, a failed compaction is tried again with the next change
+		_ = s.journal.compact()
+	}
+	return nil
+}
+
+// putJournaled stores doc with id in docs, which are the documents of collection, after writing it to the
+// journal of s if there is one. The caller must hold the write lock and must not modify doc afterwards.
+func putJournaled[V any](s *memoryStorage, collection string, docs map[string]V, id string, doc V) error {
+	return s.write(func() { docs[id] = doc }, journalChange{collection, id, doc})
+}
+
+// removeJournaled removes the document with id from docs, which are the documents of collection, after writing
+// the removal to the journal of s if there is one. The caller must hold the write lock.
+func removeJournaled[V any](s *memoryStorage, collection string, docs map[string]V, id string) error {
+	return s.write(func() { delete(docs, id) }, journalChange{collection, id, nil})
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_memoryStorage_Conformance(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) StatsKeeperStorage {
+		return NewMemoryStorage()
+	})
+}
+
+func Test_memoryStorage_Isolation(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.TODO()
+
+	in := newTestDateEntity("user-1", "entity-1", &timestamppb.Timestamp{Seconds: 1, Nanos: 1})
+	created := createTestEntity(t, s, in)
+
+	// modifying the given or returned entities must not modify the stored one
+	in.GetDate().Timestamps[0].Seconds = 100
+	created.GetDate().Timestamps[0].Seconds = 100
+	got, err := s.GetStatistic(ctx, created.UserId, created.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	got.GetDate().Timestamps[0].Seconds = 100
+
+	got, err = s.GetStatistic(ctx, created.UserId, created.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	if got.GetDate().Timestamps[0].Seconds != 1 {
+		t.Fatalf("stored entity was modified through a given or returned entity")
+	}
+}
+
+func Test_memoryStorage_Concurrency(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.TODO()
+
+	// every goroutine increments the shared counter, none of the increments should be lost
+	shared := createTestEntity(t, s, newTestCounterEntity("user-2", "shared", 0))
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			created, err := s.CreateStatistic(ctx, newTestCounterEntity("user-1", "entity", 1))
+			if err != nil {
+				t.Errorf("CreateStatistic returned unexpected error: %v", err)
+				return
+			}
+			if _, err = s.UpdateStatistic(ctx, "user-1", []string{"name"}, &statspb.StatisticEntity{Id: created.Id, Name: "updated"}, 0); err != nil {
+				t.Errorf("UpdateStatistic returned unexpected error: %v", err)
+			}
+			if _, err = s.IncrementCounter(ctx, shared.UserId, shared.Id, 1); err != nil {
+				t.Errorf("IncrementCounter returned unexpected error: %v", err)
+			}
+			if _, err = s.ListUserStatistics(ctx, &statspb.ListUserStatisticsRequest{UserId: "user-1"}); err != nil {
+				t.Errorf("ListUserStatistics returned unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	list, err := s.ListUserStatistics(ctx, &statspb.ListUserStatisticsRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("ListUserStatistics returned unexpected error: %v", err)
+	}
+	if len(list.Entities) != n {
+		t.Fatalf("wrong number of entities: expected=%d, got=%d", n, len(list.Entities))
+	}
+
+	got, err := s.GetStatistic(ctx, shared.UserId, shared.Id)
+	if err != nil {
+		t.Fatalf("GetStatistic returned unexpected error: %v", err)
+	}
+	if got.GetCounter().GetCount() != n {
+		t.Fatalf("wrong count: expected=%d, got=%d", n, got.GetCounter().GetCount())
+	}
+}
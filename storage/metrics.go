@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// metricsStorage is a StatsKeeperStorage that delegates to another one, and records the latency of its methods
+// and the errors they return.
+type metricsStorage struct {
+	db StatsKeeperStorage
+	// duration is the latency of the methods by method.
+	duration *prometheus.HistogramVec
+	// errors is the number of errors returned by the methods by method and storageErrorType.
+	errors *prometheus.CounterVec
+}
+
+// NewMetricsStorage returns a StatsKeeperStorage that delegates to db, and records the latency of its methods and
+// the errors they return by storageErrorType to metrics registered to reg. Errors that are not storage errors
+// are recorded as UNKNOWN.
+func NewMetricsStorage(db StatsKeeperStorage, reg prometheus.Registerer) (StatsKeeperStorage, error) {
+	s := &metricsStorage{
+		db: db,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "stats_keeper",
+			Subsystem: "storage",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the storage operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "stats_keeper",
+			Subsystem: "storage",
+			Name:      "errors_total",
+			Help:      "Number of errors returned by the storage operations.",
+		}, []string{"method", "type"}),
+	}
+	for _, c := range []prometheus.Collector{s.duration, s.errors} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// observe records a call to method that started at start and returned err.
+func (s *metricsStorage) observe(method string, start time.Time, err error) {
+	s.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		var typ storageErrorType
+		if se, ok := err.(*storageError); ok {
+			typ = se.Type
+		}
+		s.errors.WithLabelValues(method, typ.String()).Inc()
+	}
+}
+
+func (s *metricsStorage) CreateStatistic(ctx context.Context, entity *statspb.StatisticEntity) (*statspb.StatisticEntity, error) {
+	start := time.Now()
+	out, err := s.db.CreateStatistic(ctx, entity)
+	s.observe("CreateStatistic", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) GetStatistic(ctx context.Context, userId, entityId string) (*statspb.StatisticEntity, error) {
+	start := time.Now()
+	out, err := s.db.GetStatistic(ctx, userId, entityId)
+	s.observe("GetStatistic", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) UpdateStatistic(ctx context.Context, userId string, fields []string, values *statspb.StatisticEntity, expectedVersion int64) (*statspb.StatisticEntity, error) {
+	start := time.Now()
+	out, err := s.db.UpdateStatistic(ctx, userId, fields, values, expectedVersion)
+	s.observe("UpdateStatistic", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) DeleteStatistic(ctx context.Context, userId, entityId string, expectedVersion int64) error {
+	start := time.Now()
+	err := s.db.DeleteStatistic(ctx, userId, entityId, expectedVersion)
+	s.observe("DeleteStatistic", start, err)
+	return err
+}
+
+func (s *metricsStorage) IncrementCounter(ctx context.Context, userId, entityId string, delta int64) (*statspb.StatisticEntity, error) {
+	start := time.Now()
+	out, err := s.db.IncrementCounter(ctx, userId, entityId, delta)
+	s.observe("IncrementCounter", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) AppendTimestamps(ctx context.Context, userId, entityId string, timestamps []*timestamppb.Timestamp, unique bool) (*statspb.StatisticEntity, error) {
+	start := time.Now()
+	out, err := s.db.AppendTimestamps(ctx, userId, entityId, timestamps, unique)
+	s.observe("AppendTimestamps", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) RemoveTimestamps(ctx context.Context, userId, entityId string, timestamps []*timestamppb.Timestamp) (*statspb.StatisticEntity, error) {
+	start := time.Now()
+	out, err := s.db.RemoveTimestamps(ctx, userId, entityId, timestamps)
+	s.observe("RemoveTimestamps", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) RemoveTimestampRange(ctx context.Context, userId, entityId string, from, to *timestamppb.Timestamp) (*statspb.StatisticEntity, error) {
+	start := time.Now()
+	out, err := s.db.RemoveTimestampRange(ctx, userId, entityId, from, to)
+	s.observe("RemoveTimestampRange", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) ListUserStatistics(ctx context.Context, req *statspb.ListUserStatisticsRequest) (*statspb.ListUserStatisticsResponse, error) {
+	start := time.Now()
+	out, err := s.db.ListUserStatistics(ctx, req)
+	s.observe("ListUserStatistics", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) RestoreStatistic(ctx context.Context, userId, entityId string) (*statspb.StatisticEntity, error) {
+	start := time.Now()
+	out, err := s.db.RestoreStatistic(ctx, userId, entityId)
+	s.observe("RestoreStatistic", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) PurgeStatistic(ctx context.Context, userId, entityId string) error {
+	start := time.Now()
+	err := s.db.PurgeStatistic(ctx, userId, entityId)
+	s.observe("PurgeStatistic", start, err)
+	return err
+}
+
+func (s *metricsStorage) PurgeDeletedStatistics(ctx context.Context, before time.Time) (int64, error) {
+	start := time.Now()
+	out, err := s.db.PurgeDeletedStatistics(ctx, before)
+	s.observe("PurgeDeletedStatistics", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) ListHistory(ctx context.Context, userId string, req *statspb.ListHistoryRequest) (*statspb.ListHistoryResponse, error) {
+	start := time.Now()
+	out, err := s.db.ListHistory(ctx, userId, req)
+	s.observe("ListHistory", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) RevertStatistic(ctx context.Context, userId string, req *statspb.RevertStatisticRequest) (*statspb.StatisticEntity, error) {
+	start := time.Now()
+	out, err := s.db.RevertStatistic(ctx, userId, req)
+	s.observe("RevertStatistic", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.db.Ping(ctx)
+	s.observe("Ping", start, err)
+	return err
+}
+
+func (s *metricsStorage) Close(ctx context.Context) error {
+	start := time.Now()
+	err := s.db.Close(ctx)
+	s.observe("Close", start, err)
+	return err
+}
+
+func (s *metricsStorage) CreateUser(ctx context.Context, username string, passwordHash []byte) (*statspb.User, error) {
+	start := time.Now()
+	out, err := s.db.CreateUser(ctx, username, passwordHash)
+	s.observe("CreateUser", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) GetUserCredentials(ctx context.Context, username string) (*UserCredentials, error) {
+	start := time.Now()
+	out, err := s.db.GetUserCredentials(ctx, username)
+	s.observe("GetUserCredentials", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) RecordLoginFailure(ctx context.Context, userId string, maxFailures int, lockout time.Duration) error {
+	start := time.Now()
+	err := s.db.RecordLoginFailure(ctx, userId, maxFailures, lockout)
+	s.observe("RecordLoginFailure", start, err)
+	return err
+}
+
+func (s *metricsStorage) RecordLoginSuccess(ctx context.Context, userId string) error {
+	start := time.Now()
+	err := s.db.RecordLoginSuccess(ctx, userId)
+	s.observe("RecordLoginSuccess", start, err)
+	return err
+}
+
+func (s *metricsStorage) CreateSession(ctx context.Context, userId, tokenHash string, expiresAt time.Time) error {
+	start := time.Now()
+	err := s.db.CreateSession(ctx, userId, tokenHash, expiresAt)
+	s.observe("CreateSession", start, err)
+	return err
+}
+
+func (s *metricsStorage) GetSession(ctx context.Context, tokenHash string) (string, error) {
+	start := time.Now()
+	out, err := s.db.GetSession(ctx, tokenHash)
+	s.observe("GetSession", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) DeleteSession(ctx context.Context, tokenHash string) error {
+	start := time.Now()
+	err := s.db.DeleteSession(ctx, tokenHash)
+	s.observe("DeleteSession", start, err)
+	return err
+}
+
+func (s *metricsStorage) CreateApiKey(ctx context.Context, key *statspb.ApiKey, keyHash string) (*statspb.ApiKey, error) {
+	start := time.Now()
+	out, err := s.db.CreateApiKey(ctx, key, keyHash)
+	s.observe("CreateApiKey", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) GetApiKey(ctx context.Context, keyHash string) (*statspb.ApiKey, error) {
+	start := time.Now()
+	out, err := s.db.GetApiKey(ctx, keyHash)
+	s.observe("GetApiKey", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) ListApiKeys(ctx context.Context, userId string) ([]*statspb.ApiKey, error) {
+	start := time.Now()
+	out, err := s.db.ListApiKeys(ctx, userId)
+	s.observe("ListApiKeys", start, err)
+	return out, err
+}
+
+func (s *metricsStorage) RevokeApiKey(ctx context.Context, userId, keyId string) error {
+	start := time.Now()
+	err := s.db.RevokeApiKey(ctx, userId, keyId)
+	s.observe("RevokeApiKey", start, err)
+	return err
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/umutozd/stats-keeper/protos/statspb"
+)
+
+func Test_MetricsStorage(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	db, err := NewMetricsStorage(NewMemoryStorage(), reg)
+	if err != nil {
+		t.Fatalf("error creating metrics storage: %v", err)
+	}
+	s := db.(*metricsStorage)
+	ctx := context.Background()
+
+	entity, err := db.CreateStatistic(ctx, &statspb.StatisticEntity{
+		UserId:    "user",
+		Name:      "counter",
+		Component: &statspb.StatisticEntity_Counter{Counter: &statspb.ComponentCounter{}},
+	})
+	if err != nil {
+		t.Fatalf("error creating statistic: %v", err)
+	}
+	if _, err = db.GetStatistic(ctx, "user", entity.Id); err != nil {
+		t.Fatalf("error getting statistic: %v", err)
+	}
+	_, err = db.GetStatistic(ctx, "user", "missing")
+	compareErrors(t, NewErrorNotFound(nil, "statistic not found"), err)
+	_, err = db.IncrementCounter(ctx, "user", entity.Id, -1)
+	compareErrors(t, NewErrorInvalidArgument(nil, "count cannot go below zero"), err)
+
+	if n := testutil.CollectAndCount(s.duration, "stats_keeper_storage_operation_duration_seconds"); n != 3 {
+		t.Fatalf("wrong number of observed methods: expected=3, got=%d", n)
+	}
+	for _, c := range []struct {
+		method, typ string
+		count       float64
+	}{
+		{"CreateStatistic", "NOT_FOUND", 0},
+		{"GetStatistic", "NOT_FOUND", 1},
+		{"IncrementCounter", "INVALID_ARGUMENT", 1},
+	} {
+		if got := testutil.ToFloat64(s.errors.WithLabelValues(c.method, c.typ)); got != c.count {
+			t.Fatalf("wrong number of %s errors of %s: expected=%v, got=%v", c.typ, c.method, c.count, got)
+		}
+	}
+
+	if _, err = NewMetricsStorage(NewMemoryStorage(), reg); err == nil {
+		t.Fatalf("registering the metrics twice did not fail")
+	}
+}
@@ -2,35 +2,132 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	neturl "net/url"
+	"time"
 
 	"github.com/umutozd/stats-keeper/protos/statspb"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
 	defaultDatabaseName      = "StatsKeeper"
 	statisticsCollectionName = "Statistics"
+
+	// mongoConnectTimeout is how long newMongoStorage waits for the MongoDB server to respond.
+	mongoConnectTimeout = 10 * time.Second
 )
 
-// StatsKeeperStorage is the inteface that server will use to interact with the database.
+// timeNow returns the current time. Tests replace it to control the timestamps set by the storage.
+var timeNow = time.Now
+
+// now returns the current time, as precise as it can be stored in MongoDB.
+func now() time.Time {
+	return timeNow().UTC().Truncate(time.Millisecond)
+}
+
+// StatsKeeperStorage is the inteface that server will use to interact with the database. Methods return
+// errors created by one of the NewError* functions so that callers can tell failures apart.
+//
+// The methods that access an entity by its id take the id of the user that the caller acts on behalf of, and
+// only access the entities that belong to that user. Entities of other users are reported as NOT_FOUND, as if
+// they don't exist, so that their ids cannot be discovered.
 type StatsKeeperStorage interface {
-	// CreateStatistic inserts the given entity to the database after initializing some of its data, such as Id.
+	// CreateStatistic inserts the given entity to the database after initializing some of its data, such as Id,
+	// CreatedAt and Version. Any Id, timestamp or version given in entity is overridden. If entity doesn't have a
+	// registered Component or its component is invalid, an INVALID_ARGUMENT error is returned.
 	CreateStatistic(ctx context.Context, entity *statspb.StatisticEntity) (*statspb.StatisticEntity, error)
 
-	// GetStatistic finds and returns the entity specified by entityId.
-	GetStatistic(ctx context.Context, entityId string) (*statspb.StatisticEntity, error)
+	// GetStatistic finds and returns the entity specified by entityId. If there is no such entity or it's
+	// deleted, a NOT_FOUND error is returned.
+	GetStatistic(ctx context.Context, userId, entityId string) (*statspb.StatisticEntity, error)
 
 	// UpdateStatistic updates the entity specified by values.Id, using fields. Each element in fields specify which
-	// field to update in the entity. Immutable fields such as Id or UserId are ignored. If no possible update is found,
-	// ErrNoUpdatePossible is returned.
-	UpdateStatistic(ctx context.Context, fields []string, values *statspb.StatisticEntity) (*statspb.StatisticEntity, error)
+	// field to update in the entity. Immutable fields such as Id or UserId cannot be updated, nor can the type of the
+	// entity's component be changed; both result in an INVALID_ARGUMENT error. So do the fields that are managed by
+	// the storage, such as UpdatedAt, which is set to the time of the update. Unknown fields and component fields
+	// that are not set in values are ignored. If no possible update is found, a NO_UPDATE error is returned.
+	//
+	// Every modification of an entity, including the ones made by the other methods, increments its Version. If
+	// expectedVersion is not zero and the entity has another version, a CONFLICT error is returned. Concurrent
+	// updates never overwrite each other; if the entity is modified while it's being updated, a CONFLICT error is
+	// returned as well.
+	UpdateStatistic(ctx context.Context, userId string, fields []string, values *statspb.StatisticEntity, expectedVersion int64) (*statspb.StatisticEntity, error)
+
+	// DeleteStatistic deletes the entity from database so that it cannot be found by any other CRUD method. The
+	// DeletedAt of the entity is set to the time of the deletion. If expectedVersion is not zero and the entity
+	// has another version, a CONFLICT error is returned. Deleting an entity that is already deleted returns a
+	// NOT_FOUND error.
+	DeleteStatistic(ctx context.Context, userId, entityId string, expectedVersion int64) error
+
+	// IncrementCounter atomically adds delta to the count of the ComponentCounter of the entity specified by
+	// entityId and returns the updated entity. If the entity doesn't have a ComponentCounter, or the count
+	// would go below zero or overflow, an INVALID_ARGUMENT error is returned and the count is not changed.
+	IncrementCounter(ctx context.Context, userId, entityId string, delta int64) (*statspb.StatisticEntity, error)
+
+	// AppendTimestamps atomically adds timestamps to the ComponentDate of the entity specified by entityId and
+	// returns the updated entity. The timestamps of the component are kept sorted. If unique is true, only one of
+	// the equal timestamps is kept, including the ones that were already in the component. If the entity doesn't
+	// have a ComponentDate or any of the timestamps is invalid, an INVALID_ARGUMENT error is returned.
+	AppendTimestamps(ctx context.Context, userId, entityId string, timestamps []*timestamppb.Timestamp, unique bool) (*statspb.StatisticEntity, error)
+
+	// RemoveTimestamps atomically removes every occurrence of the given timestamps from the ComponentDate of
+	// the entity specified by entityId and returns the updated entity.
+	RemoveTimestamps(ctx context.Context, userId, entityId string, timestamps []*timestamppb.Timestamp) (*statspb.StatisticEntity, error)
+
+	// RemoveTimestampRange atomically removes the timestamps between from and to, both inclusive, from the
+	// ComponentDate of the entity specified by entityId and returns the updated entity.
+	RemoveTimestampRange(ctx context.Context, userId, entityId string, from, to *timestamppb.Timestamp) (*statspb.StatisticEntity, error)
 
-	// DeleteStatistic deletes the entity from database so that it cannot be found by any other CRUD method.
-	DeleteStatistic(ctx context.Context, entityId string) error
+	// ListUserStatistics returns a page of the entities belonging to the user specified by req.UserId, which are
+	// filtered and ordered as specified by req. Deleted entities are included only if req.Deleted is true, and
+	// then they're the only ones that are included. If there are more entities
+	// after the page, the response has a token for the next page. If any of the fields of req is invalid, an
+	// INVALID_ARGUMENT error is returned.
+	ListUserStatistics(ctx context.Context, req *statspb.ListUserStatisticsRequest) (*statspb.ListUserStatisticsResponse, error)
 
-	// ListUserStatistics returns a slice of entities belonging to the user specified by userId.
-	ListUserStatistics(ctx context.Context, userId string) ([]*statspb.StatisticEntity, error)
+	// RestoreStatistic restores the deleted entity specified by entityId, so that it can be found by the other
+	// CRUD methods again, and returns it. If there is no such entity or it's not deleted, a NOT_FOUND error is
+	// returned.
+	RestoreStatistic(ctx context.Context, userId, entityId string) (*statspb.StatisticEntity, error)
+
+	// PurgeStatistic permanently removes the deleted entity specified by entityId from the database. If there is
+	// no such entity or it's not deleted, a NOT_FOUND error is returned.
+	PurgeStatistic(ctx context.Context, userId, entityId string) error
+
+	// PurgeDeletedStatistics permanently removes the entities that are deleted before the given time, and returns
+	// the number of removed entities. Entities that are deleted before DeletedAt is introduced are kept, since
+	// the time of their deletion is unknown.
+	PurgeDeletedStatistics(ctx context.Context, before time.Time) (int64, error)
+
+	// ListHistory returns a page of the history of the entity specified by req.EntityId, from its latest change
+	// to its creation. Every change of an entity, made by any of the methods above, is recorded as an immutable
+	// event with the paths of the changed fields and the entity before and after the change. The history of an
+	// entity is kept after it's purged. If there are more events after the page, the response has a token for
+	// the next page. If any of the fields of req is invalid, an INVALID_ARGUMENT error is returned.
+	ListHistory(ctx context.Context, userId string, req *statspb.ListHistoryRequest) (*statspb.ListHistoryResponse, error)
+
+	// RevertStatistic sets the name and the component of the entity specified by req.EntityId to the ones it had
+	// in the version specified by req.Version, or at the time specified by req.Timestamp, which are looked up in
+	// its history. The revert is an update like the ones of UpdateStatistic; it increments the version of the
+	// entity, is recorded in the history as a REVERT event, and is conditional if req.ExpectedVersion is not
+	// zero. If the entity or the requested version doesn't exist, a NOT_FOUND error is returned. If the entity
+	// already has the name and the component of that version, a NO_UPDATE error is returned.
+	RevertStatistic(ctx context.Context, userId string, req *statspb.RevertStatisticRequest) (*statspb.StatisticEntity, error)
+
+	// Ping checks that the storage can serve requests, e.g. that the database server is reachable. If it cannot,
+	// an INTERNAL error is returned.
+	Ping(ctx context.Context) error
+
+	// Close releases the resources of the storage, e.g. the connections to the database server, after the
+	// ongoing operations finish or ctx is done. The storage cannot be used after it's closed.
+	Close(ctx context.Context) error
+
+	UserStorage
+	ApiKeyStorage
 }
 
 // storage is the internal type that implements StatsKeeperStorage.
@@ -38,20 +135,81 @@ type storage struct {
 	cli *mongo.Client
 }
 
-// NewStatsKeeperStorage creates a new StatsKeeperStorage by initializing connection to
-// the database server at the given url. Any error during initialization is returned.
+// NewStatsKeeperStorage creates a new StatsKeeperStorage for the database at the given url. The
+// implementation is chosen by the scheme of url:
+//   - mongodb, mongodb+srv: connects to the MongoDB server at url.
+//   - file: keeps the data in the local file at the path of url, e.g. file:///var/lib/statskeeper.db.
+//
+// Any error during initialization is returned.
 func NewStatsKeeperStorage(url string) (StatsKeeperStorage, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database url: %w", err)
+	}
+
+	switch u.Scheme {
+	case "mongodb", "mongodb+srv":
+		return newMongoStorage(url)
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("invalid database url: file url cannot have a remote host %q", u.Host)
+		}
+		path := u.Path
+		if u.Opaque != "" {
+			// relative paths such as file:stats.db
+			path = u.Opaque
+		}
+		return newFileStorage(path)
+	default:
+		return nil, fmt.Errorf("invalid database url: unsupported scheme %q", u.Scheme)
+	}
+}
+
+// newMongoStorage creates a new StatsKeeperStorage by initializing connection to the MongoDB server
+// at the given url.
+func newMongoStorage(url string) (StatsKeeperStorage, error) {
 	cli, err := mongo.Connect(context.Background(), options.Client().ApplyURI(url))
 	if err != nil {
 		return nil, err
 	}
 
-	return &storage{
+	// Connect doesn't wait for the server, so an unreachable one would only be noticed by the first request
+	s := &storage{
 		cli: cli,
-	}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoConnectTimeout)
+	defer cancel()
+	if err = s.Ping(ctx); err != nil {
+		_ = cli.Disconnect(context.Background())
+		return nil, err
+	}
+	if err = s.createUserIndexes(ctx); err != nil {
+		_ = cli.Disconnect(context.Background())
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *storage) Close(ctx context.Context) error {
+	if err := s.cli.Disconnect(ctx); err != nil {
+		return NewErrorInternal(err, "error disconnecting from database")
+	}
+	return nil
+}
+
+func (s *storage) Ping(ctx context.Context) error {
+	if err := s.cli.Ping(ctx, readpref.Primary()); err != nil {
+		return NewErrorInternal(err, "database is unreachable")
+	}
+	return nil
 }
 
 // statistics returns a handle to the statistics collection in MongoDB
 func (s *storage) statistics() *mongo.Collection {
 	return s.cli.Database(defaultDatabaseName).Collection(statisticsCollectionName)
 }
+
+// history returns a handle to the history collection in MongoDB
+func (s *storage) history() *mongo.Collection {
+	return s.cli.Database(defaultDatabaseName).Collection(historyCollectionName)
+}
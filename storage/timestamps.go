@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// dateComponent returns the ComponentDate of entity. It returns an INVALID_ARGUMENT error if entity
+// doesn't have a ComponentDate.
+func dateComponent(entity *statspb.StatisticEntity) (*statspb.ComponentDate, error) {
+	date := entity.GetDate()
+	if date == nil {
+		return nil, NewErrorInvalidArgument(nil, "component must be %s, not %s", statspb.ComponentType_DATE, entity.GetComponentType())
+	}
+	return date, nil
+}
+
+// validateTimestamps returns an INVALID_ARGUMENT error if any of the given timestamps is invalid.
+func validateTimestamps(timestamps ...*timestamppb.Timestamp) error {
+	for _, ts := range timestamps {
+		if err := ts.CheckValid(); err != nil {
+			return NewErrorInvalidArgument(err, "invalid timestamp")
+		}
+	}
+	return nil
+}
+
+// compareTimestamps returns -1, 0 or 1 if a is before, equal to or after b, respectively.
+func compareTimestamps(a, b *timestamppb.Timestamp) int {
+	switch {
+	case a.GetSeconds() < b.GetSeconds():
+		return -1
+	case a.GetSeconds() > b.GetSeconds():
+		return 1
+	case a.GetNanos() < b.GetNanos():
+		return -1
+	case a.GetNanos() > b.GetNanos():
+		return 1
+	default:
+		return 0
+	}
+}
+
+// appendTimestamps returns the sorted result of adding timestamps to existing. If unique is true, only
+// one of the equal timestamps is kept.
+func appendTimestamps(existing, timestamps []*timestamppb.Timestamp, unique bool) []*timestamppb.Timestamp {
+	result := make([]*timestamppb.Timestamp, 0, len(existing)+len(timestamps))
+	result = append(result, existing...)
+	result = append(result, timestamps...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return compareTimestamps(result[i], result[j]) < 0
+	})
+	if !unique {
+		return result
+	}
+
+	deduplicated := result[:0]
+	for i, ts := range result {
+		if i == 0 || compareTimestamps(ts, result[i-1]) != 0 {
+			deduplicated = append(deduplicated, ts)
+		}
+	}
+	return deduplicated
+}
+
+// removeTimestamps returns the timestamps in existing for which remove returns false, keeping their order.
+func removeTimestamps(existing []*timestamppb.Timestamp, remove func(ts *timestamppb.Timestamp) bool) []*timestamppb.Timestamp {
+	result := []*timestamppb.Timestamp{}
+	for _, ts := range existing {
+		if !remove(ts) {
+			result = append(result, ts)
+		}
+	}
+	return result
+}
+
+// appendTimestampsTo adds copies of timestamps to the ComponentDate of se, as described in
+// StatsKeeperStorage.AppendTimestamps. It returns an INVALID_ARGUMENT error if se doesn't have a ComponentDate.
+func appendTimestampsTo(se *statisticEntity, timestamps []*timestamppb.Timestamp, unique bool) error {
+	date, err := dateComponent(se.toPB())
+	if err != nil {
+		return err
+	}
+	added := proto.Clone(&statspb.ComponentDate{Timestamps: timestamps}).(*statspb.ComponentDate)
+	date.Timestamps = appendTimestamps(date.Timestamps, added.Timestamps, unique)
+	return nil
+}
+
+// removeTimestampsFrom removes the timestamps of the ComponentDate of se for which remove returns true. It
+// returns an INVALID_ARGUMENT error if se doesn't have a ComponentDate.
+func removeTimestampsFrom(se *statisticEntity, remove func(ts *timestamppb.Timestamp) bool) error {
+	date, err := dateComponent(se.toPB())
+	if err != nil {
+		return err
+	}
+	date.Timestamps = removeTimestamps(date.Timestamps, remove)
+	return nil
+}
+
+// equalToAny returns a function that reports whether a timestamp is equal to any of the given timestamps.
+func equalToAny(timestamps []*timestamppb.Timestamp) func(ts *timestamppb.Timestamp) bool {
+	return func(ts *timestamppb.Timestamp) bool {
+		for _, t := range timestamps {
+			if compareTimestamps(ts, t) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// inRange returns a function that reports whether a timestamp is between from and to, both inclusive.
+func inRange(from, to *timestamppb.Timestamp) func(ts *timestamppb.Timestamp) bool {
+	return func(ts *timestamppb.Timestamp) bool {
+		return compareTimestamps(ts, from) >= 0 && compareTimestamps(ts, to) <= 0
+	}
+}
@@ -3,8 +3,12 @@ package storage
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/umutozd/stats-keeper/protos/statspb"
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // statisticEntity is the internal representation of statspb.StatisticEntity. We need this type
@@ -15,25 +19,92 @@ type statisticEntity struct {
 	Name   string `bson:"name"`
 	UserId string `bson:"user_id"`
 
-	Counter *statspb.ComponentCounter `bson:"counter"`
-	Date    *statspb.ComponentDate    `bson:"date"`
+	// Component is the value of the entity's component. It's stored under the path of its registered
+	// Component, see MarshalBSON and UnmarshalBSON.
+	Component proto.Message `bson:"-"`
+
+	// CreatedAt, UpdatedAt and DeletedAt are managed by the storage. They're zero in the documents that
+	// are created before they're introduced.
+	CreatedAt time.Time `bson:"created_at,omitempty"`
+	UpdatedAt time.Time `bson:"updated_at,omitempty"`
+	DeletedAt time.Time `bson:"deleted_at,omitempty"`
+
+	// Version is incremented by every modification. It's zero in the documents that are created before
+	// it's introduced, which don't have it.
+	Version int64 `bson:"version,omitempty"`
 
 	// Deleted reports whether this entity is deleted via a db call. Instead of actual delete, this
-	// entity is marked as deleted. We may use this non-deleted entity in the future.
+	// entity is marked as deleted and kept in the trash of its user until it's restored or purged.
 	Deleted bool `bson:"deleted"`
 }
 
+// statisticEntityFields has the same fields as statisticEntity, without its bson marshal/unmarshal logic.
+type statisticEntityFields statisticEntity
+
+// MarshalBSON implements bson.Marshaler.
+func (se *statisticEntity) MarshalBSON() ([]byte, error) {
+	doc, err := bson.Marshal((*statisticEntityFields)(se))
+	if err != nil || se.Component == nil {
+		return doc, err
+	}
+
+	c := componentOfValue(se.Component)
+	if c == nil {
+		return nil, fmt.Errorf("component %T is not registered", se.Component)
+	}
+	value, err := c.Encode(se.Component)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %s component: %w", c.Type(), err)
+	}
+
+	elements, err := bson.Raw(doc).Elements()
+	if err != nil {
+		return nil, err
+	}
+	fields := make(bson.D, 0, len(elements)+1)
+	for _, e := range elements {
+		fields = append(fields, bson.E{Key: e.Key(), Value: e.Value()})
+	}
+	fields = append(fields, bson.E{Key: c.Path(), Value: value})
+	return bson.Marshal(fields)
+}
+
+// UnmarshalBSON implements bson.Unmarshaler.
+func (se *statisticEntity) UnmarshalBSON(data []byte) error {
+	if err := bson.Unmarshal(data, (*statisticEntityFields)(se)); err != nil {
+		return err
+	}
+
+	se.Component = nil
+	for _, c := range Components() {
+		raw, err := bson.Raw(data).LookupErr(c.Path())
+		if err != nil || raw.Type == bson.TypeNull {
+			// older documents have null values for the components the entity doesn't have
+			continue
+		}
+		value, err := c.Decode(raw)
+		if err != nil {
+			return fmt.Errorf("error decoding %s component: %w", c.Type(), err)
+		}
+		se.Component = value
+		break
+	}
+	return nil
+}
+
 // toPB converts this statisticEntity to *statspb.StatisticEntity.
 func (se *statisticEntity) toPB() *statspb.StatisticEntity {
 	out := &statspb.StatisticEntity{
-		Id:     se.Id,
-		Name:   se.Name,
-		UserId: se.UserId,
+		Id:        se.Id,
+		Name:      se.Name,
+		UserId:    se.UserId,
+		CreatedAt: timestampOf(se.CreatedAt),
+		UpdatedAt: timestampOf(se.UpdatedAt),
+		DeletedAt: timestampOf(se.DeletedAt),
+		Version:   se.Version,
 	}
-	if se.Counter != nil {
-		out.Component = &statspb.StatisticEntity_Counter{Counter: se.Counter}
-	} else if se.Date != nil {
-		out.Component = &statspb.StatisticEntity_Date{Date: se.Date}
+	if c := componentOfValue(se.Component); c != nil {
+		c.Set(out, se.Component)
 	}
 	return out
 }
@@ -43,19 +114,102 @@ func (se *statisticEntity) fromPB(in *statspb.StatisticEntity) {
 	se.Id = in.Id
 	se.Name = in.Name
 	se.UserId = in.UserId
+	se.CreatedAt = timeOf(in.CreatedAt)
+	se.UpdatedAt = timeOf(in.UpdatedAt)
+	se.DeletedAt = timeOf(in.DeletedAt)
+	se.Version = in.Version
+	_, se.Component = ComponentOf(in)
+}
 
-	switch comp := in.Component.(type) {
-	case *statspb.StatisticEntity_Counter:
-		se.Counter = comp.Counter
-	case *statspb.StatisticEntity_Date:
-		se.Date = comp.Date
+// timestampOf converts t to a timestamp. Zero times are converted to nil.
+func timestampOf(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
 	}
+	return timestamppb.New(t)
+}
+
+// timeOf converts ts to a time. A nil timestamp is converted to the zero time.
+func timeOf(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+// update applies the changes specified by fields and values to this statisticEntity, as described in
+// StatsKeeperStorage.UpdateStatistic, and returns the fields that are changed. It returns a NO_UPDATE
+// error if nothing is changed.
+func (se *statisticEntity) update(fields []string, values *statspb.StatisticEntity) (updated []string, err error) {
+	compType := se.componentType()
+	for _, f := range fields {
+		switch f {
+		case "id", "user_id":
+			return nil, NewErrorInvalidArgument(nil, "fields 'id', 'user_id' cannot be modified")
+		case "created_at", "updated_at", "deleted_at", "version":
+			return nil, NewErrorInvalidArgument(nil, "fields 'created_at', 'updated_at', 'deleted_at', 'version' are managed by the server")
+		case "name":
+			se.Name = values.Name
+			updated = append(updated, f)
+		default:
+			c := ComponentByPath(f)
+			if c == nil {
+				// unknown fields are ignored
+				continue
+			}
+			if c.Type() != compType {
+				return nil, NewErrorInvalidArgument(nil, "component cannot be changed from %s to %s", compType, c.Type())
+			}
+			if value := c.Get(values); value != nil {
+				if err = c.Validate(value); err != nil {
+					return nil, err
+				}
+				se.Component = c.Merge(se.Component, value)
+				updated = append(updated, f)
+			}
+		}
+	}
+	if len(updated) == 0 {
+		return nil, NewErrorNoUpdate(nil, "no update possible")
+	}
+	return updated, nil
+}
+
+// revert sets the name and the component of this statisticEntity to the ones of revision, as described in
+// StatsKeeperStorage.RevertStatistic, and returns the fields that are changed. It returns a NO_UPDATE error
+// if they're already the same.
+func (se *statisticEntity) revert(revision *statisticEntity) (updated []string, err error) {
+	var fields []string
+	if se.Name != revision.Name {
+		fields = append(fields, "name")
+	}
+	if c := componentOfValue(revision.Component); c != nil && !proto.Equal(se.Component, revision.Component) {
+		fields = append(fields, c.Path())
+	}
+	// the component of revision must not be shared with this statisticEntity
+	return se.update(fields, revision.clone().toPB())
+}
+
+// componentType returns the type of the component of this statisticEntity.
+func (se *statisticEntity) componentType() statspb.ComponentType {
+	return componentTypeOf(se.Component)
+}
+
+// clone returns a deep copy of this statisticEntity.
+func (se *statisticEntity) clone() *statisticEntity {
+	out := *se
+	if se.Component != nil {
+		out.Component = proto.Clone(se.Component)
+	}
+	return &out
 }
 
 type storageError struct {
 	Message string
 	Err     error
 	Type    storageErrorType
+	// Field is the name of the invalid field of an INVALID_ARGUMENT error, if it's known.
+	Field string
 }
 
 type storageErrorType int8
@@ -65,6 +219,7 @@ const (
 	storageErrorType_NOT_FOUND        = 2
 	storageErrorType_NO_UPDATE        = 3
 	storageErrorType_INTERNAL         = 4
+	storageErrorType_CONFLICT         = 5
 )
 
 func (set storageErrorType) String() string {
@@ -77,6 +232,8 @@ func (set storageErrorType) String() string {
 		return "NO_UPDATE"
 	case storageErrorType_INTERNAL:
 		return "INTERNAL"
+	case storageErrorType_CONFLICT:
+		return "CONFLICT"
 	default:
 		return "UNKNOWN"
 	}
@@ -92,11 +249,30 @@ func (set storageErrorType) HttpStatus() int {
 		return http.StatusBadRequest
 	case storageErrorType_INTERNAL:
 		return http.StatusInternalServerError
+	case storageErrorType_CONFLICT:
+		return http.StatusConflict
 	default:
 		return http.StatusInternalServerError
 	}
 }
 
+func (set storageErrorType) ApiErrorCode() statspb.ApiError_Code {
+	switch set {
+	case storageErrorType_INVALID_ARGUMENT:
+		return statspb.ApiError_INVALID_ARGUMENT
+	case storageErrorType_NOT_FOUND:
+		return statspb.ApiError_NOT_FOUND
+	case storageErrorType_NO_UPDATE:
+		return statspb.ApiError_NO_UPDATE
+	case storageErrorType_INTERNAL:
+		return statspb.ApiError_INTERNAL
+	case storageErrorType_CONFLICT:
+		return statspb.ApiError_CONFLICT
+	default:
+		return statspb.ApiError_UNKNOWN
+	}
+}
+
 func (se *storageError) Error() string {
 	return fmt.Sprintf("storage: type=%s, error=%v; %s", se.Type, se.Err, se.Message)
 }
@@ -105,6 +281,11 @@ func NewErrorInvalidArgument(err error, format string, args ...any) error {
 	return &storageError{Err: err, Message: fmt.Sprintf(format, args...), Type: storageErrorType_INVALID_ARGUMENT}
 }
 
+// NewErrorInvalidField returns an INVALID_ARGUMENT error of the given field of the request.
+func NewErrorInvalidField(field string, err error, format string, args ...any) error {
+	return &storageError{Err: err, Message: fmt.Sprintf(format, args...), Type: storageErrorType_INVALID_ARGUMENT, Field: field}
+}
+
 func NewErrorNotFound(err error, format string, args ...any) error {
 	return &storageError{Err: err, Message: fmt.Sprintf(format, args...), Type: storageErrorType_NOT_FOUND}
 }
@@ -117,6 +298,10 @@ func NewErrorInternal(err error, format string, args ...any) error {
 	return &storageError{Err: err, Message: fmt.Sprintf(format, args...), Type: storageErrorType_INTERNAL}
 }
 
+func NewErrorConflict(err error, format string, args ...any) error {
+	return &storageError{Err: err, Message: fmt.Sprintf(format, args...), Type: storageErrorType_CONFLICT}
+}
+
 func ToHttpError(err error) (code int, msg string, wrappedErr error) {
 	if se, ok := err.(*storageError); ok {
 		code, msg, wrappedErr = se.Type.HttpStatus(), se.Message, se.Err
@@ -125,3 +310,18 @@ func ToHttpError(err error) (code int, msg string, wrappedErr error) {
 	}
 	return
 }
+
+// ToApiError converts err to the ApiError with the code and message of its storageErrorType, and the http
+// status of its type. internalErr is the wrapped error of err, which is meant for the logs only. Errors that
+// are not storage errors are INTERNAL, and they're returned as internalErr.
+func ToApiError(err error) (code int, apiErr *statspb.ApiError, internalErr error) {
+	se, ok := err.(*storageError)
+	if !ok {
+		return http.StatusInternalServerError, &statspb.ApiError{Code: statspb.ApiError_INTERNAL, Message: "internal error"}, err
+	}
+	apiErr = &statspb.ApiError{Code: se.Type.ApiErrorCode(), Message: se.Message}
+	if se.Field != "" {
+		apiErr.FieldViolations = []*statspb.ApiError_FieldViolation{{Field: se.Field, Description: se.Message}}
+	}
+	return se.Type.HttpStatus(), apiErr, se.Err
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_ToApiError(t *testing.T) {
+	internal := errors.New("connection refused")
+	cases := []struct {
+		name         string
+		err          error
+		wantStatus   int
+		wantApiError *statspb.ApiError
+		wantInternal error
+	}{
+		{
+			name:         "not found",
+			err:          NewErrorNotFound(internal, "statistic not found"),
+			wantStatus:   http.StatusNotFound,
+			wantApiError: &statspb.ApiError{Code: statspb.ApiError_NOT_FOUND, Message: "statistic not found"},
+			wantInternal: internal,
+		},
+		{
+			name:       "invalid field",
+			err:        NewErrorInvalidField("page_size", nil, "page_size cannot be negative"),
+			wantStatus: http.StatusBadRequest,
+			wantApiError: &statspb.ApiError{
+				Code:    statspb.ApiError_INVALID_ARGUMENT,
+				Message: "page_size cannot be negative",
+				FieldViolations: []*statspb.ApiError_FieldViolation{
+					{Field: "page_size", Description: "page_size cannot be negative"},
+				},
+			},
+		},
+		{
+			name:         "conflict",
+			err:          NewErrorConflict(nil, "statistic version is not 2"),
+			wantStatus:   http.StatusConflict,
+			wantApiError: &statspb.ApiError{Code: statspb.ApiError_CONFLICT, Message: "statistic version is not 2"},
+		},
+		{
+			name:         "not a storage error",
+			err:          internal,
+			wantStatus:   http.StatusInternalServerError,
+			wantApiError: &statspb.ApiError{Code: statspb.ApiError_INTERNAL, Message: "internal error"},
+			wantInternal: internal,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status, apiErr, internalErr := ToApiError(c.err)
+			if status != c.wantStatus {
+				t.Fatalf("wrong status: expected=%d, got=%d", c.wantStatus, status)
+			}
+			if !proto.Equal(apiErr, c.wantApiError) {
+				t.Fatalf("wrong api error: expected=%v, got=%v", c.wantApiError, apiErr)
+			}
+			if internalErr != c.wantInternal {
+				t.Fatalf("wrong internal error: expected=%v, got=%v", c.wantInternal, internalErr)
+			}
+		})
+	}
+}
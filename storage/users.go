@@ -0,0 +1,351 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/umutozd/stats-keeper/protos/statspb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	usersCollectionName    = "Users"
+	sessionsCollectionName = "Sessions"
+)
+
+// UserStorage keeps the user accounts and their login sessions. Passwords and session tokens are hashed by
+// the caller, the storage never sees them.
+type UserStorage interface {
+	// CreateUser creates a user with the given username and password hash, and returns it. Usernames are case
+	// insensitive and are stored in lower case. If the username is invalid, an INVALID_ARGUMENT error is
+	// returned. If it's taken, a CONFLICT error is returned.
+	CreateUser(ctx context.Context, username string, passwordHash []byte) (*statspb.User, error)
+
+	// GetUserCredentials returns the user with the given username along with its password hash and lockout
+	// state. If there is no such user, a NOT_FOUND error is returned.
+	GetUserCredentials(ctx context.Context, username string) (*UserCredentials, error)
+
+	// RecordLoginFailure atomically increments the number of consecutive failed logins of the user specified
+	// by userId. When it reaches maxFailures, the user is locked until lockout passes and the number is reset.
+	// If there is no such user, a NOT_FOUND error is returned.
+	RecordLoginFailure(ctx context.Context, userId string, maxFailures int, lockout time.Duration) error
+
+	// RecordLoginSuccess resets the number of consecutive failed logins of the user specified by userId. If
+	// there is no such user, a NOT_FOUND error is returned.
+	RecordLoginSuccess(ctx context.Context, userId string) error
+
+	// CreateSession stores a session of the user specified by userId that is identified by tokenHash and
+	// expires at expiresAt. The expired sessions of the user are removed.
+	CreateSession(ctx context.Context, userId, tokenHash string, expiresAt time.Time) error
+
+	// GetSession returns the id of the user of the session identified by tokenHash. If there is no such session
+	// or it's expired, a NOT_FOUND error is returned.
+	GetSession(ctx context.Context, tokenHash string) (string, error)
+
+	// DeleteSession removes the session identified by tokenHash. If there is no such session, a NOT_FOUND error
+	// is returned.
+	DeleteSession(ctx context.Context, tokenHash string) error
+}
+
+// UserCredentials is a user along with the data that it logs in with.
+type UserCredentials struct {
+	User         *statspb.User
+	PasswordHash []byte
+	// LockedUntil is the time until which the user cannot log in, or zero if it's not locked.
+	LockedUntil time.Time
+}
+
+// userEntity is the internal representation of a user. Its id in the database is its username, which makes
+// usernames unique.
+type userEntity struct {
+	Username     string    `bson:"_id"`
+	Id           string    `bson:"user_id"`
+	PasswordHash []byte    `bson:"password_hash"`
+	CreatedAt    time.Time `bson:"created_at"`
+	FailedLogins int       `bson:"failed_logins"`
+	LockedUntil  time.Time `bson:"locked_until,omitempty"`
+}
+
+// sessionEntity is the internal representation of a session.
+type sessionEntity struct {
+	TokenHash string    `bson:"_id"`
+	UserId    string    `bson:"user_id"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// usernamePattern matches the valid usernames, after they're converted to lower case.
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,63}$`)
+
+// newUserEntity returns a new user with the given username and password hash. It returns an INVALID_ARGUMENT
+// error if username is invalid.
+func newUserEntity(username string, passwordHash []byte) (*userEntity, error) {
+	username = strings.ToLower(username)
+	if !usernamePattern.MatchString(username) {
+		return nil, NewErrorInvalidField("username", nil, "username must be 3 to 64 letters, digits, '.', '_' or '-', starting with a letter or digit")
+	}
+	if len(passwordHash) == 0 {
+		return nil, NewErrorInvalidArgument(nil, "password hash cannot be empty")
+	}
+	return &userEntity{
+		Username:     username,
+		Id:           primitive.NewObjectID().Hex(),
+		PasswordHash: passwordHash,
+		CreatedAt:    now(),
+	}, nil
+}
+
+// toPB converts this userEntity to *statspb.User.
+func (ue *userEntity) toPB() *statspb.User {
+	return &statspb.User{
+		Id:        ue.Id,
+		Username:  ue.Username,
+		CreatedAt: timestampOf(ue.CreatedAt),
+	}
+}
+
+// credentials returns the UserCredentials of this userEntity.
+func (ue *userEntity) credentials() *UserCredentials {
+	return &UserCredentials{
+		User:         ue.toPB(),
+		PasswordHash: append([]byte{}, ue.PasswordHash...),
+		LockedUntil:  ue.LockedUntil,
+	}
+}
+
+// recordLoginFailure updates this userEntity as described in UserStorage.RecordLoginFailure.
+func (ue *userEntity) recordLoginFailure(maxFailures int, lockout time.Duration) {
+	ue.FailedLogins++
+	if ue.FailedLogins >= maxFailures {
+		ue.FailedLogins = 0
+		ue.LockedUntil = now().Add(lockout)
+	}
+}
+
+// users returns a handle to the users collection in MongoDB
+func (s *storage) users() *mongo.Collection {
+	return s.cli.Database(defaultDatabaseName).Collection(usersCollectionName)
+}
+
+// sessions returns a handle to the sessions collection in MongoDB
+func (s *storage) sessions() *mongo.Collection {
+	return s.cli.Database(defaultDatabaseName).Collection(sessionsCollectionName)
+}
+
+// createUserIndexes creates the indexes of the users and the sessions by their user ids, which the logins look
+// them up with. Creating an index that exists does nothing.
+func (s *storage) createUserIndexes(ctx context.Context) error {
+	if _, err := s.users().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return NewErrorInternal(err, "error creating index of users")
+	}
+	if _, err := s.sessions().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	}); err != nil {
+		return NewErrorInternal(err, "error creating index of sessions")
+	}
+	return nil
+}
+
+func (s *storage) CreateUser(ctx context.Context, username string, passwordHash []byte) (*statspb.User, error) {
+	ue, err := newUserEntity(username, passwordHash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.users().InsertOne(ctx, ue); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, NewErrorConflict(nil, "username is taken")
+		}
+		return nil, NewErrorInternal(err, "error creating user")
+	}
+	return ue.toPB(), nil
+}
+
+func (s *storage) GetUserCredentials(ctx context.Context, username string) (*UserCredentials, error) {
+	ue := &userEntity{}
+	if err := s.users().FindOne(ctx, bson.M{"_id": strings.ToLower(username)}).Decode(ue); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, NewErrorNotFound(nil, "user not found")
+		}
+		return nil, NewErrorInternal(err, "error getting user from database")
+	}
+	return ue.credentials(), nil
+}
+
+func (s *storage) RecordLoginFailure(ctx context.Context, userId string, maxFailures int, lockout time.Duration) error {
+	// the counter is incremented and reset in a single update, so that concurrent failures are all counted
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"failed_logins": bson.M{"$add": bson.A{"$failed_logins", 1}}}}}, {{Key: "$set", Value: bson.M{
+		"locked_until": bson.M{"$cond": bson.A{
+			bson.M{"$gte": bson.A{"$failed_logins", maxFailures}},
+			bson.M{"$literal": now().Add(lockout)},
+			"$locked_until",
+		}},
+		"failed_logins": bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$failed_logins", maxFailures}}, 0, "$failed_logins"}},
+	}}}}
+	res, err := s.users().UpdateOne(ctx, bson.M{"user_id": userId}, update)
+	if err != nil {
+		return NewErrorInternal(err, "error recording login failure")
+	}
+	if res.MatchedCount == 0 {
+		return NewErrorNotFound(nil, "user not found")
+	}
+	return nil
+}
+
+func (s *storage) RecordLoginSuccess(ctx context.Context, userId string) error {
+	res, err := s.users().UpdateOne(ctx, bson.M{"user_id": userId}, bson.M{"$set": bson.M{"failed_logins": 0}})
+	if err != nil {
+		return NewErrorInternal(err, "error recording login success")
+	}
+	if res.MatchedCount == 0 {
+		return NewErrorNotFound(nil, "user not found")
+	}
+	return nil
+}
+
+func (s *storage) CreateSession(ctx context.Context, userId, tokenHash string, expiresAt time.Time) error {
+	if _, err := s.sessions().InsertOne(ctx, &sessionEntity{TokenHash: tokenHash, UserId: userId, ExpiresAt: expiresAt}); err != nil {
+		return NewErrorInternal(err, "error creating session")
+	}
+	filter := bson.M{"user_id": userId, "expires_at": bson.M{"$lte": now()}}
+	if _, err := s.sessions().DeleteMany(ctx, filter); err != nil {
+		return NewErrorInternal(err, "error removing expired sessions")
+	}
+	return nil
+}
+
+func (s *storage) GetSession(ctx context.Context, tokenHash string) (string, error) {
+	se := &sessionEntity{}
+	filter := bson.M{"_id": tokenHash, "expires_at": bson.M{"$gt": now()}}
+	if err := s.sessions().FindOne(ctx, filter).Decode(se); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", NewErrorNotFound(nil, "session not found")
+		}
+		return "", NewErrorInternal(err, "error getting session from database")
+	}
+	return se.UserId, nil
+}
+
+func (s *storage) DeleteSession(ctx context.Context, tokenHash string) error {
+	res, err := s.sessions().DeleteOne(ctx, bson.M{"_id": tokenHash})
+	if err != nil {
+		return NewErrorInternal(err, "error deleting session")
+	}
+	if res.DeletedCount == 0 {
+		return NewErrorNotFound(nil, "session not found")
+	}
+	return nil
+}
+
+// findUser returns the stored user specified by userId. The caller must hold the lock.
+func (s *memoryStorage) findUser(userId string) (*userEntity, bool) {
+	for _, ue := range s.users {
+		if ue.Id == userId {
+			return ue, true
+		}
+	}
+	return nil, false
+}
+
+func (s *memoryStorage) CreateUser(ctx context.Context, username string, passwordHash []byte) (*statspb.User, error) {
+	ue, err := newUserEntity(username, passwordHash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[ue.Username]; ok {
+		return nil, NewErrorConflict(nil, "username is taken")
+	}
+	if err = putJournaled(s, usersCollectionName, s.users, ue.Username, ue); err != nil {
+		return nil, err
+	}
+	return ue.toPB(), nil
+}
+
+func (s *memoryStorage) GetUserCredentials(ctx context.Context, username string) (*UserCredentials, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ue, ok := s.users[strings.ToLower(username)]
+	if !ok {
+		return nil, NewErrorNotFound(nil, "user not found")
+	}
+	return ue.credentials(), nil
+}
+
+func (s *memoryStorage) RecordLoginFailure(ctx context.Context, userId string, maxFailures int, lockout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.findUser(userId)
+	if !ok {
+		return NewErrorNotFound(nil, "user not found")
+	}
+	ue := *stored
+	ue.recordLoginFailure(maxFailures, lockout)
+	return putJournaled(s, usersCollectionName, s.users, ue.Username, &ue)
+}
+
+func (s *memoryStorage) RecordLoginSuccess(ctx context.Context, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.findUser(userId)
+	if !ok {
+		return NewErrorNotFound(nil, "user not found")
+	}
+	if stored.FailedLogins == 0 {
+		return nil
+	}
+	ue := *stored
+	ue.FailedLogins = 0
+	return putJournaled(s, usersCollectionName, s.users, ue.Username, &ue)
+}
+
+func (s *memoryStorage) CreateSession(ctx context.Context, userId, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := putJournaled(s, sessionsCollectionName, s.sessions, tokenHash, &sessionEntity{TokenHash: tokenHash, UserId: userId, ExpiresAt: expiresAt}); err != nil {
+		return err
+	}
+	now := now()
+	for _, se := range s.sessions {
+		if se.UserId == userId && !se.ExpiresAt.After(now) {
+			if err := removeJournaled(s, sessionsCollectionName, s.sessions, se.TokenHash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) GetSession(ctx context.Context, tokenHash string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	se, ok := s.sessions[tokenHash]
+	if !ok || !se.ExpiresAt.After(now()) {
+		return "", NewErrorNotFound(nil, "session not found")
+	}
+	return se.UserId, nil
+}
+
+func (s *memoryStorage) DeleteSession(ctx context.Context, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[tokenHash]; !ok {
+		return NewErrorNotFound(nil, "session not found")
+	}
+	return removeJournaled(s, sessionsCollectionName, s.sessions, tokenHash)
+}